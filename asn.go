@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// mmdbMetadataMarker precedes the metadata map at the end of a MaxMind DB file.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// mmdbMetadataWindow is how far from the end of the file the metadata is looked for.
+const mmdbMetadataWindow = 128 * 1024
+
+// mmdbDataSeparator is the run of zero bytes between the search tree and the data section.
+const mmdbDataSeparator = 16
+
+// errBadMMDB reports a database that does not follow the MaxMind DB format, and
+// errShortMMDB data that runs past the end of its section.
+var (
+	errBadMMDB   = errors.New("invalid MaxMind DB")
+	errShortMMDB = errors.New("data runs past the end of the database")
+)
+
+// asnDB is a MaxMind DB, such as GeoLite2-ASN, read by -asn-db to tell which network a
+// CNAME target's addresses belong to. The whole file is held in memory. The format is a
+// binary search tree over the address bits whose leaves point into a section of typed
+// data; see https://maxmind.github.io/MaxMind-DB/.
+type asnDB struct {
+	buf        []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// ipv4Start is the node for ::/96, under which an IPv6 tree keeps IPv4 addresses.
+	ipv4Start uint
+}
+
+// openASNDB reads the MaxMind DB at path.
+func openASNDB(path string) (*asnDB, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	db, err := parseASNDB(buf)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return db, nil
+}
+
+// parseASNDB parses a MaxMind DB held in buf.
+func parseASNDB(buf []byte) (*asnDB, error) {
+	window := buf[max(0, len(buf)-mmdbMetadataWindow):]
+	at := bytes.LastIndex(window, mmdbMetadataMarker)
+	if at < 0 {
+		return nil, fmt.Errorf("%v: no metadata", errBadMMDB)
+	}
+	metaStart := len(buf) - len(window) + at + len(mmdbMetadataMarker)
+	meta, _, err := decodeMMDB(buf[metaStart:], 0)
+	if err != nil {
+		return nil, fmt.Errorf("%v: metadata: %v", errBadMMDB, err)
+	}
+	fields, ok := meta.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%v: metadata is not a map", errBadMMDB)
+	}
+	nodeCount, _ := fields["node_count"].(uint64)
+	recordSize, _ := fields["record_size"].(uint64)
+	ipVersion, _ := fields["ip_version"].(uint64)
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 {
+		return nil, fmt.Errorf("%v: unsupported record size %d", errBadMMDB, recordSize)
+	}
+	if ipVersion != 4 && ipVersion != 6 {
+		return nil, fmt.Errorf("%v: unsupported IP version %d", errBadMMDB, ipVersion)
+	}
+
+	treeSize := nodeCount * recordSize / 4
+	if nodeCount > uint64(metaStart) || treeSize+mmdbDataSeparator > uint64(metaStart) {
+		return nil, fmt.Errorf("%v: search tree of %d nodes does not fit", errBadMMDB, nodeCount)
+	}
+	db := &asnDB{
+		buf:        buf,
+		data:       buf[treeSize+mmdbDataSeparator : metaStart-len(mmdbMetadataMarker)],
+		nodeCount:  uint(nodeCount),
+		recordSize: uint(recordSize),
+		ipVersion:  uint(ipVersion),
+	}
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (db *asnDB) record(node, bit uint) uint {
+	b := db.buf[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// lookup returns the autonomous system number and organization of ip. Ok is false when
+// the database has no network for ip.
+func (db *asnDB) lookup(ip net.IP) (uint32, string, bool) {
+	node := uint(0)
+	addr := ip.To16()
+	if v4 := ip.To4(); v4 != nil {
+		addr, node = v4, db.ipv4Start
+	} else if db.ipVersion == 4 {
+		return 0, "", false
+	}
+
+	for i := 0; i < len(addr)*8 && node < db.nodeCount; i++ {
+		node = db.record(node, uint(addr[i/8]>>(7-i%8))&1)
+	}
+	if node <= db.nodeCount {
+		return 0, "", false // not found, or the address ran out inside the tree
+	}
+
+	value, _, err := decodeMMDB(db.data, int(node-db.nodeCount-mmdbDataSeparator))
+	if err != nil {
+		return 0, "", false
+	}
+	fields, ok := value.(map[string]any)
+	if !ok {
+		return 0, "", false
+	}
+	asn, _ := fields["autonomous_system_number"].(uint64)
+	org, _ := fields["autonomous_system_organization"].(string)
+	if asn == 0 && org == "" {
+		return 0, "", false
+	}
+	return uint32(asn), org, true
+}
+
+// MaxMind DB data types.
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBoolean
+	mmdbFloat
+)
+
+// mmdbMaxDepth bounds the nesting of maps and arrays, against malformed files.
+const mmdbMaxDepth = 32
+
+// decodeMMDB decodes the value at off in section and returns it with the offset after it.
+// Maps decode to map[string]any, arrays to []any, integers to uint64 (int32 to int64),
+// and uint128 values to their big-endian bytes.
+func decodeMMDB(section []byte, off int) (any, int, error) {
+	return decodeMMDBValue(section, off, 0)
+}
+
+// decodeMMDBValue is decodeMMDB for a value nested depth maps, arrays or pointers deep.
+func decodeMMDBValue(section []byte, off, depth int) (any, int, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+	next := func(n int) ([]byte, error) {
+		if off < 0 || n < 0 || off+n > len(section) {
+			return nil, errShortMMDB
+		}
+		b := section[off : off+n]
+		off += n
+		return b, nil
+	}
+
+	b, err := next(1)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctrl := b[0]
+	kind := int(ctrl >> 5)
+
+	if kind == mmdbPointer {
+		extra := int(ctrl>>3) & 0x3
+		b, err := next(extra + 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		pointer := 0
+		if extra < 3 {
+			pointer = int(ctrl & 0x7)
+		}
+		for _, c := range b {
+			pointer = pointer<<8 | int(c)
+		}
+		pointer += [...]int{0, 2048, 526336, 0}[extra]
+		value, _, err := decodeMMDBValue(section, pointer, depth+1)
+		return value, off, err
+	}
+
+	if kind == mmdbExtended {
+		b, err := next(1)
+		if err != nil {
+			return nil, 0, err
+		}
+		kind = 7 + int(b[0])
+	}
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		b, err := next(size - 28)
+		if err != nil {
+			return nil, 0, err
+		}
+		n := 0
+		for _, c := range b {
+			n = n<<8 | int(c)
+		}
+		size = [...]int{29, 285, 65821}[size-29] + n
+	}
+
+	// unsigned reads a big-endian integer of size bytes
+	unsigned := func(limit int) (uint64, error) {
+		if size > limit {
+			return 0, fmt.Errorf("integer of %d bytes", size)
+		}
+		b, err := next(size)
+		if err != nil {
+			return 0, err
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, nil
+	}
+
+	switch kind {
+	case mmdbString:
+		b, err := next(size)
+		return string(b), off, err
+	case mmdbBytes, mmdbUint128:
+		b, err := next(size)
+		return append([]byte(nil), b...), off, err
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("double of %d bytes", size)
+		}
+		b, err := next(8)
+		if err != nil {
+			return nil, 0, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), off, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("float of %d bytes", size)
+		}
+		b, err := next(4)
+		if err != nil {
+			return nil, 0, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), off, nil
+	case mmdbUint16:
+		n, err := unsigned(2)
+		return n, off, err
+	case mmdbUint32:
+		n, err := unsigned(4)
+		return n, off, err
+	case mmdbUint64:
+		n, err := unsigned(8)
+		return n, off, err
+	case mmdbInt32:
+		n, err := unsigned(4)
+		return int64(int32(uint32(n))), off, err
+	case mmdbBoolean:
+		return size != 0, off, nil
+	case mmdbMap:
+		fields := make(map[string]any, min(size, 64))
+		for i := 0; i < size; i++ {
+			key, after, err := decodeMMDBValue(section, off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			if fields[name], off, err = decodeMMDBValue(section, after, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return fields, off, nil
+	case mmdbArray:
+		values := make([]any, 0, min(size, 64))
+		for i := 0; i < size; i++ {
+			var value any
+			if value, off, err = decodeMMDBValue(section, off, depth+1); err != nil {
+				return nil, 0, err
+			}
+			values = append(values, value)
+		}
+		return values, off, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", kind)
+}
+
+// targetNetwork looks up the addresses subdomain's CNAME chain resolves to in db and
+// returns the autonomous system of the first one it lists. An address lookup that fails
+// or finds nothing in db leaves the record unenriched.
+func targetNetwork(ctx context.Context, subdomain string, resolver Resolver, db *asnDB) (uint32, string) {
+	answer, err := resolver.LookupAddrs(ctx, subdomain)
+	if err != nil {
+		return 0, ""
+	}
+	for _, addr := range answer.Addrs {
+		if ip := net.ParseIP(addr); ip != nil {
+			if asn, org, ok := db.lookup(ip); ok {
+				return asn, org
+			}
+		}
+	}
+	return 0, ""
+}
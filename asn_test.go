@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// mmdbStr, mmdbUint and mmdbMapOf encode MaxMind DB values for hand-built test databases.
+func mmdbStr(s string) string {
+	if len(s) >= 29 {
+		return string([]byte{0x40 | 29, byte(len(s) - 29)}) + s
+	}
+	return string([]byte{0x40 | byte(len(s))}) + s
+}
+
+func mmdbUint(kind byte, n uint32) string {
+	if kind == mmdbUint16 {
+		return string([]byte{kind<<5 | 2, byte(n >> 8), byte(n)})
+	}
+	return string([]byte{kind<<5 | 4, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+}
+
+func mmdbMapOf(pairs ...string) string {
+	return string([]byte{0xE0 | byte(len(pairs)/2)}) + strings.Join(pairs, "")
+}
+
+// testMMDB builds an IPv4 database of one node, 24-bit records: 0.0.0.0/1 holds data and
+// 128.0.0.0/1 is empty.
+func testMMDB(data, meta string) []byte {
+	tree := "\x00\x00\x11" + "\x00\x00\x01" // left: data offset 0, right: node count (empty)
+	return []byte(tree + strings.Repeat("\x00", mmdbDataSeparator) + data + string(mmdbMetadataMarker) + meta)
+}
+
+// testMMDBMeta is the metadata of testMMDB.
+var testMMDBMeta = mmdbMapOf(
+	mmdbStr("node_count"), mmdbUint(mmdbUint32, 1),
+	mmdbStr("record_size"), mmdbUint(mmdbUint16, 24),
+	mmdbStr("ip_version"), mmdbUint(mmdbUint16, 4),
+)
+
+// testMMDBData is the record every address in 0.0.0.0/1 maps to.
+var testMMDBData = mmdbMapOf(
+	mmdbStr("autonomous_system_number"), mmdbUint(mmdbUint32, 64500),
+	mmdbStr("autonomous_system_organization"), mmdbStr("Example Networks"),
+)
+
+func TestASNDBLookup(t *testing.T) {
+	db, err := parseASNDB(testMMDB(testMMDBData, testMMDBMeta))
+	if err != nil {
+		t.Fatalf("parseASNDB: %v", err)
+	}
+	asn, org, ok := db.lookup(net.ParseIP("93.184.216.34"))
+	if !ok || asn != 64500 || org != "Example Networks" {
+		t.Errorf("lookup(93.184.216.34) = %d, %q, %v, want 64500, \"Example Networks\", true", asn, org, ok)
+	}
+	if _, _, ok := db.lookup(net.ParseIP("203.0.113.1")); ok {
+		t.Error("lookup(203.0.113.1) found a network in the empty half of the tree")
+	}
+	if _, _, ok := db.lookup(net.ParseIP("2001:db8::1")); ok {
+		t.Error("lookup(2001:db8::1) found a network in an IPv4 database")
+	}
+}
+
+func TestParseASNDBRejectsMalformedFiles(t *testing.T) {
+	good := testMMDB(testMMDBData, testMMDBMeta)
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{"empty", nil},
+		{"no metadata", good[:len(good)-len(testMMDBMeta)-len(mmdbMetadataMarker)]},
+		{"truncated metadata", good[:len(good)-3]},
+		{"metadata not a map", testMMDB(testMMDBData, mmdbStr("node_count"))},
+		{"metadata pointer out of range", testMMDB(testMMDBData, "\x27\xff")},
+		{"record size", testMMDB(testMMDBData, mmdbMapOf(
+			mmdbStr("node_count"), mmdbUint(mmdbUint32, 1),
+			mmdbStr("record_size"), mmdbUint(mmdbUint16, 20),
+			mmdbStr("ip_version"), mmdbUint(mmdbUint16, 4),
+		))},
+		{"tree past the data", testMMDB(testMMDBData, mmdbMapOf(
+			mmdbStr("node_count"), mmdbUint(mmdbUint32, 1000),
+			mmdbStr("record_size"), mmdbUint(mmdbUint16, 24),
+			mmdbStr("ip_version"), mmdbUint(mmdbUint16, 4),
+		))},
+		{"node count overflows", testMMDB(testMMDBData, mmdbMapOf(
+			mmdbStr("node_count"), "\x08\x02"+"\x40\x00\x00\x00\x00\x00\x00\x01", // uint64 2^62 + 1
+			mmdbStr("record_size"), mmdbUint(mmdbUint16, 32),
+			mmdbStr("ip_version"), mmdbUint(mmdbUint16, 4),
+		))},
+	}
+	for _, tt := range tests {
+		if db, err := parseASNDB(tt.buf); err == nil {
+			t.Errorf("%s: parseASNDB = %+v, want an error", tt.name, db)
+		}
+	}
+}
+
+func TestASNDBLookupSkipsMalformedData(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"truncated string", testMMDBData[:len(testMMDBData)-4]},
+		{"pointer out of range", "\x20\xff"},
+		{"pointer loop", "\x20\x00"},
+		{"not a map", mmdbStr("AS64500")},
+		{"oversized integer", mmdbMapOf(mmdbStr("autonomous_system_number"), "\xa5\x00\x00\x00\x00\x01")},
+	}
+	for _, tt := range tests {
+		db, err := parseASNDB(testMMDB(tt.data, testMMDBMeta))
+		if err != nil {
+			t.Fatalf("%s: parseASNDB: %v", tt.name, err)
+		}
+		if asn, org, ok := db.lookup(net.ParseIP("93.184.216.34")); ok {
+			t.Errorf("%s: lookup = %d, %q, want no network", tt.name, asn, org)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// maxRetryBackoff caps the -timeout-jitter backoff of lookup retries.
+const maxRetryBackoff = 2 * time.Second
+
+// maxBackoffDoublings bounds the exponent of the backoff, against overflow.
+const maxBackoffDoublings = 16
+
+// backoff spaces out retries with "full jitter": before retry n (counting from 0) it waits
+//
+//	random in [0, min(cap, base * 2^n))
+//
+// so retries of lookups that failed together, such as a burst of timeouts against a
+// flaky resolver, are spread over the window instead of arriving in step. A nil backoff,
+// or one with a zero base, retries at once.
+type backoff struct {
+	base time.Duration
+	cap  time.Duration
+	rng  interface{ Int64N(int64) int64 }
+}
+
+// newBackoff returns a backoff from base up to cap whose delays are drawn with seed.
+func newBackoff(base, cap time.Duration, seed uint64) *backoff {
+	return &backoff{base: base, cap: cap, rng: newRunRand(seed)}
+}
+
+// delay returns the random wait before retry n.
+func (b *backoff) delay(n int) time.Duration {
+	ceiling := min(b.cap, b.base<<min(n, maxBackoffDoublings))
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(b.rng.Int64N(int64(ceiling)))
+}
+
+// wait sleeps before retry n, returning early when ctx is done.
+func (b *backoff) wait(ctx context.Context, n int) {
+	if b == nil || b.base <= 0 {
+		return
+	}
+	timer := time.NewTimer(b.delay(n))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
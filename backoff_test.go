@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelayBounds(t *testing.T) {
+	const base, limit = 10 * time.Millisecond, 200 * time.Millisecond
+	b := newBackoff(base, limit, 42)
+	for n, ceiling := range []time.Duration{10, 20, 40, 80, 160, 200, 200} {
+		ceiling *= time.Millisecond
+		for i := 0; i < 100; i++ {
+			if d := b.delay(n); d < 0 || d >= ceiling {
+				t.Fatalf("delay(%d) = %v, want within [0, %v)", n, d, ceiling)
+			}
+		}
+	}
+	// A large retry count stays at the cap instead of overflowing
+	if d := b.delay(1000); d < 0 || d >= limit {
+		t.Errorf("delay(1000) = %v, want within [0, %v)", d, limit)
+	}
+}
+
+func TestBackoffSeedRepeats(t *testing.T) {
+	delays := func(seed uint64) []time.Duration {
+		b := newBackoff(time.Millisecond, maxRetryBackoff, seed)
+		var ds []time.Duration
+		for n := 0; n < 8; n++ {
+			ds = append(ds, b.delay(n))
+		}
+		return ds
+	}
+	first, again, other := delays(7), delays(7), delays(8)
+	for n := range first {
+		if first[n] != again[n] {
+			t.Fatalf("seed 7 gave %v, then %v", first, again)
+		}
+	}
+	same := true
+	for n := range first {
+		same = same && first[n] == other[n]
+	}
+	if same {
+		t.Errorf("seeds 7 and 8 gave the same delays %v", first)
+	}
+}
+
+func TestBackoffWithoutBaseRetriesAtOnce(t *testing.T) {
+	started := time.Now()
+	var none *backoff
+	none.wait(context.Background(), 3)
+	newBackoff(0, maxRetryBackoff, 1).wait(context.Background(), 3)
+	if elapsed := time.Since(started); elapsed > 50*time.Millisecond {
+		t.Errorf("waits without a base took %v", elapsed)
+	}
+
+	// A cancelled context cuts a wait short
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	started = time.Now()
+	newBackoff(time.Hour, time.Hour, 1).wait(ctx, 0)
+	if elapsed := time.Since(started); elapsed > 50*time.Millisecond {
+		t.Errorf("wait with a cancelled context took %v", elapsed)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// benchmarkSample is the most hosts -benchmark queries, spread over the input.
+const benchmarkSample = 100
+
+// benchmarkResult is what a -benchmark run measured.
+type benchmarkResult struct {
+	hosts    int
+	duration time.Duration
+	errors   int
+	// latencies holds the duration of every lookup, failed ones included, in order.
+	latencies []time.Duration
+}
+
+// sampleHosts returns at most n of hosts, evenly spaced so the sample covers the whole
+// input rather than its first part.
+func sampleHosts(hosts []string, n int) []string {
+	if len(hosts) <= n {
+		return hosts
+	}
+	sample := make([]string, n)
+	for i := range sample {
+		sample[i] = hosts[i*len(hosts)/n]
+	}
+	return sample
+}
+
+// runBenchmark looks hosts up through resolver over and over for duration, with
+// opts.Concurrency workers paced by opts.RateLimiter and each lookup bounded by
+// opts.Timeout, as a scan would, and measures how fast and how reliably it answers.
+// The resolver should not cache, or the repeated lookups measure only the cache.
+func runBenchmark(ctx context.Context, resolver Resolver, hosts []string, duration time.Duration, opts Options) benchmarkResult {
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	var next atomic.Int64
+	var mu sync.Mutex
+	result := benchmarkResult{hosts: len(hosts)}
+	var workers sync.WaitGroup
+	started := time.Now()
+	for i := 0; i < max(opts.Concurrency, 1); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for {
+				opts.RateLimiter.wait(ctx)
+				if ctx.Err() != nil {
+					return
+				}
+				host := hosts[int(next.Add(1)-1)%len(hosts)]
+				elapsed, err := benchmarkLookup(ctx, resolver, host, opts.Timeout)
+				if err != nil && ctx.Err() != nil {
+					return // cut short by the end of the run, not a failure
+				}
+				opts.RateLimiter.observe(err != nil)
+				mu.Lock()
+				result.latencies = append(result.latencies, elapsed)
+				if err != nil {
+					result.errors++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	workers.Wait()
+	result.duration = time.Since(started)
+	sort.Slice(result.latencies, func(i, j int) bool { return result.latencies[i] < result.latencies[j] })
+	return result
+}
+
+// benchmarkLookup times one lookup of host, the same query a scan sends for it.
+func benchmarkLookup(ctx context.Context, resolver Resolver, host string, timeout time.Duration) (time.Duration, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	started := time.Now()
+	var err error
+	if net.ParseIP(host) != nil {
+		_, err = resolver.LookupPTR(ctx, host)
+	} else {
+		_, err = resolver.LookupCNAME(ctx, host)
+	}
+	return time.Since(started), err
+}
+
+// percentile returns the latency below which a fraction p of the lookups finished.
+func (r benchmarkResult) percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	return r.latencies[min(int(p*float64(len(r.latencies))), len(r.latencies)-1)]
+}
+
+// write reports the result to w.
+func (r benchmarkResult) write(w io.Writer) error {
+	queries := len(r.latencies)
+	errorRate := 0.0
+	if queries > 0 {
+		errorRate = 100 * float64(r.errors) / float64(queries)
+	}
+	round := func(d time.Duration) time.Duration { return d.Round(10 * time.Microsecond) }
+	_, err := fmt.Fprintf(w, "hosts: %d\nqueries: %d in %v\nqps: %.1f\nlatency: p50 %v, p95 %v, p99 %v\nerrors: %d (%.1f%%)\n",
+		r.hosts, queries, r.duration.Round(time.Millisecond), float64(queries)/r.duration.Seconds(),
+		round(r.percentile(0.50)), round(r.percentile(0.95)), round(r.percentile(0.99)), r.errors, errorRate)
+	return err
+}
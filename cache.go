@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cachingResolver memoizes the answers of another Resolver for the duration of a scan,
+// so names shared by many hosts (typically the tail of a CDN CNAME chain) are queried
+// once. It is the one cache of a scan: chain following goes through it as a Resolver,
+// and -cross-check sends its zone discovery and authoritative queries through message.
+// It is safe for concurrent use: concurrent lookups of the same name wait for the first
+// one instead of querying again. Failed lookups are not cached, and an answer is dropped
+// once the least TTL among its records has passed, so long scans and watch cycles see
+// current data.
+type cachingResolver struct {
+	resolver Resolver
+	// overrides, when set, answer the names of -hosts-file without querying resolver.
+	overrides *hostOverrides
+
+	mu      sync.Mutex
+	entries map[cacheKey]*cacheEntry
+
+	lookups atomic.Int64
+	queries atomic.Int64
+	expired atomic.Int64
+}
+
+// cacheKey identifies a cached lookup by kind and normalized name.
+type cacheKey struct {
+	kind string
+	name string
+}
+
+// cacheEntry holds a lookup result; done is closed once it is available.
+type cacheEntry struct {
+	done    chan struct{}
+	answer  Answer
+	msg     dnsMessage
+	err     error
+	expires time.Time // zero when the result carries no TTL
+}
+
+// newCachingResolver returns a cachingResolver in front of resolver.
+func newCachingResolver(resolver Resolver) *cachingResolver {
+	return &cachingResolver{resolver: resolver, entries: make(map[cacheKey]*cacheEntry)}
+}
+
+// LookupCNAME returns the cached CNAME answer for name, looking it up on first use.
+func (r *cachingResolver) LookupCNAME(ctx context.Context, name string) (Answer, error) {
+	if r.overrides.has(name) {
+		return r.overrides.cname(name), nil
+	}
+	return r.lookup(ctx, cacheKey{"CNAME", normalizeHost(name)}, func() (Answer, error) {
+		return r.resolver.LookupCNAME(ctx, name)
+	})
+}
+
+// LookupPTR returns the cached PTR answer for ip, looking it up on first use.
+func (r *cachingResolver) LookupPTR(ctx context.Context, ip string) (Answer, error) {
+	if answer, ok := r.overrides.ptr(ip); ok {
+		return answer, nil
+	}
+	return r.lookup(ctx, cacheKey{"PTR", ip}, func() (Answer, error) {
+		return r.resolver.LookupPTR(ctx, ip)
+	})
+}
+
+// LookupAddrs returns the cached addresses of name, looking them up on first use.
+func (r *cachingResolver) LookupAddrs(ctx context.Context, name string) (Answer, error) {
+	if r.overrides.has(name) {
+		return r.overrides.lookupAddrs(ctx, name, r.LookupAddrs)
+	}
+	return r.lookup(ctx, cacheKey{"ADDRS", normalizeHost(name)}, func() (Answer, error) {
+		return r.resolver.LookupAddrs(ctx, name)
+	})
+}
+
+// message returns the cached response of server to a qtype question for name, sending
+// it with query on first use. Responses are cached per server, since an authoritative
+// nameserver and a recursive resolver may answer the same question differently.
+func (r *cachingResolver) message(ctx context.Context, server, name string, qtype uint16, query func() (dnsMessage, error)) (dnsMessage, error) {
+	key := cacheKey{typeName(qtype) + " " + server, normalizeHost(name)}
+	entry, err := r.fetch(ctx, key, func(entry *cacheEntry) {
+		entry.msg, entry.err = query()
+		entry.expires = expiry(entry.msg.Answers, entry.msg.Authorities, entry.msg.Additionals)
+		if entry.msg.RCode != rcodeSuccess && entry.msg.RCode != rcodeNameError {
+			entry.expires = time.Now() // a server failure is worth asking again
+		}
+	})
+	if err != nil {
+		return dnsMessage{}, err
+	}
+	return entry.msg, nil
+}
+
+// lookup returns the cached answer for key, running query if there is none yet.
+func (r *cachingResolver) lookup(ctx context.Context, key cacheKey, query func() (Answer, error)) (Answer, error) {
+	entry, err := r.fetch(ctx, key, func(entry *cacheEntry) {
+		entry.answer, entry.err = query()
+		entry.expires = expiry(entry.answer.Records, entry.answer.Authorities)
+	})
+	if err != nil {
+		return Answer{}, err
+	}
+	return entry.answer, nil
+}
+
+// fetch returns the entry for key, filling a new one with fill if there is none yet or
+// the cached one has expired. A failed fill is removed again so a later lookup retries it.
+func (r *cachingResolver) fetch(ctx context.Context, key cacheKey, fill func(*cacheEntry)) (*cacheEntry, error) {
+	r.lookups.Add(1)
+
+	r.mu.Lock()
+	entry, ok := r.entries[key]
+	if ok && entry.stale() {
+		r.expired.Add(1)
+		ok = false
+	}
+	if !ok {
+		entry = &cacheEntry{done: make(chan struct{})}
+		r.entries[key] = entry
+	}
+	r.mu.Unlock()
+
+	if ok {
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if entry.err == nil {
+			return entry, nil
+		}
+		// The first lookup failed (perhaps only its own deadline ran out); try again
+		r.lookups.Add(-1)
+		return r.fetch(ctx, key, fill)
+	}
+
+	r.queries.Add(1)
+	fill(entry)
+	if entry.err != nil {
+		r.mu.Lock()
+		if r.entries[key] == entry {
+			delete(r.entries, key)
+		}
+		r.mu.Unlock()
+	}
+	close(entry.done)
+	return entry, entry.err
+}
+
+// stale reports whether a completed entry has outlived its TTL. Entries still being
+// looked up are never stale, so concurrent lookups share them.
+func (e *cacheEntry) stale() bool {
+	select {
+	case <-e.done:
+		return !e.expires.IsZero() && time.Now().After(e.expires)
+	default:
+		return false
+	}
+}
+
+// expiry returns when a result made of the given records expires: after the least TTL
+// among them, or never (the zero time) when none carries one. Backends without TTLs,
+// such as dig +short captures, leave TTLs at zero, so zero TTLs are treated as missing
+// rather than as "do not cache".
+func expiry(sections ...[]ResourceRecord) time.Time {
+	var least uint32
+	for _, records := range sections {
+		for _, rr := range records {
+			if rr.Type != "OPT" && rr.TTL > 0 && (least == 0 || rr.TTL < least) {
+				least = rr.TTL
+			}
+		}
+	}
+	if least == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(least) * time.Second)
+}
+
+// reset empties the cache and its counters, so the next scan of a watch starts afresh.
+func (r *cachingResolver) reset() {
+	r.mu.Lock()
+	r.entries = make(map[cacheKey]*cacheEntry)
+	r.mu.Unlock()
+	r.lookups.Store(0)
+	r.queries.Store(0)
+	r.expired.Store(0)
+}
+
+// stats returns the number of lookups made, how many of them reached the backend, and
+// how many of those replaced an answer whose TTL had run out.
+func (r *cachingResolver) stats() (lookups, queries, expired int64) {
+	return r.lookups.Load(), r.queries.Load(), r.expired.Load()
+}
+
+// hitRate returns the percentage of lookups answered from the cache.
+func (r *cachingResolver) hitRate() float64 {
+	lookups, queries, _ := r.stats()
+	if lookups == 0 {
+		return 0
+	}
+	return 100 * float64(lookups-queries) / float64(lookups)
+}
+
+// logCacheStats logs how much DNS traffic the cache saved.
+func logCacheStats(cache *cachingResolver) {
+	lookups, queries, expired := cache.stats()
+	slog.Info("Cache statistics", "queries", queries, "lookups", lookups, "cached", lookups-queries, "hit_rate", math.Round(cache.hitRate()*10)/10, "expired", expired)
+}
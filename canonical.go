@@ -0,0 +1,120 @@
+package main
+
+import "strings"
+
+// canonicalOptions selects the transforms canonicalizeHost applies, each set by its own
+// flag.
+type canonicalOptions struct {
+	// StripScheme removes a URL scheme, user info, port, path, query and fragment, so
+	// "https://user@Example.com:8443/login?x" becomes "Example.com".
+	StripScheme bool
+	// Lowercase folds the host to lower case.
+	Lowercase bool
+	// StripTrailingDot removes the dot of a fully qualified name.
+	StripTrailingDot bool
+	// StripWWW removes a leading "www." label, unless only a single label would remain.
+	StripWWW bool
+}
+
+// enabled reports whether any transform is selected.
+func (o canonicalOptions) enabled() bool {
+	return o.StripScheme || o.Lowercase || o.StripTrailingDot || o.StripWWW
+}
+
+// canonicalizeHost applies the selected transforms to an input line, in a fixed order:
+// scheme and path first, then case, the trailing dot and the www label.
+func canonicalizeHost(host string, o canonicalOptions) string {
+	host = strings.TrimSpace(host)
+	if o.StripScheme {
+		host = stripURL(host)
+	}
+	if o.Lowercase {
+		host = strings.ToLower(host)
+	}
+	if o.StripTrailingDot {
+		host = strings.TrimSuffix(host, ".")
+	}
+	if o.StripWWW && len(host) > 4 && strings.EqualFold(host[:4], "www.") && strings.Contains(host[4:], ".") {
+		host = host[4:]
+	}
+	return host
+}
+
+// stripURL returns the host part of a URL-like line. Lines that are already plain host
+// names come back unchanged.
+func stripURL(line string) string {
+	if _, rest, ok := strings.Cut(line, "://"); ok {
+		line = rest
+	}
+	if i := strings.IndexAny(line, "/?#"); i >= 0 {
+		line = line[:i]
+	}
+	if i := strings.LastIndex(line, "@"); i >= 0 {
+		line = line[i+1:]
+	}
+
+	// Drop a port, keeping bracketed IPv6 literals and bare IPv6 addresses intact
+	if strings.HasPrefix(line, "[") {
+		if end := strings.Index(line, "]"); end > 0 {
+			return line[1:end]
+		}
+		return line
+	}
+	if host, port, ok := strings.Cut(line, ":"); ok && !strings.Contains(port, ":") && isDigits(port) {
+		return host
+	}
+	return line
+}
+
+// isDigits reports whether s is a non-empty run of ASCII digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// hostCanonicalizer canonicalizes subdomains and drops the ones that were already seen,
+// so aliases of one host are looked up and reported once. It is not safe for concurrent use.
+type hostCanonicalizer struct {
+	opts    canonicalOptions
+	seen    map[string]bool
+	dropped int
+}
+
+// newHostCanonicalizer returns a hostCanonicalizer applying opts.
+func newHostCanonicalizer(opts canonicalOptions) *hostCanonicalizer {
+	return &hostCanonicalizer{opts: opts, seen: make(map[string]bool)}
+}
+
+// add canonicalizes subdomain and reports whether it is new. Names are compared as
+// normalizeHost compares them, whatever the transforms.
+func (c *hostCanonicalizer) add(subdomain string) (string, bool) {
+	subdomain = canonicalizeHost(subdomain, c.opts)
+	key := normalizeHost(subdomain)
+	if key == "" {
+		return "", false
+	}
+	if c.seen[key] {
+		c.dropped++
+		return "", false
+	}
+	c.seen[key] = true
+	return subdomain, true
+}
+
+// filter canonicalizes subdomains, keeping the first of each set of duplicates.
+func (c *hostCanonicalizer) filter(subdomains []string) []string {
+	kept := subdomains[:0]
+	for _, subdomain := range subdomains {
+		if canonical, ok := c.add(subdomain); ok {
+			kept = append(kept, canonical)
+		}
+	}
+	return kept
+}
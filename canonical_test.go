@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCanonicalizeHost(t *testing.T) {
+	all := canonicalOptions{StripScheme: true, Lowercase: true, StripTrailingDot: true, StripWWW: true}
+	tests := []struct {
+		host string
+		opts canonicalOptions
+		want string
+	}{
+		{"www.example.com", canonicalOptions{StripWWW: true}, "example.com"},
+		{"WWW.Example.com", canonicalOptions{StripWWW: true}, "Example.com"},
+		// A lone www label after a TLD is a name of its own
+		{"www.com", canonicalOptions{StripWWW: true}, "www.com"},
+		{"www", canonicalOptions{StripWWW: true}, "www"},
+		{"www2.example.com", canonicalOptions{StripWWW: true}, "www2.example.com"},
+		// Only the leading label goes
+		{"www.www.example.com", canonicalOptions{StripWWW: true}, "www.example.com"},
+		{"api.www.example.com", canonicalOptions{StripWWW: true}, "api.www.example.com"},
+		{"www.example.com.", canonicalOptions{StripWWW: true, StripTrailingDot: true}, "example.com"},
+		{"Example.COM", canonicalOptions{Lowercase: true}, "example.com"},
+		{"example.com.", canonicalOptions{StripTrailingDot: true}, "example.com"},
+		{"https://user@www.Example.com:8443/login?next=/#top", all, "example.com"},
+		{"http://[2001:db8::1]:80/", canonicalOptions{StripScheme: true}, "2001:db8::1"},
+		{"2001:db8::1", canonicalOptions{StripScheme: true}, "2001:db8::1"},
+		{"example.com:http", canonicalOptions{StripScheme: true}, "example.com:http"},
+		// Every transform is off by default
+		{"  https://WWW.Example.com./  ", canonicalOptions{}, "https://WWW.Example.com./"},
+	}
+	for _, tt := range tests {
+		if got := canonicalizeHost(tt.host, tt.opts); got != tt.want {
+			t.Errorf("canonicalizeHost(%q, %+v) = %q, want %q", tt.host, tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestHostCanonicalizerDropsAliases(t *testing.T) {
+	c := newHostCanonicalizer(canonicalOptions{StripWWW: true, StripScheme: true})
+	got := c.filter([]string{"www.example.com", "example.com", "https://Example.com/", "api.example.com", "", "EXAMPLE.com."})
+	if want := []string{"example.com", "api.example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("filter = %q, want %q", got, want)
+	}
+	if c.dropped != 3 {
+		t.Errorf("dropped = %d, want 3", c.dropped)
+	}
+}
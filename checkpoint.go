@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// checkpointSyncEvery and checkpointSyncInterval bound how many completed hosts, and for
+// how long, the checkpoint holds before writing them out.
+const (
+	checkpointSyncEvery    = 500
+	checkpointSyncInterval = 5 * time.Second
+)
+
+// checkpoint records which hosts a scan has completed in an append-only file, one
+// normalized name per line, so an interrupted scan can resume without checking them
+// again. Records sit in the output's buffers for a while after they are written, so
+// completed hosts are held back and only written, and the file synced, after output has
+// been flushed: every checkpointSyncEvery hosts, once the oldest held host is
+// checkpointSyncInterval old, and on Close. A crash can then lose hosts whose records
+// were written, which a resumed scan checks again, but never a record of a host the
+// checkpoint holds; a torn last line is cut off on loading.
+type checkpoint struct {
+	name string
+	file *os.File
+	done map[string]bool
+	// output is flushed before held hosts are written; nil when there is nothing to flush.
+	output flusher
+	held   []string
+	since  time.Time
+}
+
+// openCheckpoint loads the hosts already completed in the named file, creating it if
+// needed, and opens it for appending.
+func openCheckpoint(name string) (*checkpoint, error) {
+	c := &checkpoint{name: name, done: make(map[string]bool)}
+	data, err := os.ReadFile(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error opening file %s: %v", name, err)
+	}
+	complete := data
+	if i := strings.LastIndexByte(string(data), '\n'); i < len(data)-1 {
+		complete = data[:i+1] // drop a line torn by a crash
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(complete)))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+	for scanner.Scan() {
+		if host := normalizeHost(scanner.Text()); host != "" {
+			c.done[host] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading checkpoint %s: %v", name, err)
+	}
+
+	if len(complete) < len(data) {
+		// Cut the torn line off so the next entry starts on a line of its own
+		if err := os.Truncate(name, int64(len(complete))); err != nil {
+			return nil, fmt.Errorf("error repairing checkpoint %s: %v", name, err)
+		}
+	}
+	if c.file, err = os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o666); err != nil {
+		return nil, fmt.Errorf("error opening file %s: %v", name, err)
+	}
+	return c, nil
+}
+
+// completed returns how many hosts the checkpoint holds.
+func (c *checkpoint) completed() int {
+	return len(c.done)
+}
+
+// skip reports whether subdomain was completed by an earlier run. Only the hosts loaded
+// from the file count, so skip is safe to call while markDone runs.
+func (c *checkpoint) skip(subdomain string) bool {
+	return c.done[normalizeHost(subdomain)]
+}
+
+// filter returns the subdomains the checkpoint does not hold, and how many it dropped.
+func (c *checkpoint) filter(subdomains []string) ([]string, int) {
+	kept := subdomains[:0]
+	for _, subdomain := range subdomains {
+		if !c.skip(subdomain) {
+			kept = append(kept, subdomain)
+		}
+	}
+	return kept, len(subdomains) - len(kept)
+}
+
+// markDone adds subdomain to the checkpoint once its record has been written to the
+// output, writing out the held hosts when enough have gathered.
+func (c *checkpoint) markDone(subdomain string) error {
+	if len(c.held) == 0 {
+		c.since = time.Now()
+	}
+	c.held = append(c.held, normalizeHost(subdomain))
+	if len(c.held) >= checkpointSyncEvery || time.Since(c.since) >= checkpointSyncInterval {
+		return c.commit()
+	}
+	return nil
+}
+
+// commit flushes the output, then appends the held hosts to the file and syncs it. Hosts
+// stay held when the flush fails, since their records may not have reached the output.
+func (c *checkpoint) commit() error {
+	if len(c.held) == 0 {
+		return nil
+	}
+	if c.output != nil {
+		if err := c.output.Flush(); err != nil {
+			return fmt.Errorf("error flushing results before checkpoint %s: %v", c.name, err)
+		}
+	}
+	if _, err := io.WriteString(c.file, strings.Join(c.held, "\n")+"\n"); err != nil {
+		return fmt.Errorf("error writing checkpoint %s: %v", c.name, err)
+	}
+	c.held = c.held[:0]
+	if err := c.file.Sync(); err != nil {
+		return fmt.Errorf("error syncing checkpoint %s: %v", c.name, err)
+	}
+	return nil
+}
+
+// Close writes out the held hosts and closes the checkpoint file. It is called after the
+// output has been closed, so flushing it there is a no-op.
+func (c *checkpoint) Close() error {
+	err := c.commit()
+	if closeErr := c.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// failingFlusher is an output whose flushes fail.
+type failingFlusher struct{}
+
+func (failingFlusher) Flush() error {
+	return errors.New("disk full")
+}
+
+func TestCheckpointWaitsForOutputFlush(t *testing.T) {
+	var results bytes.Buffer
+	buffered := bufferedStream{bufio.NewWriterSize(&results, 1<<20)}
+	records, err := newRecordWriter(formatText, buffered, nil, Options{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Neither trigger fires on its own, so only the checkpoint flushes
+	output := newFlushingWriter(records, []flusher{buffered}, 0, 0)
+	group := &flushGroup{}
+	group.add(output)
+
+	name := filepath.Join(t.TempDir(), "checkpoint")
+	c, err := openCheckpoint(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.output = group
+	for i := 0; i < checkpointSyncEvery; i++ {
+		host := fmt.Sprintf("h%d.example.com", i)
+		if err := output.Write(Record{Subdomain: host, CNAME: "app.herokuapp.com"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.markDone(host); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := os.ReadFile(name)
+		if flushed := strings.Count(results.String(), ".example.com"); strings.Count(string(data), "\n") > flushed {
+			t.Fatalf("after %d hosts the checkpoint holds %d hosts but only %d records were flushed", i+1, strings.Count(string(data), "\n"), flushed)
+		}
+	}
+	data, _ := os.ReadFile(name)
+	if got := strings.Count(string(data), "\n"); got != checkpointSyncEvery {
+		t.Errorf("checkpoint holds %d hosts, want %d", got, checkpointSyncEvery)
+	}
+	if flushed := strings.Count(results.String(), ".example.com"); flushed != checkpointSyncEvery {
+		t.Errorf("%d records flushed, want %d", flushed, checkpointSyncEvery)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckpointKeepsHostsWhenFlushFails(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "checkpoint")
+	c, err := openCheckpoint(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.output = failingFlusher{}
+	if err := c.markDone("a.example.com"); err != nil {
+		t.Fatalf("markDone of a single host failed: %v", err)
+	}
+	if err := c.Close(); err == nil {
+		t.Error("Close succeeded although the output could not be flushed")
+	}
+	if data, _ := os.ReadFile(name); len(data) != 0 {
+		t.Errorf("checkpoint = %q, want nothing written", data)
+	}
+
+	// Once the output flushes, the held hosts are written out and loaded on resume
+	c, err = openCheckpoint(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.markDone("A.Example.com.")
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = openCheckpoint(name); err != nil || !c.skip("a.example.com") {
+		t.Errorf("resumed checkpoint does not hold a.example.com (err %v)", err)
+	}
+	c.Close()
+}
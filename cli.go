@@ -0,0 +1,374 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// command describes a digcname subcommand for dispatch and help output.
+type command struct {
+	name    string
+	summary string
+	// usage lists the argument forms, one per line, without the program and command name.
+	usage    []string
+	groups   []flagGroup
+	examples []string
+}
+
+// flagGroup is a titled set of flags shown together in help output.
+type flagGroup struct {
+	title string
+	flags []string
+}
+
+// defaultCommand runs when the first argument is not a command name.
+const defaultCommand = "scan"
+
+var scanCommand = &command{
+	name:    "scan",
+	summary: "look up subdomains and report CNAMEs matching takeover fingerprints",
+	usage: []string{
+		"[flags] <subdomains-file> [<patterns-file>] <result-file>",
+		"[flags] -fingerprints <file-or-url> <subdomains-file> <result-file>",
+		"[flags] < subdomains > results",
+		"[flags] -target <subdomain> [-target <subdomain> ...] [[<subdomains-file>] <result-file>]",
+		"[flags] -syslog [<subdomains-file> [<patterns-file>]]",
+		"[flags] -count-only [<subdomains-file> [<patterns-file>]]",
+		"[flags] -parallel-files <result-dir> <subdomains-file> [<subdomains-file> ...]",
+		"[flags] -input-json <earlier-results> [[<patterns-file>] <result-file>]",
+	},
+	groups: []flagGroup{
+		{"Patterns", []string{"fingerprints", "with-defaults", "fingerprints-cache", "match-literal", "all-matches", "ip-patterns", "validate-patterns"}},
+		{"Input", []string{"target", "expand", "strip-scheme", "lowercase", "strip-trailing-dot", "strip-www", "strict-tld", "exclude", "shard", "checkpoint", "parallel-files", "input-json", "dry-run", "count-only"}},
+		{"Resolution", []string{"server", "resolvers-file", "dot", "dot-pin", "resolver-from-system", "hosts-file", "resolver-cooldown", "timeout-jitter", "compare-two", "use-native", "use-dig", "dig-path", "dig-args", "replay", "no-recursion", "follow-chain", "check-delegation", "cross-check", "asn-db", "timeout"}},
+		{"Confirmation", []string{"confirm", "confirm-timeout", "confirm-max-redirects", "probe-path", "insecure-confirm", "confirm-sample", "confirm-sample-seed"}},
+		{"Filtering", []string{"min-severity", "min-confidence", "skip-resolvable", "require-cname", "min-cname-length", "max-cname-length", "all", "only-changed", "state-file", "compare-baseline-severity"}},
+		{"Output", []string{"format", "fields", "header", "preserve-cname-case", "collapse", "append", "flush-every", "flush-interval", "rotate", "gzip-output", "answer-hash", "ttl-warn", "redact", "redact-cname", "redact-map", "output-encrypt", "encrypt-passphrase", "encrypt-keyfile", "sqlite", "graph", "list-providers", "summary-json", "syslog", "syslog-addr", "syslog-facility", "syslog-tag", "es-url", "es-index", "es-user", "es-password", "es-batch-size", "on-finding", "on-finding-concurrency"}},
+		{"Pacing and limits", []string{"concurrency", "benchmark", "doh-concurrency", "rate", "auto-rate", "watch", "jitter", "jitter-seed", "seed", "max-runtime", "max-errors", "max-consecutive-errors", "findings-limit", "findings-limit-abort"}},
+		{"Configuration", []string{"config"}},
+		{"Debugging", []string{"v", "log-format", "explain", "explain-file", "trace-dns", "cpuprofile", "memprofile", "pprof-addr"}},
+	},
+	examples: []string{
+		"digcname list.txt patterns.txt results.txt",
+		"digcname -format jsonl -min-severity high list.txt results.jsonl",
+		"digcname -fingerprints https://example.com/fingerprints.json -with-defaults list.txt results.txt",
+		"cat hosts.txt | digcname -concurrency 20 -skip-resolvable | jq .subdomain",
+		"digcname -target api.example.com -format text",
+		"digcname -parallel-files out clients/*.txt",
+		"digcname -watch 1h -only-changed -syslog -syslog-addr tcp://logs.example.com:514 list.txt",
+	},
+}
+
+var validateCommand = &command{
+	name:    "validate",
+	summary: "check a patterns file for duplicate, redundant or malformed entries",
+	usage: []string{
+		"[flags] [<patterns-file>]",
+		"[flags] -fingerprints <file-or-url>",
+	},
+	groups: []flagGroup{
+		{"Patterns", []string{"fingerprints", "with-defaults", "fingerprints-cache"}},
+	},
+	examples: []string{
+		"digcname validate patterns.txt",
+		"digcname validate -fingerprints fingerprints.json -with-defaults",
+	},
+}
+
+var unredactCommand = &command{
+	name:    "unredact",
+	summary: "restore the names in -redact output using its mapping file",
+	usage: []string{
+		"-map <file> [<redacted-file>]",
+	},
+	examples: []string{
+		"digcname unredact -map redaction.csv shared-results.jsonl > results.jsonl",
+	},
+}
+
+var decryptCommand = &command{
+	name:    "decrypt",
+	summary: "decrypt a result file written with -output-encrypt",
+	usage: []string{
+		"(-passphrase <passphrase> | -keyfile <file>) [<encrypted-file>]",
+	},
+	examples: []string{
+		envName("encrypt-passphrase") + "=... digcname decrypt results.jsonl.enc > results.jsonl",
+		"digcname decrypt -keyfile results.key results.csv.enc",
+	},
+}
+
+// commands lists every subcommand in help order.
+var commands = []*command{scanCommand, validateCommand, unredactCommand, decryptCommand}
+
+func main() {
+	args := os.Args[1:]
+	name := defaultCommand
+	if len(args) > 0 && (args[0] == "help" || findCommand(args[0]) != nil) {
+		name, args = args[0], args[1:]
+	}
+	runCommand(name, args)
+}
+
+// runCommand runs the command called name with its arguments.
+func runCommand(name string, args []string) {
+	switch name {
+	case scanCommand.name:
+		runScan(args)
+	case validateCommand.name:
+		runValidate(args)
+	case unredactCommand.name:
+		runUnredact(args)
+	case decryptCommand.name:
+		runDecrypt(args)
+	case "help":
+		runHelp(args)
+	default:
+		configError("Unknown command %q; run 'digcname help' for a list", name)
+	}
+}
+
+// findCommand returns the command called name, or nil.
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// runHelp prints the overview, or the help of the command named in args.
+func runHelp(args []string) {
+	if len(args) > 0 {
+		runCommand(args[0], []string{"-h"})
+		return
+	}
+
+	w := os.Stderr
+	fmt.Fprintf(w, "Usage: digcname [<command>] [flags] [arguments]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nWithout a command, digcname runs %s. Run 'digcname help <command>' for its flags.\n", defaultCommand)
+}
+
+// printCommandUsage writes the usage, grouped flags and examples of cmd to the output of fs.
+// Flags of fs that are not in any group are listed under "Other".
+func printCommandUsage(fs *flag.FlagSet, cmd *command) {
+	w := fs.Output()
+	for i, usage := range cmd.usage {
+		prefix := "Usage:"
+		if i > 0 {
+			prefix = "      "
+		}
+		fmt.Fprintf(w, "%s digcname %s %s\n", prefix, cmd.name, usage)
+	}
+	fmt.Fprintf(w, "\n%s.\n", strings.ToUpper(cmd.summary[:1])+cmd.summary[1:])
+	if cmd == scanCommand {
+		fmt.Fprintf(w, "A file name of - means stdin or stdout; without file arguments hosts are read from stdin and JSON Lines are written to stdout.\n")
+		fmt.Fprintf(w, "Every flag can also be set with an environment variable such as %s or %sRESOLVER (for -server), or in a -config file; command line flags take precedence over the environment, which takes precedence over the config file.\n", envName("es-password"), envPrefix)
+	}
+
+	grouped := make(map[string]bool)
+	groups := cmd.groups
+	for _, group := range groups {
+		for _, name := range group.flags {
+			grouped[name] = true
+		}
+	}
+	var other []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if !grouped[f.Name] {
+			other = append(other, f.Name)
+		}
+	})
+	if len(other) > 0 {
+		groups = append(groups[:len(groups):len(groups)], flagGroup{"Other", other})
+	}
+
+	for _, group := range groups {
+		fmt.Fprintf(w, "\n%s:\n", group.title)
+		for _, name := range group.flags {
+			if f := fs.Lookup(name); f != nil {
+				printFlag(w, f)
+			}
+		}
+	}
+
+	if len(cmd.examples) > 0 {
+		fmt.Fprintf(w, "\nExamples:\n")
+		for _, example := range cmd.examples {
+			fmt.Fprintf(w, "  %s\n", example)
+		}
+	}
+
+	if cmd == scanCommand {
+		fmt.Fprintf(w, "\nExit status:\n")
+		fmt.Fprintf(w, "  %d  clean: nothing vulnerable was found\n", exitClean)
+		fmt.Fprintf(w, "  %d  error, such as an unwritable result file\n", exitError)
+		fmt.Fprintf(w, "  %d  findings: a reported record is vulnerable (even if the scan was incomplete)\n", exitFindings)
+		fmt.Fprintf(w, "  %d  partial: some lookups failed, or the scan was stopped early\n", exitPartial)
+		fmt.Fprintf(w, "  %d  aborted by -max-errors or -max-consecutive-errors\n", exitAborted)
+		fmt.Fprintf(w, "  %d  invalid flags, arguments or configuration\n", exitUsage)
+	}
+}
+
+// printFlag writes one flag the way flag.PrintDefaults does.
+func printFlag(w io.Writer, f *flag.Flag) {
+	typeName, usage := flag.UnquoteUsage(f)
+	line := "  -" + f.Name
+	if typeName != "" {
+		line += " " + typeName
+	}
+	line += "\n    \t" + strings.ReplaceAll(usage, "\n", "\n    \t")
+	if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" && f.DefValue != "0s" {
+		line += fmt.Sprintf(" (default %s)", f.DefValue)
+	}
+	fmt.Fprintln(w, line)
+}
+
+// stringList is a repeatable flag collecting its values; a value may also hold several
+// comma-separated items, so the flag can be set from the environment or a config file.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// dryRunSample is how many hosts -dry-run lists before summarizing the rest.
+const dryRunSample = 20
+
+// writeDryRun lists the first sample hosts to w, followed by the total.
+func writeDryRun(w io.Writer, hosts []string, sample int) error {
+	for _, host := range hosts[:min(sample, len(hosts))] {
+		if _, err := fmt.Fprintln(w, host); err != nil {
+			return err
+		}
+	}
+	if len(hosts) > sample {
+		if _, err := fmt.Fprintf(w, "... and %d more\n", len(hosts)-sample); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d subdomains would be queried\n", len(hosts))
+	return err
+}
+
+// usageError reports a command line mistake followed by the command's usage, and exits.
+func usageError(fs *flag.FlagSet, format string, args ...interface{}) {
+	fmt.Fprintf(fs.Output(), "digcname %s: %s\n\n", fs.Name(), fmt.Sprintf(format, args...))
+	fs.Usage()
+	os.Exit(int(exitUsage))
+}
+
+// runValidate implements the validate command.
+func runValidate(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	var fingerprints stringList
+	fs.Var(&fingerprints, "fingerprints", "load patterns from this file, glob or http(s):// URL instead of the patterns-file argument; repeatable, or comma-separated, merging the patterns")
+	withDefaults := fs.Bool("with-defaults", false, "add the built-in default fingerprints to the patterns file or -fingerprints source")
+	fingerprintsCache := fs.String("fingerprints-cache", "", "cache fetched -fingerprints URLs in this file and use it when a fetch fails")
+	fs.Usage = func() { printCommandUsage(fs, validateCommand) }
+	parseFlags(fs, args)
+
+	if fs.NArg() > 1 || (fs.NArg() == 1 && len(fingerprints) > 0) {
+		usageError(fs, "expected at most one patterns file, and none with -fingerprints")
+	}
+	source := fingerprints.String()
+	if fs.NArg() == 1 {
+		source = fs.Arg(0)
+	}
+	validatePatternSource(source, *fingerprintsCache, *withDefaults)
+}
+
+// validatePatternSource loads the patterns selected by source, prints every problem found
+// and exits non-zero if there were any.
+func validatePatternSource(source, cacheFile string, withDefaults bool) {
+	patterns, name, err := selectPatterns(source, cacheFile, withDefaults)
+	if err != nil {
+		fatal("Failed to load patterns", err)
+	}
+	problems := validatePatterns(patterns)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		slog.Error("Found problems in patterns", "problems", len(problems), "patterns", len(patterns), "source", name)
+		os.Exit(int(exitError))
+	}
+	slog.Info("Patterns are valid", "patterns", len(patterns), "source", name)
+}
+
+// runUnredact implements the unredact command, reading the named file or stdin.
+func runUnredact(args []string) {
+	fs := flag.NewFlagSet("unredact", flag.ContinueOnError)
+	mapFile := fs.String("map", "", "redaction mapping file written by scan -redact-map")
+	fs.Usage = func() { printCommandUsage(fs, unredactCommand) }
+	parseFlags(fs, args)
+
+	if *mapFile == "" || fs.NArg() > 1 {
+		usageError(fs, "expected -map and at most one redacted file")
+	}
+	input := io.Reader(os.Stdin)
+	if fs.NArg() == 1 && fs.Arg(0) != stdioName {
+		file, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fatal("Failed to open redacted file", err)
+		}
+		defer file.Close()
+		input = file
+	}
+	if err := unredact(input, os.Stdout, *mapFile); err != nil {
+		fatal("Failed to unredact", err)
+	}
+}
+
+// runDecrypt implements the decrypt command, reading the named file or stdin. Like scan,
+// it falls back to the DIGCNAME_ENCRYPT_PASSPHRASE and DIGCNAME_ENCRYPT_KEYFILE
+// environment variables.
+func runDecrypt(args []string) {
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	passphrase := fs.String("passphrase", "", "passphrase the file was encrypted with")
+	keyFile := fs.String("keyfile", "", "key file the file was encrypted with")
+	fs.Usage = func() { printCommandUsage(fs, decryptCommand) }
+	parseFlags(fs, args)
+
+	if *passphrase == "" && *keyFile == "" {
+		*passphrase = os.Getenv(envName("encrypt-passphrase"))
+		*keyFile = os.Getenv(envName("encrypt-keyfile"))
+	}
+
+	if fs.NArg() > 1 {
+		usageError(fs, "expected at most one encrypted file")
+	}
+	key, err := loadEncryptionKey(*passphrase, *keyFile)
+	if err != nil {
+		usageError(fs, "%v", err)
+	}
+	input := io.Reader(os.Stdin)
+	if fs.NArg() == 1 && fs.Arg(0) != stdioName {
+		file, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fatal("Failed to open encrypted file", err)
+		}
+		defer file.Close()
+		input = file
+	}
+	if err := decryptStream(input, os.Stdout, key); err != nil {
+		fatal("Failed to decrypt", err)
+	}
+}
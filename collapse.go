@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// collapseWriter writes -collapse output: one entry per CNAME target listing every host
+// that points at it, instead of one per host, so a single unclaimed resource behind many
+// hosts reads as the one fix it is. Records are held until Close, which writes the groups
+// as text, JSON or JSON Lines, vulnerable targets first, then by severity and number of
+// hosts. Hosts whose lookup failed or that have no answer share the group with an empty
+// target.
+type collapseWriter struct {
+	output io.Writer
+	format string
+	header string
+	groups map[string]*targetGroup
+}
+
+// targetGroup is the hosts pointing at one target. The group is vulnerable when any of
+// its records is, and takes the pattern and remediation of its most severe one.
+type targetGroup struct {
+	Target         string   `json:"target"`
+	Hosts          int      `json:"hosts"`
+	Subdomains     []string `json:"subdomains"`
+	IsVulnerable   bool     `json:"vulnerable"`
+	MatchedPattern string   `json:"matched_pattern"`
+	Severity       Severity `json:"severity"`
+	Confirmed      bool     `json:"confirmed"`
+	Remediation    string   `json:"remediation"`
+}
+
+// collapseFormats are the output formats -collapse can write.
+var collapseFormats = map[string]bool{formatText: true, formatJSON: true, formatJSONL: true}
+
+// newCollapseWriter returns a collapseWriter writing format, one of collapseFormats, to
+// output, after header for text.
+func newCollapseWriter(format string, output io.Writer, header string) *collapseWriter {
+	return &collapseWriter{output: output, format: format, header: header, groups: make(map[string]*targetGroup)}
+}
+
+// collapseTarget returns the target record is grouped under: its CNAME, or PTR for an
+// IP address, or the matched address of a host vulnerable through -ip-patterns alone.
+func collapseTarget(record Record) string {
+	switch {
+	case record.Error != "":
+		return ""
+	case record.MatchedIP != "" && emptyAnswer(record):
+		return record.MatchedIP
+	case emptyAnswer(record):
+		return ""
+	case record.PTR != "":
+		return record.PTR
+	default:
+		return record.CNAME
+	}
+}
+
+func (w *collapseWriter) Write(record Record) error {
+	target := collapseTarget(record)
+	group, ok := w.groups[target]
+	if !ok {
+		group = &targetGroup{Target: target}
+		w.groups[target] = group
+	}
+	group.Hosts++
+	group.Subdomains = append(group.Subdomains, record.Subdomain)
+	if record.IsVulnerable && (!group.IsVulnerable || record.Severity > group.Severity) {
+		group.IsVulnerable = true
+		group.MatchedPattern = record.MatchedPattern
+		group.Severity = record.Severity
+		group.Remediation = record.Remediation
+	}
+	group.Confirmed = group.Confirmed || record.Confirmed
+	return nil
+}
+
+func (w *collapseWriter) Close() error {
+	groups := make([]*targetGroup, 0, len(w.groups))
+	for _, group := range w.groups {
+		sort.Strings(group.Subdomains)
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		switch {
+		case a.IsVulnerable != b.IsVulnerable:
+			return a.IsVulnerable
+		case a.Severity != b.Severity:
+			return a.Severity > b.Severity
+		case a.Hosts != b.Hosts:
+			return a.Hosts > b.Hosts
+		default:
+			return a.Target < b.Target
+		}
+	})
+
+	switch w.format {
+	case formatJSON:
+		data, err := json.MarshalIndent(groups, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w.output, "%s\n", data)
+		return err
+	case formatJSONL:
+		encoder := json.NewEncoder(w.output)
+		for _, group := range groups {
+			if err := encoder.Encode(group); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if _, err := io.WriteString(w.output, w.header); err != nil {
+		return err
+	}
+	for _, group := range groups {
+		target := strings.ReplaceAll(group.Target, "\n", ", ")
+		if target == "" {
+			target = "none"
+		}
+		line := fmt.Sprintf("Target: %s, Hosts: %d, Vulnerable: No", target, group.Hosts)
+		if group.IsVulnerable {
+			line = fmt.Sprintf("Target: %s, Hosts: %d, Vulnerable: Yes, Pattern: %s, Severity: %s", target, group.Hosts, group.MatchedPattern, group.Severity)
+			if group.Confirmed {
+				line += ", Confirmed: Yes"
+			}
+			if group.Remediation != "" {
+				line += ", Remediation: " + group.Remediation
+			}
+		}
+		var b strings.Builder
+		b.WriteString(line + "\n")
+		for _, subdomain := range group.Subdomains {
+			b.WriteString("  " + subdomain + "\n")
+		}
+		if _, err := io.WriteString(w.output, b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
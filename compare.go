@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// discardRecords is a recordWriter that drops every record.
+type discardRecords struct{}
+
+func (discardRecords) Write(Record) error { return nil }
+
+func (discardRecords) Close() error { return nil }
+
+// diffCounter counts the records a changeFilter lets through to next.
+type diffCounter struct {
+	next  recordWriter
+	count int
+}
+
+func (w *diffCounter) Write(record Record) error {
+	w.count++
+	return w.next.Write(record)
+}
+
+func (w *diffCounter) Close() error {
+	return w.next.Close()
+}
+
+// compareScan scans subdomains with the baseline resolver and then with the candidate,
+// and writes to output the candidate's record for every host whose verdict or CNAME
+// differs between the two, told apart by a changeTracker as -only-changed does between
+// scans. It returns the number of hosts that differ and a summary of both scans: their
+// failures add up, and Findings counts the candidate's vulnerable records.
+func compareScan(ctx context.Context, subdomains []string, patterns []Pattern, baseline, candidate Resolver, opts Options, output recordWriter) (int, Summary, error) {
+	opts.DiscardResults = true
+	// Every host has to reach the tracker, or a host turning clean would go unnoticed
+	opts.All = true
+	tracker, _ := newChangeTracker("")
+
+	_, first, err := checkCNAMERecords(ctx, sendLines(ctx, subdomains), len(subdomains), patterns, baseline, opts, &changeFilter{next: discardRecords{}, tracker: tracker})
+	if err != nil {
+		return 0, first, fmt.Errorf("baseline scan: %v", err)
+	}
+	slog.Info("Finished baseline scan", "scanned", first.Scanned, "failed", first.Failed)
+
+	diffs := &diffCounter{next: output}
+	_, summary, err := checkCNAMERecords(ctx, sendLines(ctx, subdomains), len(subdomains), patterns, candidate, opts, &changeFilter{next: diffs, tracker: tracker})
+	summary.Failed += first.Failed
+	if err != nil {
+		return diffs.count, summary, fmt.Errorf("comparison scan: %v", err)
+	}
+	return diffs.count, summary, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import "strings"
+
+// Confidence score weights. A vulnerable record starts at confidenceBase and gains the
+// bonuses below for each piece of corroborating evidence, up to 100.
+const (
+	confidenceBase          = 40
+	confidenceSuffixMatch   = 30 // the pattern matches at a label boundary at the end of the target
+	confidenceAuthoritative = 15 // the answer came from an authoritative server
+	confidenceOffSite       = 15 // the target is in a different registrable domain than the subdomain
+)
+
+// confidence scores from 0 to 100 how likely a vulnerable record is a real dangling CNAME
+// rather than an incidental pattern hit. Records that are not vulnerable score 0.
+func confidence(record Record, candidates []string) int {
+	if !record.IsVulnerable {
+		return 0
+	}
+
+	score := confidenceBase
+	for _, candidate := range candidates {
+		if isSuffixMatch(candidate, record.MatchedPattern) {
+			score += confidenceSuffixMatch
+			break
+		}
+	}
+	if record.Authoritative {
+		score += confidenceAuthoritative
+	}
+	if record.CNAMERegistrable != "" && record.CNAMERegistrable != record.SubdomainRegistrable {
+		score += confidenceOffSite
+	}
+	return min(score, 100)
+}
+
+// isSuffixMatch reports whether any line of target ends with pattern on a label boundary,
+// ignoring a trailing dot.
+func isSuffixMatch(target, pattern string) bool {
+	pattern = normalizeHost(pattern)
+	if pattern == "" {
+		return false
+	}
+	for _, line := range strings.Split(target, "\n") {
+		line = normalizeHost(line)
+		if line == pattern || strings.HasSuffix(line, "."+strings.TrimPrefix(pattern, ".")) {
+			return true
+		}
+	}
+	return false
+}
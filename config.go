@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix starts the environment variables that set flag defaults: -es-password is
+// read from DIGCNAME_ES_PASSWORD, for example.
+const envPrefix = "DIGCNAME_"
+
+// configEnv names the environment variable holding the default -config file.
+const configEnv = envPrefix + "CONFIG"
+
+// envAliases maps extra environment variable names to the flags they set.
+var envAliases = map[string]string{
+	envPrefix + "RESOLVER": "server",
+}
+
+// applyConfig fills in every flag of fs that was not given on the command line, so
+// secrets such as resolver addresses and credentials need not appear in process lists.
+// It must run after fs.Parse. The precedence, highest first, is:
+//
+//  1. flags on the command line
+//  2. environment variables: DIGCNAME_ plus the flag name in upper case with dashes as
+//     underscores (DIGCNAME_ES_PASSWORD), and the aliases in envAliases
+//  3. the config file named by configFile, or by DIGCNAME_CONFIG when configFile is empty
+//  4. the flag's built-in default
+//
+// The config file holds one "name = value" line per flag, without the leading dash;
+// blank lines and lines starting with # are ignored.
+func applyConfig(fs *flag.FlagSet, configFile string) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	if configFile == "" {
+		configFile = os.Getenv(configEnv)
+	}
+	fileValues := make(map[string]string)
+	if configFile != "" {
+		var err error
+		if fileValues, err = readConfigFile(configFile, fs); err != nil {
+			return err
+		}
+	}
+
+	envValues := make(map[string]string)
+	for alias, name := range envAliases {
+		if value, ok := os.LookupEnv(alias); ok && fs.Lookup(name) != nil {
+			envValues[name] = value
+		}
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			envValues[f.Name] = value
+		}
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] {
+			return
+		}
+		if value, ok := envValues[f.Name]; ok {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value for %s: %v", envName(f.Name), setErr)
+			}
+			return
+		}
+		if value, ok := fileValues[f.Name]; ok {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value for %s in %s: %v", f.Name, configFile, setErr)
+			}
+		}
+	})
+	return err
+}
+
+// envName returns the environment variable that sets the flag called name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// readConfigFile reads "name = value" lines, rejecting names that are not flags of fs.
+func readConfigFile(filename string, fs *flag.FlagSet) (map[string]string, error) {
+	lines, err := readLinesFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid line %q in %s: expected \"name = value\"", line, filename)
+		}
+		name = strings.TrimPrefix(strings.TrimSpace(name), "-")
+		if fs.Lookup(name) == nil || name == "config" {
+			return nil, fmt.Errorf("unknown setting %q in %s", name, filename)
+		}
+		values[name] = strings.TrimSpace(value)
+	}
+	return values, nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// HTTP confirmation defaults.
+const (
+	defaultConfirmTimeout      = 10 * time.Second
+	defaultConfirmMaxRedirects = 5
+	// maxConfirmBody is how much of a response body is searched for fingerprints.
+	maxConfirmBody = 1 << 20
+)
+
+// nxdomainFingerprint marks fingerprints that are confirmed by the target not resolving
+// rather than by an HTTP response.
+const nxdomainFingerprint = "NXDOMAIN"
+
+// genericFingerprints are provider error pages, many of them served with status 200 (soft
+// 404s), used to confirm patterns that carry no fingerprint of their own.
+var genericFingerprints = []string{
+	"404 Web Site not found",
+	"The resource you are looking for has been removed",
+	"NoSuchBucket",
+	"The specified bucket does not exist",
+	"There isn't a GitHub Pages site here",
+	"Fastly error: unknown domain",
+	"No such app",
+	"herokucdn.com/error-pages/no-such-app.html",
+	"Repository not found",
+	"Sorry, this shop is currently unavailable",
+	"Do you want to register",
+	"The requested URL was not found on this server",
+	"Web Site Not Found",
+}
+
+// confirmer checks vulnerable records with the check their pattern calls for. By default
+// a takeover is confirmed when the response body, after following redirects, contains
+// the pattern's fingerprint (or, for patterns without one, a generic provider error page)
+// and the status matches when the pattern names one. Checking the body catches providers
+// that answer a missing resource with 200 and an error page.
+type confirmer struct {
+	client *http.Client
+	// timeout bounds one record's check, every request and redirect included.
+	timeout time.Duration
+
+	// insecure is set by skipTLSVerify: HTTPS certificates are not verified.
+	insecure bool
+
+	// probePath is the URL path requested for patterns that do not name their own.
+	probePath string
+
+	// sampleRate is the fraction of records checked; sampleSeed picks which ones.
+	sampleRate float64
+	sampleSeed uint64
+
+	checked    atomic.Int64
+	confirmed  atomic.Int64
+	sampledOut atomic.Int64
+}
+
+// newConfirmer returns a confirmer whose checks time out after timeout and whose requests
+// follow at most maxRedirects redirects.
+func newConfirmer(timeout time.Duration, maxRedirects int) *confirmer {
+	// HTTPS requests offer HTTP/2 and fall back to HTTP/1.1 when the server does not
+	// negotiate it
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ForceAttemptHTTP2 = true
+	return &confirmer{probePath: "/", sampleRate: 1, timeout: timeout, client: &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				// Stop and judge the last redirect response itself
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}}
+}
+
+// skipTLSVerify makes the confirmer accept any HTTPS certificate, for -insecure-confirm:
+// an unclaimed resource often serves an invalid or expired one, and verifying it would
+// fail the request before the error page shows. It affects only the confirmer's client.
+func (c *confirmer) skipTLSVerify() {
+	c.client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	c.insecure = true
+}
+
+// sample makes the confirmer check only the given fraction of records. The choice depends
+// only on seed and the host name, so a seed reproduces it whatever the scan order; a zero
+// seed picks a random one.
+func (c *confirmer) sample(rate float64, seed uint64) {
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	c.sampleRate, c.sampleSeed = rate, seed
+}
+
+// sampled reports whether host falls in the sampled fraction.
+func (c *confirmer) sampled(host string) bool {
+	if c.sampleRate >= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	var seed [8]byte
+	binary.BigEndian.PutUint64(seed[:], c.sampleSeed)
+	h.Write(seed[:])
+	h.Write([]byte(normalizeHost(host)))
+	return float64(h.Sum64())/math.MaxUint64 < c.sampleRate
+}
+
+// stats returns how many records were checked and confirmed, and how many were skipped
+// by sampling.
+func (c *confirmer) stats() (checked, confirmed, sampledOut int64) {
+	return c.checked.Load(), c.confirmed.Load(), c.sampledOut.Load()
+}
+
+// checkFunc confirms a vulnerable record matched by pattern, setting the record's
+// confirmation fields.
+type checkFunc func(ctx context.Context, c *confirmer, record *Record, pattern Pattern)
+
+// Names of the built-in confirmation checks, as used in the "check" field of a
+// fingerprint entry.
+const (
+	checkHTTPBody = "http_body"
+	checkNXDOMAIN = "nxdomain"
+)
+
+// checks is the registry of confirmation checks by name. Providers that need their own
+// probe (a bucket listing, a provider API) get a checkFunc here and name it in their
+// fingerprint entries.
+var checks = map[string]checkFunc{
+	checkHTTPBody: checkHTTPResponse,
+	checkNXDOMAIN: checkDangling,
+}
+
+// checkName returns the check that confirms pattern: the one it names, else the
+// NXDOMAIN check for the "NXDOMAIN" fingerprint and the HTTP body check otherwise.
+func checkName(pattern Pattern) string {
+	switch {
+	case pattern.Check != "":
+		return pattern.Check
+	case pattern.Fingerprint == nxdomainFingerprint:
+		return checkNXDOMAIN
+	default:
+		return checkHTTPBody
+	}
+}
+
+// confirm sets the confirmation fields of a vulnerable record matched by pattern, using
+// the check the pattern calls for.
+func (c *confirmer) confirm(ctx context.Context, record *Record, pattern Pattern) {
+	if !c.sampled(record.Subdomain) {
+		record.SampledOut = true
+		c.sampledOut.Add(1)
+		return
+	}
+
+	name := checkName(pattern)
+	check, ok := checks[name]
+	if !ok {
+		record.ConfirmError = fmt.Sprintf("unknown check %q", name)
+		return
+	}
+	check(ctx, c, record, pattern)
+	c.checked.Add(1)
+	if record.Confirmed {
+		c.confirmed.Add(1)
+	}
+}
+
+// checkDangling confirms a record whose CNAME target does not resolve.
+func checkDangling(ctx context.Context, c *confirmer, record *Record, pattern Pattern) {
+	record.Confirmed = record.Resolution == resolutionDangling
+	if record.Confirmed {
+		record.ConfirmMatch = nxdomainFingerprint
+	}
+}
+
+// checkHTTPResponse confirms a record whose web response carries the pattern's
+// fingerprint, or a generic provider error page when it has none, with the pattern's
+// status code if it names one.
+func checkHTTPResponse(ctx context.Context, c *confirmer, record *Record, pattern Pattern) {
+	fingerprints := genericFingerprints
+	if pattern.Fingerprint != "" {
+		fingerprints = []string{pattern.Fingerprint}
+	}
+
+	path := c.probePath
+	if pattern.Path != "" {
+		path = pattern.Path
+	}
+	resp, err := c.fetch(ctx, record.Subdomain, path)
+	if err != nil {
+		record.ConfirmError = err.Error()
+		return
+	}
+	record.HTTPStatus = resp.status
+	record.ConfirmProtocol = resp.protocol
+	record.ConfirmInsecure = resp.unverified
+	if pattern.HTTPStatus != 0 && resp.status != pattern.HTTPStatus {
+		return
+	}
+	for _, fingerprint := range fingerprints {
+		if strings.Contains(resp.body, fingerprint) {
+			record.Confirmed = true
+			record.ConfirmMatch = fingerprint
+			return
+		}
+	}
+}
+
+// probeResponse is the final response to a confirmation request: its status code, the
+// start of its body and the protocol it came over, such as "HTTP/2.0". Unverified is set
+// when it came over TLS whose certificate was not verified.
+type probeResponse struct {
+	status     int
+	body       string
+	protocol   string
+	unverified bool
+}
+
+// fetch requests path on the host over HTTPS, falling back to plain HTTP when HTTPS
+// cannot be reached. Both attempts share the confirmer's timeout, so a host that answers
+// neither takes no longer than one that does not answer HTTPS.
+func (c *confirmer) fetch(ctx context.Context, host, path string) (probeResponse, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	resp, err := c.get(ctx, "https://"+host+path)
+	if err == nil || ctx.Err() != nil {
+		return resp, err
+	}
+	resp, httpErr := c.get(ctx, "http://"+host+path)
+	if httpErr != nil {
+		return probeResponse{}, fmt.Errorf("https: %v; http: %v", err, httpErr)
+	}
+	return resp, nil
+}
+
+// get performs one GET request, following redirects up to the configured limit.
+func (c *confirmer) get(ctx context.Context, url string) (probeResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return probeResponse{}, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return probeResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	result := probeResponse{status: resp.StatusCode, protocol: resp.Proto, unverified: c.insecure && resp.TLS != nil}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxConfirmBody))
+	if err != nil {
+		return result, err
+	}
+	result.body = string(body)
+	return result, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfirmTimeoutLeavesRecordVulnerable(t *testing.T) {
+	// The provider's error page never arrives: the handler holds the request until the
+	// client gives up
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		w.Write([]byte("No such app"))
+	}))
+	defer server.Close()
+	host := server.Listener.Addr().String()
+
+	resolver := &fakeResolver{cnames: map[string]Answer{host: {CNAMEs: []string{"gone.herokuapp.com"}}}}
+	patterns := []Pattern{{Value: "herokuapp.com", Severity: SeverityHigh, Fingerprint: "No such app"}}
+	opts := Options{MinCNAMELength: 4, Confirmer: newConfirmer(100*time.Millisecond, defaultConfirmMaxRedirects)}
+
+	started := time.Now()
+	record, err := lookupRecord(context.Background(), host, patterns, resolver, opts)
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("lookup took %v, want it cut short by -confirm-timeout", elapsed)
+	}
+	if !record.IsVulnerable || record.MatchedPattern != "herokuapp.com" {
+		t.Errorf("record = %+v, want it still vulnerable", record)
+	}
+	if record.Confirmed || record.ConfirmMatch != "" {
+		t.Errorf("record confirmed by %q, want unconfirmed", record.ConfirmMatch)
+	}
+	if !strings.Contains(record.ConfirmError, context.DeadlineExceeded.Error()) {
+		t.Errorf("ConfirmError = %q, want the confirmation deadline", record.ConfirmError)
+	}
+	// A failed confirmation is not a failed lookup
+	if record.Error != "" {
+		t.Errorf("Error = %q, want none", record.Error)
+	}
+	if checked, confirmed, _ := opts.Confirmer.stats(); checked != 1 || confirmed != 0 {
+		t.Errorf("stats = %d checked, %d confirmed; want 1, 0", checked, confirmed)
+	}
+}
+
+func TestConfirmTimeoutCoversHTTPSAndHTTP(t *testing.T) {
+	// A host that accepts connections and never answers, over HTTPS or HTTP
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	const timeout = 300 * time.Millisecond
+	c := newConfirmer(timeout, defaultConfirmMaxRedirects)
+	record := Record{Subdomain: listener.Addr().String(), IsVulnerable: true}
+	started := time.Now()
+	c.confirm(context.Background(), &record, Pattern{Value: "herokuapp.com", Fingerprint: "No such app"})
+	if elapsed := time.Since(started); elapsed > timeout+timeout/2 {
+		t.Errorf("confirmation took %v, want both attempts within the %v timeout", elapsed, timeout)
+	}
+	if record.Confirmed || record.ConfirmError == "" {
+		t.Errorf("record = %+v, want an unconfirmed record with its error", record)
+	}
+}
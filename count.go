@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// outcomeCounter is the recordWriter of -count-only: it tallies the outcome of every
+// record instead of writing it, to size a scan before running it in full.
+type outcomeCounter struct {
+	scanned  int
+	answered int
+	matched  int
+	dangling int
+	failed   int
+}
+
+func (c *outcomeCounter) Write(record Record) error {
+	c.scanned++
+	switch {
+	case record.Error != "":
+		c.failed++
+	case !emptyAnswer(record):
+		c.answered++
+	}
+	if record.IsVulnerable {
+		c.matched++
+	}
+	if record.Resolution == resolutionDangling {
+		c.dangling++
+	}
+	return nil
+}
+
+func (c *outcomeCounter) Close() error { return nil }
+
+// writeCounts writes the tallies as a table.
+func (c *outcomeCounter) writeCounts(output io.Writer) error {
+	tw := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOSTS\tOUTCOME")
+	fmt.Fprintf(tw, "%d\tscanned\n", c.scanned)
+	fmt.Fprintf(tw, "%d\twith a CNAME (or PTR for addresses)\n", c.answered)
+	fmt.Fprintf(tw, "%d\tmatching a pattern\n", c.matched)
+	fmt.Fprintf(tw, "%d\twith a dangling target\n", c.dangling)
+	fmt.Fprintf(tw, "%d\tfailed\n", c.failed)
+	return tw.Flush()
+}
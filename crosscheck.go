@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// crossCheckResolver answers through a primary Resolver and repeats every lookup against
+// the zone's authoritative nameservers, so records that are dangling at the authority but
+// still cached downstream show up as discrepancies.
+type crossCheckResolver struct {
+	primary Resolver
+	zones   *zoneFinder
+}
+
+// newCrossCheckResolver wraps primary with authoritative cross-checking. Nameservers are
+// found by a zoneFinder over server, cache and opts.
+func newCrossCheckResolver(primary Resolver, server string, cache *cachingResolver, opts Options) (*crossCheckResolver, error) {
+	zones, err := newZoneFinder(server, cache, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &crossCheckResolver{primary: primary, zones: zones}, nil
+}
+
+// LookupCNAME returns the primary answer with the authoritative CNAMEs attached.
+func (r *crossCheckResolver) LookupCNAME(ctx context.Context, name string) (Answer, error) {
+	answer, err := r.primary.LookupCNAME(ctx, name)
+	if err != nil {
+		return answer, err
+	}
+
+	msg, err := r.zones.queryAuthority(ctx, name, typeCNAME)
+	if err != nil {
+		return Answer{}, fmt.Errorf("error cross-checking %s: %v", name, err)
+	}
+	answer.CrossChecked = true
+	for _, rr := range msg.Answers {
+		if rr.Type == "CNAME" {
+			answer.Authority = append(answer.Authority, rr.Value)
+		}
+	}
+	return answer, nil
+}
+
+// LookupPTR returns the primary answer with the authoritative PTR names attached.
+func (r *crossCheckResolver) LookupPTR(ctx context.Context, ip string) (Answer, error) {
+	answer, err := r.primary.LookupPTR(ctx, ip)
+	if err != nil {
+		return answer, err
+	}
+
+	name, err := reverseName(ip)
+	if err != nil {
+		return Answer{}, err
+	}
+	msg, err := r.zones.queryAuthority(ctx, name, typePTR)
+	if err != nil {
+		return Answer{}, fmt.Errorf("error cross-checking %s: %v", ip, err)
+	}
+	answer.CrossChecked = true
+	for _, rr := range msg.Answers {
+		if rr.Type == "PTR" {
+			answer.Authority = append(answer.Authority, rr.Value)
+		}
+	}
+	return answer, nil
+}
+
+// LookupAddrs resolves name through the primary resolver only; chain resolution is not
+// cross-checked.
+func (r *crossCheckResolver) LookupAddrs(ctx context.Context, name string) (Answer, error) {
+	return r.primary.LookupAddrs(ctx, name)
+}
+
+// zoneFinder finds the zone enclosing a name and its authoritative nameservers, for
+// -cross-check and -check-delegation.
+type zoneFinder struct {
+	// recursive finds the zone's nameservers and their addresses.
+	recursive *nativeResolver
+	// cache, when set, holds the zone discovery and authoritative responses, so hosts in
+	// the same zone find its nameservers once.
+	cache *cachingResolver
+}
+
+// newZoneFinder returns a zoneFinder discovering nameservers through server, or the
+// system resolver when server is empty. Queries go through cache unless it is nil. The
+// discovery resolver takes the rest of its settings, such as -trace-dns, from opts.
+func newZoneFinder(server string, cache *cachingResolver, opts Options) (*zoneFinder, error) {
+	recursive, err := newNativeResolver(server, false)
+	if err != nil {
+		return nil, err
+	}
+	recursive.configure(opts)
+	return &zoneFinder{recursive: recursive, cache: cache}, nil
+}
+
+// queryAuthority asks the authoritative nameservers of name's zone, without recursion,
+// trying each nameserver in turn until one answers.
+func (r *zoneFinder) queryAuthority(ctx context.Context, name string, qtype uint16) (dnsMessage, error) {
+	servers, err := r.authoritativeServers(ctx, name)
+	if err != nil {
+		return dnsMessage{}, err
+	}
+
+	var lastErr error
+	for _, server := range servers {
+		msg, err := r.query(ctx, &nativeResolver{server: server, noRecursion: true, trace: r.recursive.trace}, name, qtype)
+		if err == nil && msg.RCode != rcodeSuccess && msg.RCode != rcodeNameError {
+			err = fmt.Errorf("server returned %s", rcodeName(msg.RCode))
+		}
+		if err == nil {
+			return msg, nil
+		}
+		lastErr = fmt.Errorf("%s: %v", server, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return dnsMessage{}, lastErr
+}
+
+// query sends one question to resolver, through the cache when there is one.
+func (r *zoneFinder) query(ctx context.Context, resolver *nativeResolver, name string, qtype uint16) (dnsMessage, error) {
+	if r.cache == nil {
+		return resolver.query(ctx, name, qtype)
+	}
+	return r.cache.message(ctx, resolver.server, name, qtype, func() (dnsMessage, error) {
+		return resolver.query(ctx, name, qtype)
+	})
+}
+
+// authoritativeServers returns the addresses of the nameservers for the zone containing name.
+func (r *zoneFinder) authoritativeServers(ctx context.Context, name string) ([]string, error) {
+	zone, hosts, glue, err := r.findZone(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return r.nameserverAddrs(ctx, zone, hosts, glue)
+}
+
+// nameserverAddrs returns the addresses of the nameservers hosts of zone, from glue or
+// looked up.
+func (r *zoneFinder) nameserverAddrs(ctx context.Context, zone string, hosts []string, glue map[string][]string) ([]string, error) {
+	var servers []string
+	for _, host := range hosts {
+		addrs := glue[normalizeHost(host)]
+		if len(addrs) == 0 {
+			msg, err := r.query(ctx, r.recursive, host, typeA)
+			if err != nil {
+				continue
+			}
+			for _, rr := range msg.Answers {
+				if rr.Type == "A" {
+					addrs = append(addrs, rr.Value)
+				}
+			}
+		}
+		for _, addr := range addrs {
+			servers = append(servers, net.JoinHostPort(addr, "53"))
+		}
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no reachable nameserver addresses for zone %s", zone)
+	}
+	return servers, nil
+}
+
+// findZone walks up from name to the closest enclosing zone with NS records and returns
+// the zone, its nameserver names and any glue addresses. A SOA in the authority section
+// of a negative answer skips straight to the zone it names.
+func (r *zoneFinder) findZone(ctx context.Context, name string) (string, []string, map[string][]string, error) {
+	candidate := normalizeHost(name)
+	for candidate != "" {
+		msg, err := r.query(ctx, r.recursive, candidate+".", typeNS)
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("error finding nameservers for %s: %v", name, err)
+		}
+
+		var hosts []string
+		for _, rr := range msg.Answers {
+			if rr.Type == "NS" && normalizeHost(rr.Name) == candidate {
+				hosts = append(hosts, rr.Value)
+			}
+		}
+		if len(hosts) > 0 {
+			glue := make(map[string][]string)
+			for _, rr := range msg.Additionals {
+				if rr.Type == "A" {
+					host := normalizeHost(rr.Name)
+					glue[host] = append(glue[host], rr.Value)
+				}
+			}
+			return candidate, hosts, glue, nil
+		}
+
+		next := ""
+		for _, rr := range msg.Authorities {
+			if rr.Type == "SOA" {
+				next = normalizeHost(rr.Name)
+				break
+			}
+		}
+		if next == "" || next == candidate || !strings.HasSuffix(candidate, "."+next) {
+			// No usable SOA hint: move up one label
+			_, next, _ = strings.Cut(candidate, ".")
+		}
+		candidate = next
+	}
+	return "", nil, nil, fmt.Errorf("no nameservers found for %s", name)
+}
+
+// answersDiffer reports whether two answers name different targets, ignoring
+// case, order and trailing dots.
+func answersDiffer(a, b []string) bool {
+	normalize := func(names []string) []string {
+		out := make([]string, 0, len(names))
+		for _, name := range names {
+			out = append(out, normalizeHost(name))
+		}
+		sort.Strings(out)
+		return out
+	}
+	return strings.Join(normalize(a), "\n") != strings.Join(normalize(b), "\n")
+}
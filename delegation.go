@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// delegationChecker looks for dangling delegations, for -check-delegation: a zone
+// delegated to nameservers at a provider that no longer serves it, which whoever
+// creates the zone at that provider can take over, whatever records the host has. The
+// nameservers of the zone enclosing each host are matched against the patterns, and a
+// matching delegation is lame when none of its nameservers answers authoritatively for
+// the zone.
+type delegationChecker struct {
+	zones    *zoneFinder
+	patterns []Pattern
+}
+
+// delegation is what a delegationChecker found for one host.
+type delegation struct {
+	zone        string
+	nameservers []string
+	// pattern is the pattern the nameservers matched, if matched is set.
+	pattern Pattern
+	matched bool
+	// lame reports that no nameserver serves the zone.
+	lame bool
+}
+
+// check finds the delegation of the zone enclosing subdomain. The zone's own
+// nameservers, found through the recursive resolver, are asked for subdomain too: a
+// referral names a zone below, delegated where the recursive resolver could not follow,
+// as a lame delegation cannot be.
+func (d *delegationChecker) check(ctx context.Context, subdomain string) (delegation, error) {
+	zone, hosts, glue, err := d.zones.findZone(ctx, subdomain)
+	if err != nil {
+		return delegation{}, err
+	}
+	if msg, err := d.zones.queryAuthority(ctx, subdomain, typeNS); err == nil && len(msg.Answers) == 0 {
+		if child, childHosts := referral(msg, zone, subdomain); child != "" {
+			zone, hosts, glue = child, childHosts, make(map[string][]string)
+			for _, rr := range msg.Additionals {
+				if rr.Type == "A" {
+					host := normalizeHost(rr.Name)
+					glue[host] = append(glue[host], rr.Value)
+				}
+			}
+		}
+	}
+
+	found := delegation{zone: zone}
+	for _, host := range hosts {
+		found.nameservers = append(found.nameservers, normalizeHost(host))
+	}
+	sort.Strings(found.nameservers)
+	found.pattern, found.matched = matchesAnyPattern(matchCandidates(strings.Join(found.nameservers, "\n"), false), d.patterns)
+	if found.matched {
+		found.lame = !d.serves(ctx, zone, hosts, glue)
+		if err := ctx.Err(); err != nil {
+			return delegation{}, err // whether it is lame is not known
+		}
+	}
+	return found, nil
+}
+
+// referral returns the zone below zone, on the way to subdomain, that msg delegates to
+// other nameservers, and their names; the zone is empty when msg is not such a referral.
+func referral(msg dnsMessage, zone, subdomain string) (string, []string) {
+	var child string
+	var hosts []string
+	for _, rr := range msg.Authorities {
+		if rr.Type != "NS" {
+			continue
+		}
+		owner := normalizeHost(rr.Name)
+		if owner == zone || !isSubdomainOf(owner, zone) || !isSubdomainOf(normalizeHost(subdomain), owner) {
+			continue
+		}
+		if child != "" && owner != child {
+			continue
+		}
+		child = owner
+		hosts = append(hosts, rr.Value)
+	}
+	return child, hosts
+}
+
+// isSubdomainOf reports whether name is zone or a name under it.
+func isSubdomainOf(name, zone string) bool {
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// serves reports whether any of the nameservers hosts answers authoritatively for zone.
+// Nameservers without addresses do not.
+func (d *delegationChecker) serves(ctx context.Context, zone string, hosts []string, glue map[string][]string) bool {
+	servers, err := d.zones.nameserverAddrs(ctx, zone, hosts, glue)
+	if err != nil {
+		return false
+	}
+	for _, server := range servers {
+		msg, err := d.zones.query(ctx, &nativeResolver{server: server, noRecursion: true, trace: d.zones.recursive.trace}, zone+".", typeSOA)
+		if err == nil && msg.RCode == rcodeSuccess && msg.Authoritative {
+			return true
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return false
+}
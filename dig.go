@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// defaultDigPath is the dig command run when no -dig-path is given.
+const defaultDigPath = "dig"
+
+const (
+	// maxDigDetail bounds the dig diagnostics added to an error in normal runs.
+	maxDigDetail = 200
+	// maxDigVerboseDetail bounds them with -v.
+	maxDigVerboseDetail = 4 << 10
+)
+
+// digResolver looks up CNAME records by running the dig command.
+type digResolver struct {
+	server      string
+	noRecursion bool
+	// path is the dig binary and extraArgs are options added to every query.
+	path      string
+	extraArgs []string
+	// verbose puts all of dig's diagnostics into errors instead of only the first line.
+	verbose bool
+}
+
+// LookupCNAME returns the CNAME records for name using dig.
+func (r *digResolver) LookupCNAME(ctx context.Context, name string) (Answer, error) {
+	return getCNAMERecord(ctx, name, r)
+}
+
+// LookupPTR returns the PTR records for an IP address using dig.
+func (r *digResolver) LookupPTR(ctx context.Context, ip string) (Answer, error) {
+	return getPTRRecord(ctx, ip, r)
+}
+
+// errDigNotFound is reported once, before scanning, when the dig backend is chosen but
+// dig is not installed.
+var errDigNotFound = errors.New("dig not found; install bind-utils or use -use-native")
+
+// checkDig verifies that the dig command at path exists: a bare name is looked up on
+// PATH, anything else must be an executable file.
+func checkDig(path string) error {
+	if _, err := exec.LookPath(path); err != nil {
+		if path == defaultDigPath {
+			return errDigNotFound
+		}
+		return fmt.Errorf("dig not found at %s: %v", path, err)
+	}
+	return nil
+}
+
+// probe checks that dig is installed and can reach the configured server.
+func (r *digResolver) probe() error {
+	if err := checkDig(r.path); err != nil {
+		return err
+	}
+
+	args := []string{"+short", "+time=2", "+tries=1"}
+	if r.server != "" {
+		args = append(args, "@"+r.server)
+	}
+	args = append(args, ".", "NS")
+	if err := exec.Command(r.path, args...).Run(); err != nil {
+		return fmt.Errorf("error executing dig command: %v", err)
+	}
+	return nil
+}
+
+// getCNAMERecord performs the dig command to get the CNAME record for a single subdomain.
+// Responses other than NOERROR and NXDOMAIN are errors, as in every dig lookup.
+func getCNAMERecord(ctx context.Context, subdomain string, dig *digResolver) (Answer, error) {
+	out, err := runDig(ctx, subdomain, []string{"CNAME", subdomain}, dig)
+	if err != nil {
+		return Answer{}, err
+	}
+	if err := digStatusError(out, dig.server, subdomain); err != nil {
+		return Answer{}, err
+	}
+	records, authoritative := parseDigRecords(out)
+	return Answer{
+		CNAMEs:        cnameTargets(subdomain, records),
+		Authoritative: authoritative,
+		AnsweredBy:    parseDigServer(out),
+		Records:       records,
+		RCode:         parseDigStatus(out),
+	}, nil
+}
+
+// getPTRRecord performs the dig command to get the PTR records for an IP address.
+func getPTRRecord(ctx context.Context, ip string, dig *digResolver) (Answer, error) {
+	out, err := runDig(ctx, ip, []string{"-x", ip}, dig)
+	if err != nil {
+		return Answer{}, err
+	}
+	if err := digStatusError(out, dig.server, ip); err != nil {
+		return Answer{}, err
+	}
+	names, authoritative := parseDigAnswer(out, "PTR")
+	return Answer{PTRs: names, Authoritative: authoritative, AnsweredBy: parseDigServer(out), RCode: parseDigStatus(out)}, nil
+}
+
+// LookupAddrs returns the A addresses of name, or its AAAA addresses when it has no A
+// records, using dig.
+func (r *digResolver) LookupAddrs(ctx context.Context, name string) (Answer, error) {
+	answer := Answer{}
+	for _, rrType := range []string{"A", "AAAA"} {
+		// The authority section carries the zone's SOA when name does not exist
+		out, err := runDig(ctx, name, []string{"+authority", rrType, name}, r)
+		if err != nil {
+			return Answer{}, err
+		}
+		if err := digStatusError(out, r.server, name); err != nil {
+			return Answer{}, err
+		}
+		status := parseDigStatus(out)
+
+		records, authoritative := parseDigRecords(out)
+		addrs := terminalValues(name, records, rrType)
+		answer = Answer{
+			Addrs:         addrs,
+			Authoritative: authoritative,
+			AnsweredBy:    parseDigServer(out),
+			Records:       records,
+			NXDomain:      status == "NXDOMAIN",
+			Authorities:   parseDigAuthority(out),
+			RCode:         status,
+		}
+		if len(addrs) > 0 || status == "NXDOMAIN" {
+			break
+		}
+	}
+	return answer, nil
+}
+
+// digStatusError returns an *rcodeError when the status of dig output is neither NOERROR
+// nor NXDOMAIN, so the dig backend fails the same answers as the native one. Output
+// without a header, such as +short output, has no status and passes.
+func digStatusError(out, server, name string) error {
+	status := parseDigStatus(out)
+	if status == "" || status == "NOERROR" || status == "NXDOMAIN" {
+		return nil
+	}
+	return &rcodeError{server: server, name: name, rcode: status}
+}
+
+// runDig runs dig with the given query arguments and returns its output. The answer
+// section is printed together with the header comments, for the authoritative flag, and
+// the statistics, for the SERVER line; the resolver's extra arguments come after those,
+// so they can override them. The dig process is killed when ctx is done.
+func runDig(ctx context.Context, name string, query []string, dig *digResolver) (string, error) {
+	var args []string
+	if dig.server != "" {
+		args = append(args, "@"+dig.server)
+	}
+	if dig.noRecursion {
+		args = append(args, "+norecurse")
+	}
+	args = append(args, "+noall", "+comments", "+answer", "+stats")
+	args = append(args, dig.extraArgs...)
+	args = append(args, query...)
+
+	cmd := exec.CommandContext(ctx, dig.path, args...)
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		err = contextError(ctx, err)
+		if detail := digDetail(stderr.String(), out.String(), dig.verbose); detail != "" && ctx.Err() == nil {
+			return "", fmt.Errorf("error executing dig command for %s: %v: %s", name, err, detail)
+		}
+		return "", fmt.Errorf("error executing dig command for %s: %v", name, err)
+	}
+	return out.String(), nil
+}
+
+// digDetail returns what dig said about a failed query: its stderr, or the last line of
+// its output when stderr is empty, since dig reports timeouts such as "no servers could
+// be reached" on stdout. Only the first line is kept unless verbose is set, in which case
+// every line is, joined with "; ".
+func digDetail(stderr, stdout string, verbose bool) string {
+	var lines []string
+	for _, line := range strings.Split(stderr, "\n") {
+		if line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), ";;")); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		for _, line := range strings.Split(stdout, "\n") {
+			if line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), ";;")); line != "" {
+				lines = []string{line}
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+
+	detail, limit := lines[0], maxDigDetail
+	if verbose {
+		detail, limit = strings.Join(lines, "; "), maxDigVerboseDetail
+	}
+	if len(detail) > limit {
+		detail = detail[:limit] + "..."
+	}
+	return detail
+}
+
+// parseDigAnswer extracts the values of rrType records and the authoritative-answer flag
+// from dig output printed with +comments +answer.
+func parseDigAnswer(output string, rrType string) ([]string, bool) {
+	records, authoritative := parseDigRecords(output)
+	var values []string
+	for _, rr := range records {
+		if strings.EqualFold(rr.Type, rrType) {
+			values = append(values, rr.Value)
+		}
+	}
+	return values, authoritative
+}
+
+// dnsClasses are the class mnemonics dig may print between the TTL and the type.
+var dnsClasses = map[string]bool{"IN": true, "CH": true, "HS": true, "ANY": true}
+
+// parseDigRecords parses the answer section of dig output into typed records, along with
+// the authoritative-answer flag from the header. Answer lines look like
+// "name. 300 IN CNAME target."; the TTL and class may be missing (+nottlid, +noclass).
+func parseDigRecords(output string) ([]ResourceRecord, bool) {
+	answers, _, authoritative := parseDigSections(output)
+	return answers, authoritative
+}
+
+// parseDigAuthority parses the authority section of dig output printed with +authority.
+func parseDigAuthority(output string) []ResourceRecord {
+	_, authorities, _ := parseDigSections(output)
+	return authorities
+}
+
+// parseDigSections parses the answer and authority records of dig output, told apart by
+// the section comments +comments prints, and the authoritative-answer flag. Records
+// before any section comment are answers; additional records are skipped.
+func parseDigSections(output string) ([]ResourceRecord, []ResourceRecord, bool) {
+	var answers, authorities []ResourceRecord
+	authoritative := false
+	section := &answers
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, ";; flags:") {
+			flags := strings.TrimPrefix(line, ";; flags:")
+			if i := strings.Index(flags, ";"); i >= 0 {
+				flags = flags[:i]
+			}
+			for _, flag := range strings.Fields(flags) {
+				if flag == "aa" {
+					authoritative = true
+				}
+			}
+			continue
+		}
+		switch line {
+		case ";; ANSWER SECTION:":
+			section = &answers
+		case ";; AUTHORITY SECTION:":
+			section = &authorities
+		case ";; ADDITIONAL SECTION:":
+			section = nil
+		}
+		if line == "" || strings.HasPrefix(line, ";") || section == nil {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		rr := ResourceRecord{Name: fields[0]}
+		rest := fields[1:]
+		if len(rest) > 0 {
+			if ttl, err := strconv.ParseUint(rest[0], 10, 32); err == nil {
+				rr.TTL = uint32(ttl)
+				rest = rest[1:]
+			}
+		}
+		if len(rest) > 0 && dnsClasses[strings.ToUpper(rest[0])] {
+			rest = rest[1:]
+		}
+		if len(rest) < 2 {
+			continue
+		}
+		rr.Type = strings.ToUpper(rest[0])
+		rr.Value = strings.Join(rest[1:], " ")
+		*section = append(*section, rr)
+	}
+
+	return answers, authorities, authoritative
+}
+
+// parseDigServer returns the nameserver address from dig's ";; SERVER: 192.0.2.1#53(...)"
+// statistics line, or "" when there is none.
+func parseDigServer(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, ";; SERVER:") {
+			continue
+		}
+		server := strings.TrimSpace(strings.TrimPrefix(line, ";; SERVER:"))
+		if i := strings.IndexAny(server, "( "); i >= 0 {
+			server = server[:i]
+		}
+		if host, port, ok := strings.Cut(server, "#"); ok {
+			return net.JoinHostPort(host, port)
+		}
+		return server
+	}
+	return ""
+}
+
+// parseDigStatus returns the response code from dig's ";; ->>HEADER<<- ... status: NOERROR,"
+// comment line, or "" when there is none.
+func parseDigStatus(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		_, status, ok := strings.Cut(line, "status: ")
+		if !ok || !strings.Contains(line, "HEADER") {
+			continue
+		}
+		status, _, _ = strings.Cut(status, ",")
+		return strings.TrimSpace(status)
+	}
+	return ""
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// digChainOutput is dig +noall +comments +answer output for a host whose CNAME chain
+// ends in two addresses.
+const digChainOutput = `
+; <<>> DiG 9.18.18 <<>> +noall +comments +answer www.example.com CNAME
+;; global options: +cmd
+;; Got answer:
+;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 40627
+;; flags: qr aa rd ra; QUERY: 1, ANSWER: 4, AUTHORITY: 0, ADDITIONAL: 1
+
+;; OPT PSEUDOSECTION:
+; EDNS: version: 0, flags:; udp: 1232
+;; ANSWER SECTION:
+www.example.com.	300	IN	CNAME	shop.example.net.
+shop.example.net.	60	IN	CNAME	d111111abcdef8.cloudfront.net.
+d111111abcdef8.cloudfront.net. 20 IN	A	192.0.2.10
+d111111abcdef8.cloudfront.net. 20 IN	A	192.0.2.11
+
+;; Query time: 3 msec
+;; SERVER: 192.0.2.53#53(192.0.2.53) (UDP)
+`
+
+// digNXDomainOutput is dig output for a name that does not exist, with the zone's SOA
+// in the authority section.
+const digNXDomainOutput = `
+;; ->>HEADER<<- opcode: QUERY, status: NXDOMAIN, id: 1101
+;; flags: qr rd ra; QUERY: 1, ANSWER: 1, AUTHORITY: 1, ADDITIONAL: 1
+
+;; ANSWER SECTION:
+old.example.com.	300	IN	CNAME	gone.herokuapp.com.
+
+;; AUTHORITY SECTION:
+herokuapp.com.	900	IN	SOA	ns-1.awsdns.com. hostmaster.amazon.com. 1 7200 900 1209600 86400
+
+;; ADDITIONAL SECTION:
+ns-1.awsdns.com.	300	IN	A	192.0.2.1
+`
+
+// digServFailOutput is dig output for a query the server failed to answer.
+const digServFailOutput = `
+;; ->>HEADER<<- opcode: QUERY, status: SERVFAIL, id: 5120
+;; flags: qr rd ra; QUERY: 1, ANSWER: 0, AUTHORITY: 0, ADDITIONAL: 1
+
+;; Query time: 1203 msec
+;; SERVER: 192.0.2.53#53(192.0.2.53) (UDP)
+`
+
+// fakeDig returns a dig command that prints output whatever it is asked.
+func fakeDig(t *testing.T, output string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake dig is a shell script")
+	}
+	dir := t.TempDir()
+	capture := filepath.Join(dir, "capture.txt")
+	if err := os.WriteFile(capture, []byte(output), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "dig")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\ncat '"+capture+"'\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDigBackendFailsOnErrorStatus(t *testing.T) {
+	dig := &digResolver{server: "192.0.2.53", path: fakeDig(t, digServFailOutput)}
+	ctx := context.Background()
+	for name, lookup := range map[string]func() (Answer, error){
+		"CNAME": func() (Answer, error) { return dig.LookupCNAME(ctx, "www.example.com") },
+		"PTR":   func() (Answer, error) { return dig.LookupPTR(ctx, "192.0.2.1") },
+		"A":     func() (Answer, error) { return dig.LookupAddrs(ctx, "www.example.com") },
+	} {
+		_, err := lookup()
+		var rcodeErr *rcodeError
+		if !errors.As(err, &rcodeErr) || rcodeErr.rcode != "SERVFAIL" {
+			t.Errorf("%s lookup error = %v, want a SERVFAIL rcodeError", name, err)
+		}
+	}
+}
+
+func TestDigBackendNXDomainIsAnAnswer(t *testing.T) {
+	dig := &digResolver{path: fakeDig(t, digNXDomainOutput)}
+	answer, err := dig.LookupCNAME(context.Background(), "old.example.com")
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if answer.RCode != "NXDOMAIN" || len(answer.CNAMEs) != 1 {
+		t.Errorf("answer = %+v, want the NXDOMAIN answer with its CNAME", answer)
+	}
+}
+
+func TestReplayFailsOnErrorStatus(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "www.example.com.txt"), []byte(digServFailOutput), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := (&replayResolver{dir: dir}).LookupCNAME(context.Background(), "www.example.com")
+	var rcodeErr *rcodeError
+	if !errors.As(err, &rcodeErr) || rcodeErr.rcode != "SERVFAIL" {
+		t.Errorf("replay error = %v, want a SERVFAIL rcodeError", err)
+	}
+}
+
+func TestParseDigRecords(t *testing.T) {
+	records, authoritative := parseDigRecords(digChainOutput)
+	want := []ResourceRecord{
+		{Name: "www.example.com.", Type: "CNAME", TTL: 300, Value: "shop.example.net."},
+		{Name: "shop.example.net.", Type: "CNAME", TTL: 60, Value: "d111111abcdef8.cloudfront.net."},
+		{Name: "d111111abcdef8.cloudfront.net.", Type: "A", TTL: 20, Value: "192.0.2.10"},
+		{Name: "d111111abcdef8.cloudfront.net.", Type: "A", TTL: 20, Value: "192.0.2.11"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %+v, want %+v", records, want)
+	}
+	if !authoritative {
+		t.Error("aa flag not reported")
+	}
+	if server := parseDigServer(digChainOutput); server != "192.0.2.53:53" {
+		t.Errorf("server = %q, want 192.0.2.53:53", server)
+	}
+	if status := parseDigStatus(digChainOutput); status != "NOERROR" {
+		t.Errorf("status = %q, want NOERROR", status)
+	}
+}
+
+func TestParseDigRecordsWithoutTTLOrClass(t *testing.T) {
+	// +nottlid +noclass output, and a line too short to be a record
+	output := "www.example.com. CNAME app.herokuapp.com.\napp.herokuapp.com. A 192.0.2.7\nbroken.example.com.\n"
+	records, authoritative := parseDigRecords(output)
+	want := []ResourceRecord{
+		{Name: "www.example.com.", Type: "CNAME", Value: "app.herokuapp.com."},
+		{Name: "app.herokuapp.com.", Type: "A", Value: "192.0.2.7"},
+	}
+	if !reflect.DeepEqual(records, want) || authoritative {
+		t.Errorf("records = %+v, %v; want %+v, false", records, authoritative, want)
+	}
+}
+
+func TestParseDigSections(t *testing.T) {
+	answers, authorities, authoritative := parseDigSections(digNXDomainOutput)
+	if len(answers) != 1 || answers[0].Value != "gone.herokuapp.com." {
+		t.Errorf("answers = %+v, want the CNAME alone", answers)
+	}
+	if len(authorities) != 1 || authorities[0].Type != "SOA" || soaMinimum(authorities) != 86400 {
+		t.Errorf("authorities = %+v, want the SOA with minimum 86400", authorities)
+	}
+	if authoritative {
+		t.Error("aa flag reported without one")
+	}
+	if status := parseDigStatus(digNXDomainOutput); status != "NXDOMAIN" {
+		t.Errorf("status = %q, want NXDOMAIN", status)
+	}
+}
+
+func TestParseDigAnswerSeparatesCNAMEsFromAddresses(t *testing.T) {
+	cnames, _ := parseDigAnswer(digChainOutput, "CNAME")
+	if want := []string{"shop.example.net.", "d111111abcdef8.cloudfront.net."}; !reflect.DeepEqual(cnames, want) {
+		t.Errorf("CNAMEs = %q, want %q", cnames, want)
+	}
+	addrs, _ := parseDigAnswer(digChainOutput, "a")
+	if want := []string{"192.0.2.10", "192.0.2.11"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("addresses = %q, want %q", addrs, want)
+	}
+}
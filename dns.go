@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DNS record types understood by the native resolver.
+const (
+	typeA     uint16 = 1
+	typeNS    uint16 = 2
+	typeCNAME uint16 = 5
+	typeSOA   uint16 = 6
+	typePTR   uint16 = 12
+	typeTXT   uint16 = 16
+	typeAAAA  uint16 = 28
+	typeDNAME uint16 = 39
+	typeOPT   uint16 = 41
+
+	classINET uint16 = 1
+)
+
+var typeNames = map[uint16]string{
+	typeA:     "A",
+	typeNS:    "NS",
+	typeCNAME: "CNAME",
+	typeSOA:   "SOA",
+	typePTR:   "PTR",
+	typeTXT:   "TXT",
+	typeAAAA:  "AAAA",
+	typeDNAME: "DNAME",
+	typeOPT:   "OPT",
+}
+
+// typeName returns the mnemonic for a record type, or the RFC 3597 TYPEnnn form.
+func typeName(t uint16) string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+// DNS response codes.
+const (
+	rcodeSuccess        = 0
+	rcodeFormatError    = 1
+	rcodeServerFailure  = 2
+	rcodeNameError      = 3
+	rcodeNotImplemented = 4
+	rcodeRefused        = 5
+)
+
+var rcodeNames = map[int]string{
+	rcodeSuccess:        "NOERROR",
+	rcodeFormatError:    "FORMERR",
+	rcodeServerFailure:  "SERVFAIL",
+	rcodeNameError:      "NXDOMAIN",
+	rcodeNotImplemented: "NOTIMP",
+	rcodeRefused:        "REFUSED",
+}
+
+// rcodeName returns the mnemonic for a response code.
+func rcodeName(rcode int) string {
+	if name, ok := rcodeNames[rcode]; ok {
+		return name
+	}
+	return "RCODE" + strconv.Itoa(rcode)
+}
+
+// ednsBufferSize is the UDP payload size advertised in queries, small enough to avoid fragmentation.
+const ednsBufferSize = 1232
+
+// maxCompressionPointers bounds how many compression pointers decodeName follows, guarding against loops.
+const maxCompressionPointers = 64
+
+// ResourceRecord is a single DNS record in presentation form, e.g. a CNAME whose Value is the target name.
+type ResourceRecord struct {
+	Name  string
+	Type  string
+	TTL   uint32
+	Value string
+}
+
+// dnsMessage is a decoded DNS response.
+type dnsMessage struct {
+	ID                 uint16
+	Response           bool
+	Authoritative      bool
+	Truncated          bool
+	RecursionDesired   bool
+	RecursionAvailable bool
+	RCode              int
+	Answers            []ResourceRecord
+	Authorities        []ResourceRecord
+	Additionals        []ResourceRecord
+}
+
+// buildQuery encodes a query for name and qtype with an EDNS0 OPT record. The
+// recursion-desired bit is set when recursive is true.
+func buildQuery(id uint16, name string, qtype uint16, recursive bool) ([]byte, error) {
+	encodedName, err := encodeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := make([]byte, 12, 12+len(encodedName)+4+11)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	if recursive {
+		msg[2] = 0x01 // RD
+	}
+	binary.BigEndian.PutUint16(msg[4:], 1)  // QDCOUNT
+	binary.BigEndian.PutUint16(msg[10:], 1) // ARCOUNT
+
+	msg = append(msg, encodedName...)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, classINET)
+
+	// OPT pseudo-record: root name, type, UDP size as class, zero extended RCODE/flags, no options
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, typeOPT)
+	msg = binary.BigEndian.AppendUint16(msg, ednsBufferSize)
+	msg = binary.BigEndian.AppendUint32(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, 0)
+
+	return msg, nil
+}
+
+// encodeName converts a dotted name into DNS wire format.
+func encodeName(name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return []byte{0}, nil
+	}
+
+	encoded := make([]byte, 0, len(name)+2)
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return nil, fmt.Errorf("invalid name %q: empty label", name)
+		}
+		if len(label) > 63 {
+			return nil, fmt.Errorf("invalid name %q: label longer than 63 bytes", name)
+		}
+		encoded = append(encoded, byte(len(label)))
+		encoded = append(encoded, label...)
+	}
+	encoded = append(encoded, 0)
+
+	if len(encoded) > 255 {
+		return nil, fmt.Errorf("invalid name %q: longer than 255 bytes", name)
+	}
+	return encoded, nil
+}
+
+var errShortMessage = errors.New("dns message too short")
+
+// decodeName reads a possibly compressed name starting at off and returns it in
+// presentation form with a trailing dot, along with the offset just past the name.
+func decodeName(msg []byte, off int) (string, int, error) {
+	var b strings.Builder
+	end := -1
+	pointers := 0
+
+	for {
+		if off >= len(msg) {
+			return "", 0, errShortMessage
+		}
+		length := int(msg[off])
+
+		switch length & 0xC0 {
+		case 0x00:
+			if length == 0 {
+				if end < 0 {
+					end = off + 1
+				}
+				if b.Len() == 0 {
+					return ".", end, nil
+				}
+				return b.String(), end, nil
+			}
+			if off+1+length > len(msg) {
+				return "", 0, errShortMessage
+			}
+			writeLabel(&b, msg[off+1:off+1+length])
+			b.WriteByte('.')
+			if b.Len() > 1024 {
+				return "", 0, errors.New("dns name too long")
+			}
+			off += 1 + length
+		case 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, errShortMessage
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			pointers++
+			if pointers > maxCompressionPointers {
+				return "", 0, errors.New("too many compression pointers in dns name")
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+		default:
+			return "", 0, fmt.Errorf("unsupported dns label type 0x%02x", length&0xC0)
+		}
+	}
+}
+
+// writeLabel appends a label to b, escaping dots, backslashes and non-printable bytes the way dig does.
+func writeLabel(b *strings.Builder, label []byte) {
+	for _, c := range label {
+		switch {
+		case c == '.' || c == '\\' || c == '"' || c == ';' || c == '(' || c == ')' || c == '@' || c == '$':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x21 || c > 0x7E:
+			fmt.Fprintf(b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// parseMessage decodes a DNS message.
+func parseMessage(msg []byte) (dnsMessage, error) {
+	var m dnsMessage
+	if len(msg) < 12 {
+		return m, errShortMessage
+	}
+
+	m.ID = binary.BigEndian.Uint16(msg[0:])
+	flags := binary.BigEndian.Uint16(msg[2:])
+	m.Response = flags&0x8000 != 0
+	m.Authoritative = flags&0x0400 != 0
+	m.Truncated = flags&0x0200 != 0
+	m.RecursionDesired = flags&0x0100 != 0
+	m.RecursionAvailable = flags&0x0080 != 0
+	m.RCode = int(flags & 0x000F)
+
+	qdCount := int(binary.BigEndian.Uint16(msg[4:]))
+	anCount := int(binary.BigEndian.Uint16(msg[6:]))
+	nsCount := int(binary.BigEndian.Uint16(msg[8:]))
+	arCount := int(binary.BigEndian.Uint16(msg[10:]))
+
+	off := 12
+	for i := 0; i < qdCount; i++ {
+		_, next, err := decodeName(msg, off)
+		if err != nil {
+			return m, err
+		}
+		off = next + 4
+		if off > len(msg) {
+			return m, errShortMessage
+		}
+	}
+
+	var err error
+	if m.Answers, off, err = parseRecords(msg, off, anCount); err != nil {
+		return m, err
+	}
+	if m.Authorities, off, err = parseRecords(msg, off, nsCount); err != nil {
+		return m, err
+	}
+	if m.Additionals, _, err = parseRecords(msg, off, arCount); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+// parseRecords decodes count resource records starting at off.
+func parseRecords(msg []byte, off, count int) ([]ResourceRecord, int, error) {
+	var records []ResourceRecord
+	for i := 0; i < count; i++ {
+		name, next, err := decodeName(msg, off)
+		if err != nil {
+			return nil, 0, err
+		}
+		off = next
+		if off+10 > len(msg) {
+			return nil, 0, errShortMessage
+		}
+
+		rrType := binary.BigEndian.Uint16(msg[off:])
+		ttl := binary.BigEndian.Uint32(msg[off+4:])
+		length := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+length > len(msg) {
+			return nil, 0, errShortMessage
+		}
+
+		value, err := decodeRData(msg, off, length, rrType)
+		if err != nil {
+			return nil, 0, err
+		}
+		records = append(records, ResourceRecord{Name: name, Type: typeName(rrType), TTL: ttl, Value: value})
+		off += length
+	}
+	return records, off, nil
+}
+
+// decodeRData renders the data of a record in presentation form.
+func decodeRData(msg []byte, off, length int, rrType uint16) (string, error) {
+	data := msg[off : off+length]
+
+	switch rrType {
+	case typeA:
+		if length != net.IPv4len {
+			return "", fmt.Errorf("invalid A record length %d", length)
+		}
+		return net.IP(data).String(), nil
+	case typeAAAA:
+		if length != net.IPv6len {
+			return "", fmt.Errorf("invalid AAAA record length %d", length)
+		}
+		return net.IP(data).String(), nil
+	case typeCNAME, typeNS, typePTR, typeDNAME:
+		name, _, err := decodeName(msg, off)
+		return name, err
+	case typeSOA:
+		mname, next, err := decodeName(msg, off)
+		if err != nil {
+			return "", err
+		}
+		rname, next, err := decodeName(msg, next)
+		if err != nil {
+			return "", err
+		}
+		if next+20 > off+length {
+			return "", errShortMessage
+		}
+		return fmt.Sprintf("%s %s %d %d %d %d %d", mname, rname,
+			binary.BigEndian.Uint32(msg[next:]), binary.BigEndian.Uint32(msg[next+4:]),
+			binary.BigEndian.Uint32(msg[next+8:]), binary.BigEndian.Uint32(msg[next+12:]),
+			binary.BigEndian.Uint32(msg[next+16:])), nil
+	case typeTXT:
+		var parts []string
+		for i := 0; i < len(data); {
+			n := int(data[i])
+			if i+1+n > len(data) {
+				return "", errShortMessage
+			}
+			parts = append(parts, strconv.Quote(string(data[i+1:i+1+n])))
+			i += 1 + n
+		}
+		return strings.Join(parts, " "), nil
+	default:
+		return fmt.Sprintf("\\# %d %x", length, data), nil
+	}
+}
+
+// exchange sends query to server over UDP, retrying over TCP if the answer is truncated,
+// and returns the decoded response. The exchange is abandoned when ctx is done. With
+// trace, every message sent and received is logged by traceMessage.
+func exchange(ctx context.Context, server string, query []byte, trace bool) (dnsMessage, error) {
+	id := binary.BigEndian.Uint16(query)
+
+	if trace {
+		traceMessage("Sent DNS message", server, query)
+	}
+	response, err := exchangeUDP(ctx, server, query, id)
+	if err != nil {
+		return dnsMessage{}, err
+	}
+	if trace {
+		traceMessage("Received DNS message", server, response)
+	}
+	msg, err := parseMessage(response)
+	if err != nil {
+		return dnsMessage{}, err
+	}
+	if !msg.Truncated {
+		return msg, nil
+	}
+
+	if trace {
+		traceMessage("Sent DNS message over TCP", server, query)
+	}
+	response, err = exchangeTCP(ctx, server, query)
+	if err != nil {
+		return dnsMessage{}, err
+	}
+	if trace {
+		traceMessage("Received DNS message over TCP", server, response)
+	}
+	return parseMessage(response)
+}
+
+// dialContext connects to server and ties the connection's deadline to ctx, so reads and
+// writes fail as soon as ctx is cancelled or expires. Without a ctx deadline,
+// defaultQueryTimeout applies. The returned stop function must be called when done.
+func dialContext(ctx context.Context, network, server string) (net.Conn, func(), error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultQueryTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	stopAfter := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	return conn, func() {
+		stopAfter()
+		conn.Close()
+	}, nil
+}
+
+// contextError returns ctx's error in place of err when ctx ended the exchange, so a
+// cancelled lookup reports "context deadline exceeded" rather than an I/O timeout.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// exchangeUDP sends query over UDP and waits for a response with the matching ID.
+func exchangeUDP(ctx context.Context, server string, query []byte, id uint16) ([]byte, error) {
+	conn, stop, err := dialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, contextError(ctx, err)
+	}
+
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		// Ignore stray datagrams that do not answer this query
+		if n >= 12 && binary.BigEndian.Uint16(buf) == id && buf[2]&0x80 != 0 {
+			return buf[:n], nil
+		}
+	}
+}
+
+// exchangeTCP sends query over TCP using the two-byte length framing.
+func exchangeTCP(ctx context.Context, server string, query []byte) ([]byte, error) {
+	conn, stop, err := dialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	response, err := exchangeStream(conn, query)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return response, nil
+}
+
+// exchangeStream writes a length-prefixed query to a stream connection and reads the length-prefixed response.
+func exchangeStream(conn net.Conn, query []byte) ([]byte, error) {
+	framed := binary.BigEndian.AppendUint16(make([]byte, 0, len(query)+2), uint16(len(query)))
+	framed = append(framed, query...)
+	if _, err := conn.Write(framed); err != nil {
+		return nil, err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	response := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// newQueryID returns a random DNS message ID.
+func newQueryID() uint16 {
+	return uint16(rand.Uint32())
+}
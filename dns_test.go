@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// wireRecord appends a resource record to msg with its name given as a compression
+// pointer to ptr and rdata as given.
+func wireRecord(msg []byte, ptr uint16, rrType uint16, ttl uint32, rdata []byte) []byte {
+	msg = binary.BigEndian.AppendUint16(msg, 0xC000|ptr)
+	msg = binary.BigEndian.AppendUint16(msg, rrType)
+	msg = binary.BigEndian.AppendUint16(msg, classINET)
+	msg = binary.BigEndian.AppendUint32(msg, ttl)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(rdata)))
+	return append(msg, rdata...)
+}
+
+// cnameResponse returns an authoritative response to "www.example.com CNAME" with ID
+// 0x1234: www.example.com CNAME app.herokuapp.com, then app.herokuapp.com A 192.0.2.7,
+// its owner compressed into the CNAME's rdata.
+func cnameResponse() []byte {
+	msg, _ := buildQuery(0x1234, "www.example.com", typeCNAME, true)
+	msg = msg[:len(msg)-11]     // drop the OPT record
+	msg[2], msg[3] = 0x85, 0x80 // QR AA RD, RA
+	binary.BigEndian.PutUint16(msg[6:], 2)
+	binary.BigEndian.PutUint16(msg[10:], 0)
+
+	target, _ := encodeName("app.herokuapp.com")
+	cnameData := len(msg) + 12 // where the CNAME's rdata, the target, starts
+	msg = wireRecord(msg, 12, typeCNAME, 300, target)
+	return wireRecord(msg, uint16(cnameData), typeA, 60, []byte{192, 0, 2, 7})
+}
+
+func TestParseMessageCNAMEChain(t *testing.T) {
+	parsed, err := parseMessage(cnameResponse())
+	if err != nil {
+		t.Fatalf("parseMessage: %v", err)
+	}
+	want := []ResourceRecord{
+		{Name: "www.example.com.", Type: "CNAME", TTL: 300, Value: "app.herokuapp.com."},
+		{Name: "app.herokuapp.com.", Type: "A", TTL: 60, Value: "192.0.2.7"},
+	}
+	if !reflect.DeepEqual(parsed.Answers, want) {
+		t.Errorf("answers = %+v, want %+v", parsed.Answers, want)
+	}
+	if parsed.ID != 0x1234 || !parsed.Response || !parsed.Authoritative || parsed.RCode != rcodeSuccess {
+		t.Errorf("header = %+v", parsed)
+	}
+}
+
+func TestDecodeNamePointerLoop(t *testing.T) {
+	// A name that points at itself must fail instead of looping
+	msg := make([]byte, 12, 14)
+	msg = append(msg, 0xC0, 12)
+	if _, _, err := decodeName(msg, 12); err == nil {
+		t.Error("self-referencing compression pointer decoded without error")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// dohContentType is the media type of DNS messages sent over HTTPS (RFC 8484).
+const dohContentType = "application/dns-message"
+
+// maxDoHResponse bounds the size of a DoH response body; DNS messages fit in 64 KiB.
+const maxDoHResponse = 1 << 16
+
+// isDoHServer reports whether server is a DNS-over-HTTPS URL rather than a nameserver address.
+func isDoHServer(server string) bool {
+	return strings.HasPrefix(server, "https://")
+}
+
+// dohClient sends DNS queries to a DNS-over-HTTPS endpoint. All queries share one
+// http.Client whose idle connections are kept for reuse, and HTTP/2 is negotiated when
+// the server offers it, so concurrent queries are multiplexed over a few connections
+// instead of paying a TLS handshake per name. RFC 8484 carries one question per request,
+// so names are not batched.
+type dohClient struct {
+	url    string
+	client *http.Client
+	// sem bounds the requests in flight; nil means no limit beyond the scan's concurrency.
+	sem chan struct{}
+	// trace logs every message sent and received, for -trace-dns.
+	trace bool
+}
+
+// newDoHClient returns a dohClient for the endpoint url.
+func newDoHClient(url string) *dohClient {
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         (&net.Dialer{Timeout: defaultQueryTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 100, // the default of 2 would reopen connections under concurrency
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: defaultQueryTimeout,
+	}
+	return &dohClient{url: url, client: &http.Client{Transport: transport}}
+}
+
+// limit allows at most n requests in flight; n <= 0 removes the limit.
+func (c *dohClient) limit(n int) {
+	c.sem = nil
+	if n > 0 {
+		c.sem = make(chan struct{}, n)
+	}
+}
+
+// exchange POSTs query to the endpoint and returns the decoded response.
+func (c *dohClient) exchange(ctx context.Context, query []byte) (dnsMessage, error) {
+	if c.sem != nil {
+		select {
+		case c.sem <- struct{}{}:
+			defer func() { <-c.sem }()
+		case <-ctx.Done():
+			return dnsMessage{}, ctx.Err()
+		}
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultQueryTimeout)
+		defer cancel()
+	}
+
+	// RFC 8484 asks for ID 0 so responses are cacheable by HTTP caches
+	query = append([]byte(nil), query...)
+	query[0], query[1] = 0, 0
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(query))
+	if err != nil {
+		return dnsMessage{}, err
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+	if c.trace {
+		traceMessage("Sent DNS message", c.url, query)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return dnsMessage{}, contextError(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	// Read the whole body so the connection can be reused
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDoHResponse))
+	if err != nil {
+		return dnsMessage{}, contextError(ctx, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return dnsMessage{}, fmt.Errorf("DoH server returned %s", resp.Status)
+	}
+	if c.trace {
+		traceMessage("Received DNS message", c.url, body)
+	}
+	return parseMessage(body)
+}
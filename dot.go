@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// dotScheme prefixes a DNS-over-TLS server in -server, as in tls://dns.example:853.
+const dotScheme = "tls://"
+
+// dotPort is the DNS-over-TLS port (RFC 7858).
+const dotPort = "853"
+
+// maxIdleDoTConns bounds the connections a dotClient keeps open between queries, as
+// MaxIdleConnsPerHost does for DoH.
+const maxIdleDoTConns = 100
+
+// isDoTServer reports whether server is a DNS-over-TLS server rather than a plain
+// nameserver address.
+func isDoTServer(server string) bool {
+	return strings.HasPrefix(server, dotScheme)
+}
+
+// parseDoTPins parses -dot-pin: comma-separated hex SHA-256 digests of the server
+// certificate's public key (its DER SubjectPublicKeyInfo), more than one so a key can
+// be rotated.
+func parseDoTPins(value string) ([][]byte, error) {
+	var pins [][]byte
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		pin, err := hex.DecodeString(strings.TrimPrefix(field, "sha256:"))
+		if err != nil || len(pin) != sha256.Size {
+			return nil, fmt.Errorf("%q is not a hex SHA-256 digest", field)
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+// dotClient sends DNS queries to a DNS-over-TLS server. The certificate is verified
+// against the system roots and the server's host name, and, when pins are set, its
+// public key must match one of them. Connections are kept after each query and reused
+// by the next one, so a scan pays for a TLS handshake per concurrent worker rather than
+// per name; each connection carries one query at a time.
+type dotClient struct {
+	server string // host:port
+	config *tls.Config
+	idle   chan *tls.Conn
+	// pins are the SHA-256 digests of accepted public keys; nil accepts any valid certificate.
+	pins [][]byte
+	// trace logs every message sent and received, for -trace-dns.
+	trace bool
+}
+
+// newDoTClient returns a dotClient for server, a tls:// address with an optional port.
+func newDoTClient(server string) *dotClient {
+	address := withDefaultPort(strings.TrimPrefix(server, dotScheme), dotPort)
+	host, _, _ := net.SplitHostPort(address)
+	c := &dotClient{server: address, idle: make(chan *tls.Conn, maxIdleDoTConns)}
+	c.config = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12, VerifyConnection: c.verifyPin}
+	return c
+}
+
+// verifyPin checks the verified certificate of a new connection against the pins.
+func (c *dotClient) verifyPin(state tls.ConnectionState) error {
+	if len(c.pins) == 0 {
+		return nil
+	}
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("DoT server sent no certificate")
+	}
+	digest := sha256.Sum256(state.PeerCertificates[0].RawSubjectPublicKeyInfo)
+	for _, pin := range c.pins {
+		if bytes.Equal(pin, digest[:]) {
+			return nil
+		}
+	}
+	return fmt.Errorf("DoT server certificate key sha256:%x matches no -dot-pin", digest)
+}
+
+// exchange sends query to the server and returns the decoded response. A query on a
+// reused connection that fails is retried once on a new one, since the server may have
+// closed the connection while it was idle.
+func (c *dotClient) exchange(ctx context.Context, query []byte) (dnsMessage, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultQueryTimeout)
+		defer cancel()
+	}
+	if c.trace {
+		traceMessage("Sent DNS message", dotScheme+c.server, query)
+	}
+
+	conn, reused, err := c.conn(ctx)
+	if err != nil {
+		return dnsMessage{}, err
+	}
+	response, err := c.roundTrip(ctx, conn, query)
+	if err != nil && reused && ctx.Err() == nil {
+		if conn, err = c.dial(ctx); err != nil {
+			return dnsMessage{}, err
+		}
+		response, err = c.roundTrip(ctx, conn, query)
+	}
+	if err != nil {
+		return dnsMessage{}, err
+	}
+	c.release(conn)
+
+	if c.trace {
+		traceMessage("Received DNS message", dotScheme+c.server, response)
+	}
+	return parseMessage(response)
+}
+
+// conn returns an idle connection, or a new one when none is idle, and whether it was reused.
+func (c *dotClient) conn(ctx context.Context) (*tls.Conn, bool, error) {
+	select {
+	case conn := <-c.idle:
+		return conn, true, nil
+	default:
+	}
+	conn, err := c.dial(ctx)
+	return conn, false, err
+}
+
+// dial opens a new TLS connection to the server.
+func (c *dotClient) dial(ctx context.Context) (*tls.Conn, error) {
+	dialer := &tls.Dialer{NetDialer: &net.Dialer{KeepAlive: 30 * time.Second}, Config: c.config}
+	conn, err := dialer.DialContext(ctx, "tcp", c.server)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return conn.(*tls.Conn), nil
+}
+
+// roundTrip sends query on conn and reads the response, within ctx. The connection is
+// closed if anything goes wrong, as its stream may be out of step.
+func (c *dotClient) roundTrip(ctx context.Context, conn *tls.Conn, query []byte) ([]byte, error) {
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	stopAfter := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	response, err := exchangeStream(conn, query)
+	if !stopAfter() {
+		err = ctx.Err() // the connection's deadline was cut short
+	}
+	if err == nil && (len(response) < 12 || binary.BigEndian.Uint16(response) != binary.BigEndian.Uint16(query)) {
+		err = errors.New("DoT response does not answer the query")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, contextError(ctx, err)
+	}
+	return response, nil
+}
+
+// release keeps conn for the next query, or closes it when enough are idle.
+func (c *dotClient) release(conn *tls.Conn) {
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return
+	}
+	select {
+	case c.idle <- conn:
+	default:
+		conn.Close()
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Elasticsearch/OpenSearch bulk indexing defaults.
+const (
+	defaultESBatchSize = 500
+	esRequestTimeout   = 30 * time.Second
+	esMaxAttempts      = 4
+	esInitialBackoff   = 500 * time.Millisecond
+)
+
+// esDocument is the indexed form of a Record, stamped with the time it was found.
+type esDocument struct {
+	Record
+	Timestamp time.Time `json:"@timestamp"`
+}
+
+// esWriter is a recordWriter that indexes records into Elasticsearch or OpenSearch through
+// the bulk API. Records are batched and sent once batchSize have accumulated, and any
+// remainder is sent on Close. Transient failures are retried with exponential backoff.
+type esWriter struct {
+	client    *http.Client
+	bulkURL   string
+	index     string
+	username  string
+	password  string
+	batchSize int
+
+	pending bytes.Buffer
+	count   int
+}
+
+// newESWriter returns an esWriter for the cluster at baseURL writing to index.
+func newESWriter(baseURL, index, username, password string, batchSize int) (*esWriter, error) {
+	if index == "" {
+		return nil, fmt.Errorf("-es-index is required with -es-url")
+	}
+	if batchSize <= 0 {
+		batchSize = defaultESBatchSize
+	}
+	return &esWriter{
+		client:    &http.Client{Timeout: esRequestTimeout},
+		bulkURL:   strings.TrimSuffix(baseURL, "/") + "/_bulk",
+		index:     index,
+		username:  username,
+		password:  password,
+		batchSize: batchSize,
+	}, nil
+}
+
+func (w *esWriter) Write(record Record) error {
+	action, err := json.Marshal(map[string]map[string]string{"index": {"_index": w.index}})
+	if err != nil {
+		return err
+	}
+	doc, err := json.Marshal(esDocument{Record: record, Timestamp: time.Now().UTC()})
+	if err != nil {
+		return fmt.Errorf("error encoding record for %s: %v", record.Subdomain, err)
+	}
+
+	w.pending.Write(action)
+	w.pending.WriteByte('\n')
+	w.pending.Write(doc)
+	w.pending.WriteByte('\n')
+	w.count++
+
+	if w.count >= w.batchSize {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *esWriter) Close() error {
+	return w.flush()
+}
+
+// flush sends the pending batch, retrying transient failures.
+func (w *esWriter) flush() error {
+	if w.count == 0 {
+		return nil
+	}
+	body := append([]byte(nil), w.pending.Bytes()...)
+	count := w.count
+	w.pending.Reset()
+	w.count = 0
+
+	backoff := esInitialBackoff
+	var lastErr error
+	for attempt := 1; attempt <= esMaxAttempts; attempt++ {
+		retry, err := w.send(body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry || attempt == esMaxAttempts {
+			break
+		}
+		slog.Warn("Bulk indexing failed, retrying", "records", count, "attempt", attempt, "max_attempts", esMaxAttempts, "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return fmt.Errorf("error indexing %d records into %s: %v", count, w.index, lastErr)
+}
+
+// send posts one bulk request and reports whether a failure is worth retrying.
+func (w *esWriter) send(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.bulkURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if w.username != "" || w.password != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return true, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("bulk API returned %s", resp.Status)
+	case resp.StatusCode >= 300:
+		return false, fmt.Errorf("bulk API returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	return bulkItemErrors(data)
+}
+
+// bulkItemErrors inspects a bulk API response for per-item failures. Items rejected with
+// 429 (queue full) make the whole batch retryable.
+func bulkItemErrors(data []byte) (bool, error) {
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return false, fmt.Errorf("error parsing bulk API response: %v", err)
+	}
+	if !result.Errors {
+		return false, nil
+	}
+
+	failed, retry := 0, false
+	var first string
+	for _, item := range result.Items {
+		for _, status := range item {
+			if status.Status < 300 {
+				continue
+			}
+			failed++
+			if status.Status == http.StatusTooManyRequests {
+				retry = true
+			}
+			if first == "" {
+				first = string(status.Error)
+			}
+		}
+	}
+	return retry, fmt.Errorf("%d items failed: %s", failed, first)
+}
+
+// multiWriter fans records out to several recordWriters.
+type multiWriter []recordWriter
+
+func (w multiWriter) Write(record Record) error {
+	for _, writer := range w {
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w multiWriter) Close() error {
+	var firstErr error
+	for _, writer := range w {
+		if err := writer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
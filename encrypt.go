@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Encrypted result files start with encryptMagic and a header:
+//
+//	magic (5 bytes) | key kind (1) | salt (16) | nonce prefix (8)
+//
+// followed by chunks of at most encryptChunkSize plaintext bytes, each sealed with
+// AES-256-GCM:
+//
+//	final flag (1) | ciphertext length (4, big endian) | ciphertext
+//
+// The nonce of a chunk is the prefix followed by its 4-byte index, and the final flag is
+// authenticated, so reordered, dropped or truncated chunks fail to decrypt.
+const (
+	encryptMagic     = "DGCE1"
+	encryptSaltSize  = 16
+	encryptChunkSize = 64 << 10
+	// pbkdf2Iterations is the PBKDF2-SHA256 work factor for passphrases.
+	pbkdf2Iterations = 600000
+	// minKeyFileSize is the least key material accepted from a key file.
+	minKeyFileSize = 16
+)
+
+// Key kinds recorded in the header, so decrypting with the wrong kind of secret gives a
+// clear error instead of an authentication failure.
+const (
+	keyKindPassphrase byte = 1
+	keyKindKeyFile    byte = 2
+)
+
+// encryptionKey is the secret an output file is encrypted with: a passphrase or the
+// contents of a key file.
+type encryptionKey struct {
+	kind   byte
+	secret []byte
+}
+
+// loadEncryptionKey returns the key given by a passphrase or a key file; exactly one of
+// them must be set.
+func loadEncryptionKey(passphrase, keyFile string) (encryptionKey, error) {
+	switch {
+	case passphrase != "" && keyFile != "":
+		return encryptionKey{}, errors.New("give either a passphrase or a key file, not both")
+	case passphrase != "":
+		return encryptionKey{kind: keyKindPassphrase, secret: []byte(passphrase)}, nil
+	case keyFile != "":
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return encryptionKey{}, fmt.Errorf("error opening file %s: %v", keyFile, err)
+		}
+		data = bytes.TrimSpace(data)
+		if len(data) < minKeyFileSize {
+			return encryptionKey{}, fmt.Errorf("key file %s holds fewer than %d bytes", keyFile, minKeyFileSize)
+		}
+		return encryptionKey{kind: keyKindKeyFile, secret: data}, nil
+	default:
+		return encryptionKey{}, errors.New("a passphrase or a key file is required")
+	}
+}
+
+// aead derives the AES-256-GCM cipher for salt: PBKDF2 stretches passphrases, key files
+// only go through HKDF.
+func (k encryptionKey) aead(salt []byte) (cipher.AEAD, error) {
+	var key []byte
+	var err error
+	if k.kind == keyKindPassphrase {
+		key, err = pbkdf2.Key(sha256.New, string(k.secret), salt, pbkdf2Iterations, 32)
+	} else {
+		key, err = hkdf.Key(sha256.New, k.secret, salt, "digcname output", 32)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptWriter encrypts everything written to it onto another writer, a chunk at a time,
+// so result files of any size are encrypted without buffering them. Close seals the final
+// chunk and must be called for the output to decrypt.
+type encryptWriter struct {
+	output io.Writer
+	aead   cipher.AEAD
+	nonce  []byte
+	index  uint32
+	buf    []byte
+}
+
+// newEncryptWriter writes the header to output and returns a writer encrypting onto it.
+func newEncryptWriter(output io.Writer, key encryptionKey) (*encryptWriter, error) {
+	header := make([]byte, len(encryptMagic)+1+encryptSaltSize+8)
+	copy(header, encryptMagic)
+	header[len(encryptMagic)] = key.kind
+	if _, err := rand.Read(header[len(encryptMagic)+1:]); err != nil {
+		return nil, err
+	}
+	salt := header[len(encryptMagic)+1 : len(encryptMagic)+1+encryptSaltSize]
+	aead, err := key.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := output.Write(header); err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, header[len(header)-8:])
+	return &encryptWriter{output: output, aead: aead, nonce: nonce, buf: make([]byte, 0, encryptChunkSize)}, nil
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), encryptChunkSize-len(w.buf))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(w.buf) == encryptChunkSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Flush seals what is buffered as a chunk of its own, so everything written so far can
+// be decrypted. Chunks may be shorter than encryptChunkSize, so flushing costs only the
+// chunk overhead.
+func (w *encryptWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	return w.seal(false)
+}
+
+// Close writes the final chunk. It does not close the underlying writer.
+func (w *encryptWriter) Close() error {
+	return w.seal(true)
+}
+
+// seal encrypts and writes the buffered plaintext as the next chunk.
+func (w *encryptWriter) seal(final bool) error {
+	if w.index == ^uint32(0) {
+		return errors.New("encrypted output too large")
+	}
+	flag := []byte{0}
+	if final {
+		flag[0] = 1
+	}
+	binary.BigEndian.PutUint32(w.nonce[len(w.nonce)-4:], w.index)
+	ciphertext := w.aead.Seal(nil, w.nonce, w.buf, flag)
+
+	var prefix [5]byte
+	prefix[0] = flag[0]
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(ciphertext)))
+	if _, err := w.output.Write(prefix[:]); err != nil {
+		return err
+	}
+	if _, err := w.output.Write(ciphertext); err != nil {
+		return err
+	}
+	w.index++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// decryptStream decrypts an encryptWriter stream from r onto w, a chunk at a time. Each
+// chunk is written only once it has been authenticated; a stream that ends before its
+// final chunk is an error.
+func decryptStream(r io.Reader, w io.Writer, key encryptionKey) error {
+	header := make([]byte, len(encryptMagic)+1+encryptSaltSize+8)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(encryptMagic)]) != encryptMagic {
+		return errors.New("not a digcname encrypted file")
+	}
+	switch kind := header[len(encryptMagic)]; {
+	case kind == keyKindPassphrase && key.kind != keyKindPassphrase:
+		return errors.New("the file was encrypted with a passphrase")
+	case kind == keyKindKeyFile && key.kind != keyKindKeyFile:
+		return errors.New("the file was encrypted with a key file")
+	case kind != keyKindPassphrase && kind != keyKindKeyFile:
+		return fmt.Errorf("unknown key kind %d", kind)
+	}
+
+	salt := header[len(encryptMagic)+1 : len(encryptMagic)+1+encryptSaltSize]
+	aead, err := key.aead(salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, header[len(header)-8:])
+
+	var prefix [5]byte
+	for index := uint32(0); ; index++ {
+		if _, err := io.ReadFull(r, prefix[:]); err != nil {
+			return errors.New("encrypted file is truncated")
+		}
+		size := binary.BigEndian.Uint32(prefix[1:])
+		if size > encryptChunkSize+uint32(aead.Overhead()) || prefix[0] > 1 {
+			return fmt.Errorf("corrupt chunk %d", index)
+		}
+		ciphertext := make([]byte, size)
+		if _, err := io.ReadFull(r, ciphertext); err != nil {
+			return errors.New("encrypted file is truncated")
+		}
+
+		binary.BigEndian.PutUint32(nonce[len(nonce)-4:], index)
+		plaintext, err := aead.Open(nil, nonce, ciphertext, prefix[:1])
+		if err != nil {
+			return fmt.Errorf("chunk %d does not decrypt: wrong key or corrupt file", index)
+		}
+		if _, err := w.Write(plaintext); err != nil {
+			return err
+		}
+
+		if prefix[0] == 1 {
+			if n, _ := r.Read(make([]byte, 1)); n > 0 {
+				return errors.New("unexpected data after the final chunk")
+			}
+			return nil
+		}
+	}
+}
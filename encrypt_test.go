@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testKey is a key file key, which skips the passphrase work factor.
+var testKey = encryptionKey{kind: keyKindKeyFile, secret: []byte("0123456789abcdef0123456789abcdef")}
+
+// encryptForTest encrypts chunks, flushing after each one, and returns the stream.
+func encryptForTest(t *testing.T, key encryptionKey, chunks ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := newEncryptWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range chunks {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	large := strings.Repeat("shop.example.com,gone.herokuapp.com\n", 2*encryptChunkSize/36+100)
+	tests := []struct {
+		name   string
+		key    encryptionKey
+		chunks []string
+	}{
+		{"empty", testKey, nil},
+		{"flushed lines", testKey, []string{"first line\n", "", "second line\n"}},
+		{"several chunks", testKey, []string{large}},
+		{"passphrase", encryptionKey{kind: keyKindPassphrase, secret: []byte("correct horse")}, []string{"line\n"}},
+	}
+	for _, tt := range tests {
+		stream := encryptForTest(t, tt.key, tt.chunks...)
+		if bytes.Contains(stream, []byte("line")) || bytes.Contains(stream, []byte("example")) {
+			t.Errorf("%s: stream holds plaintext", tt.name)
+		}
+		var out bytes.Buffer
+		if err := decryptStream(bytes.NewReader(stream), &out, tt.key); err != nil {
+			t.Errorf("%s: decryptStream: %v", tt.name, err)
+			continue
+		}
+		if want := strings.Join(tt.chunks, ""); out.String() != want {
+			t.Errorf("%s: decrypted %d bytes, want %d", tt.name, out.Len(), len(want))
+		}
+	}
+}
+
+func TestDecryptRejectsDamagedStreams(t *testing.T) {
+	stream := encryptForTest(t, testKey, "first\n", "second\n")
+	header := len(encryptMagic) + 1 + encryptSaltSize + 8
+	finalChunk := 5 + 16 // an empty final chunk is its prefix and the GCM tag
+	flipped := bytes.Clone(stream)
+	flipped[header+7] ^= 1
+
+	tests := []struct {
+		name   string
+		stream []byte
+		key    encryptionKey
+		want   string
+	}{
+		{"empty", nil, testKey, "not a digcname encrypted file"},
+		{"short header", stream[:header-1], testKey, "not a digcname encrypted file"},
+		{"no chunks", stream[:header], testKey, "truncated"},
+		{"cut in a prefix", stream[:header+2], testKey, "truncated"},
+		{"cut in a chunk", stream[:header+8], testKey, "truncated"},
+		{"final chunk dropped", stream[:len(stream)-finalChunk], testKey, "truncated"},
+		{"final chunk cut", stream[:len(stream)-1], testKey, "truncated"},
+		{"trailing data", append(bytes.Clone(stream), 0), testKey, "after the final chunk"},
+		{"flipped bit", flipped, testKey, "chunk 0 does not decrypt"},
+		{"wrong key", stream, encryptionKey{kind: keyKindKeyFile, secret: []byte("fedcba9876543210fedcba9876543210")}, "chunk 0 does not decrypt"},
+		{"wrong kind", stream, encryptionKey{kind: keyKindPassphrase, secret: testKey.secret}, "encrypted with a key file"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		err := decryptStream(bytes.NewReader(tt.stream), &out, tt.key)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: decryptStream error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	// Chunks before the cut are authenticated and written; nothing after them is
+	var out bytes.Buffer
+	decryptStream(bytes.NewReader(stream[:len(stream)-finalChunk]), &out, testKey)
+	if out.String() != "first\nsecond\n" {
+		t.Errorf("truncated stream decrypted to %q, want the complete chunks", out.String())
+	}
+}
+
+func TestLoadEncryptionKey(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	shortFile := filepath.Join(dir, "short")
+	if err := os.WriteFile(keyFile, []byte("0123456789abcdef\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(shortFile, []byte("0123456789abcde\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := loadEncryptionKey("", keyFile)
+	if err != nil || key.kind != keyKindKeyFile || string(key.secret) != "0123456789abcdef" {
+		t.Errorf("loadEncryptionKey(key file) = %+v, %v, want the trimmed file contents", key, err)
+	}
+	tests := []struct {
+		passphrase, keyFile string
+		want                string
+	}{
+		{"secret", keyFile, "not both"},
+		{"", "", "required"},
+		{"", shortFile, "fewer than 16 bytes"},
+		{"", filepath.Join(dir, "missing"), "error opening file"},
+	}
+	for _, tt := range tests {
+		if _, err := loadEncryptionKey(tt.passphrase, tt.keyFile); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("loadEncryptionKey(%q, %q) error = %v, want %q", tt.passphrase, tt.keyFile, err, tt.want)
+		}
+	}
+}
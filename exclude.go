@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+)
+
+// exclusions holds subdomain patterns that are skipped before any query is made.
+// A pattern starting with "*." or "." excludes every name under that suffix;
+// any other pattern excludes that exact name.
+type exclusions struct {
+	exact    map[string]bool
+	suffixes []string
+}
+
+// parseExclusions builds exclusions from exclude file lines.
+func parseExclusions(lines []string) exclusions {
+	e := exclusions{exact: make(map[string]bool)}
+	for _, line := range lines {
+		pattern := normalizeHost(line)
+		switch {
+		case strings.HasPrefix(pattern, "*."):
+			e.suffixes = append(e.suffixes, pattern[1:])
+		case strings.HasPrefix(pattern, "."):
+			e.suffixes = append(e.suffixes, pattern)
+		case pattern != "":
+			e.exact[pattern] = true
+		}
+	}
+	return e
+}
+
+// excludes reports whether the subdomain matches any exclusion.
+func (e exclusions) excludes(subdomain string) bool {
+	host := normalizeHost(subdomain)
+	if e.exact[host] {
+		return true
+	}
+	for _, suffix := range e.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterExcluded returns the subdomains that are not excluded and the number that were.
+// Each excluded subdomain is logged when verbose is set.
+func filterExcluded(subdomains []string, e exclusions, verbose bool) ([]string, int) {
+	kept := make([]string, 0, len(subdomains))
+	excluded := 0
+	for _, subdomain := range subdomains {
+		if e.excludes(subdomain) {
+			excluded++
+			if verbose {
+				slog.Info("Excluding host", "host", subdomain)
+			}
+			continue
+		}
+		kept = append(kept, subdomain)
+	}
+	return kept, excluded
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// exitCode is the process exit status of digcname, distinct per outcome so wrapper
+// scripts and CI jobs can branch on it.
+type exitCode int
+
+const (
+	// exitClean: the scan completed and found nothing vulnerable.
+	exitClean exitCode = 0
+	// exitError: an operational failure such as an unwritable result file.
+	exitError exitCode = 1
+	// exitFindings: at least one reported record is vulnerable.
+	exitFindings exitCode = 2
+	// exitPartial: some lookups failed, or the scan was stopped (-max-runtime, an
+	// interrupt) before every subdomain was checked.
+	exitPartial exitCode = 3
+	// exitAborted: the -max-errors or -max-consecutive-errors circuit breaker stopped the scan.
+	exitAborted exitCode = 4
+	// exitUsage: invalid flags, arguments or configuration.
+	exitUsage exitCode = 5
+)
+
+// Summary is the outcome of a scan.
+type Summary struct {
+	// Scanned counts the subdomains checked, Findings the vulnerable records reported and
+	// Failed the lookups that failed.
+	Scanned  int
+	Findings int
+	Failed   int
+	// Aborted is set when the error or findings limits stopped the scan, Stopped when its context
+	// ended first, and WriteFailed when a result could not be written.
+	Aborted     bool
+	Stopped     bool
+	WriteFailed bool
+}
+
+// writeSummaryJSON writes s to w as a single JSON object, for -summary-json, together
+// with the exit status it leads to and how long the scan ran.
+func writeSummaryJSON(w io.Writer, s Summary, duration time.Duration) error {
+	return json.NewEncoder(w).Encode(struct {
+		Scanned     int     `json:"scanned"`
+		Findings    int     `json:"findings"`
+		Failed      int     `json:"failed"`
+		Aborted     bool    `json:"aborted"`
+		Stopped     bool    `json:"stopped"`
+		WriteFailed bool    `json:"write_failed"`
+		ExitCode    int     `json:"exit_code"`
+		Duration    float64 `json:"duration_seconds"`
+	}{s.Scanned, s.Findings, s.Failed, s.Aborted, s.Stopped, s.WriteFailed, int(s.exitCode()), duration.Seconds()})
+}
+
+// exitCode maps a scan's summary to the process exit status. Findings take precedence
+// over an incomplete scan, since a confirmed-vulnerable host matters whatever else went
+// wrong; a failed write is reported as an error before anything else.
+func (s Summary) exitCode() exitCode {
+	switch {
+	case s.WriteFailed:
+		return exitError
+	case s.Findings > 0:
+		return exitFindings
+	case s.Aborted:
+		return exitAborted
+	case s.Stopped || s.Failed > 0:
+		return exitPartial
+	default:
+		return exitClean
+	}
+}
+
+// parseFlags parses args into fs, which must use flag.ContinueOnError, exiting with
+// exitUsage on invalid flags and with exitClean after -h has printed the usage.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(int(exitClean))
+		}
+		os.Exit(int(exitUsage))
+	}
+}
+
+// configError logs an invalid flag combination or setting, writes any profiles and exits
+// with exitUsage.
+func configError(format string, args ...interface{}) {
+	slog.Error(fmt.Sprintf(format, args...))
+	stopProfiles()
+	os.Exit(int(exitUsage))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxExpansions bounds how many names a single brace pattern may expand to.
+const maxExpansions = 100000
+
+// expandBraces expands shell-style brace patterns in a subdomain line: comma lists
+// ("{api,admin}.example.com") and numeric ranges ("host{1..5}.example.com", with
+// "{01..10}" keeping the zero padding). Several groups expand to their cartesian product
+// and groups may nest. Braces that do not form a valid group are kept literally, like a
+// shell would. Lines without braces are returned unchanged.
+func expandBraces(line string) ([]string, error) {
+	results := []string{line}
+	for {
+		expanded := false
+		var next []string
+		for _, s := range results {
+			parts, ok := expandFirstGroup(s)
+			if !ok {
+				next = append(next, s)
+				continue
+			}
+			expanded = true
+			next = append(next, parts...)
+			if len(next) > maxExpansions {
+				return nil, fmt.Errorf("%q expands to more than %d names", line, maxExpansions)
+			}
+		}
+		results = next
+		if !expanded {
+			return results, nil
+		}
+	}
+}
+
+// expandFirstGroup expands the first valid brace group of s, reporting false when s has none.
+func expandFirstGroup(s string) ([]string, bool) {
+	for start := strings.IndexByte(s, '{'); start >= 0; {
+		end, alternatives := braceGroup(s, start)
+		if end >= 0 {
+			prefix, suffix := s[:start], s[end+1:]
+			out := make([]string, len(alternatives))
+			for i, alternative := range alternatives {
+				out[i] = prefix + alternative + suffix
+			}
+			return out, true
+		}
+
+		next := strings.IndexByte(s[start+1:], '{')
+		if next < 0 {
+			break
+		}
+		start += 1 + next
+	}
+	return nil, false
+}
+
+// braceGroup parses the brace group opening at s[start] and returns the index of its
+// closing brace and its alternatives, or -1 when it is not a valid group. Nested groups
+// are left in the alternatives for a later pass.
+func braceGroup(s string, start int) (int, []string) {
+	depth := 0
+	last := start + 1
+	var alternatives []string
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			body := s[start+1 : i]
+			if alternatives == nil {
+				if values, ok := numericRange(body); ok {
+					return i, values
+				}
+				return -1, nil // a single item without a comma is not a group
+			}
+			return i, append(alternatives, s[last:i])
+		case ',':
+			if depth == 1 {
+				alternatives = append(alternatives, s[last:i])
+				last = i + 1
+			}
+		}
+	}
+	return -1, nil
+}
+
+// numericRange expands "a..b" into the integers from a to b inclusive, in either
+// direction. When either bound has a leading zero, every value is padded to that width.
+func numericRange(body string) ([]string, bool) {
+	from, to, ok := strings.Cut(body, "..")
+	if !ok {
+		return nil, false
+	}
+	// 32-bit bounds keep b-a from overflowing
+	a, errA := strconv.ParseInt(from, 10, 32)
+	b, errB := strconv.ParseInt(to, 10, 32)
+	if errA != nil || errB != nil {
+		return nil, false
+	}
+	if n := b - a; n > maxExpansions || -n > maxExpansions {
+		return nil, false
+	}
+
+	width := 0
+	if (len(from) > 1 && from[0] == '0') || (len(to) > 1 && to[0] == '0') {
+		width = max(len(from), len(to))
+	}
+	step := int64(1)
+	if b < a {
+		step = -1
+	}
+	var values []string
+	for i := a; ; i += step {
+		values = append(values, fmt.Sprintf("%0*d", width, i))
+		if i == b {
+			break
+		}
+	}
+	return values, true
+}
+
+// expandLines expands the brace patterns of every line. Lines that expand to too many
+// names are an error.
+func expandLines(lines []string) ([]string, error) {
+	var out []string
+	for _, line := range lines {
+		expanded, err := expandBraces(line)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, expanded...)
+	}
+	return out, nil
+}
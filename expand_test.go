@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandBraces(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"api.example.com", []string{"api.example.com"}},
+		{"{api,admin}.example.com", []string{"api.example.com", "admin.example.com"}},
+		{"host{1..3}.example.com", []string{"host1.example.com", "host2.example.com", "host3.example.com"}},
+		{"host{3..1}.example.com", []string{"host3.example.com", "host2.example.com", "host1.example.com"}},
+		{"host{-1..1}", []string{"host-1", "host0", "host1"}},
+		{"host{08..10}", []string{"host08", "host09", "host10"}},
+		{"host{1..03}", []string{"host01", "host02", "host03"}},
+		{"host{5..5}", []string{"host5"}},
+		// Groups multiply and nest
+		{"{a,b}{1..2}.x", []string{"a1.x", "a2.x", "b1.x", "b2.x"}},
+		{"{a,b{1,2}}.x", []string{"a.x", "b1.x", "b2.x"}},
+		{"{,www.}example.com", []string{"example.com", "www.example.com"}},
+		// Braces that are not a group stay literal
+		{"{api}.example.com", []string{"{api}.example.com"}},
+		{"{}.example.com", []string{"{}.example.com"}},
+		{"{api,admin.example.com", []string{"{api,admin.example.com"}},
+		{"api}.example.com", []string{"api}.example.com"}},
+		{"{1..x}.example.com", []string{"{1..x}.example.com"}},
+		{"{x}{a,b}", []string{"{x}a", "{x}b"}},
+	}
+	for _, tt := range tests {
+		got, err := expandBraces(tt.line)
+		if err != nil {
+			t.Errorf("expandBraces(%q): %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandBraces(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestExpandBracesLimits(t *testing.T) {
+	got, err := expandBraces("h{1..100000}")
+	if err != nil {
+		t.Fatalf("expandBraces(h{1..100000}): %v", err)
+	}
+	if len(got) != maxExpansions || got[0] != "h1" || got[len(got)-1] != "h100000" {
+		t.Errorf("expandBraces(h{1..100000}) gave %d names, want %d", len(got), maxExpansions)
+	}
+	if _, err := expandBraces("h{1..100001}"); err == nil {
+		t.Error("expandBraces(h{1..100001}) expanded past the limit")
+	}
+	// A range far past the limit is not expanded at all
+	if got, _ := expandBraces("h{1..100002}"); !reflect.DeepEqual(got, []string{"h{1..100002}"}) {
+		t.Errorf("expandBraces(h{1..100002}) = %d names, want the line kept literally", len(got))
+	}
+	// Bounds whose difference would overflow are not a range
+	for _, line := range []string{"h{-9223372036854775808..9223372036854775807}", "h{0..4294967296}"} {
+		if got, _ := expandBraces(line); !reflect.DeepEqual(got, []string{line}) {
+			t.Errorf("expandBraces(%q) = %d names, want the line kept literally", line, len(got))
+		}
+	}
+
+	for _, line := range []string{"h{1..1000}{1..1000}", "{a,b}{1..50001}", "{1..10}{1..10}{1..10}{1..10}{1..10}{1..10}"} {
+		if _, err := expandBraces(line); err == nil || !strings.Contains(err.Error(), "expands to more than 100000 names") {
+			t.Errorf("expandBraces(%q) error = %v, want the expansion limit", line, err)
+		}
+	}
+	if got, err := expandBraces("{1..10}{1..10}{1..10}{1..10}{1..10}"); err != nil || len(got) != maxExpansions {
+		t.Errorf("expandBraces of 10^5 names = %d names, %v, want %d", len(got), err, maxExpansions)
+	}
+}
+
+func TestExpandLines(t *testing.T) {
+	got, err := expandLines([]string{"a.example.com", "{b,c}.example.com"})
+	if want := []string{"a.example.com", "b.example.com", "c.example.com"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("expandLines = %q, %v, want %q", got, err, want)
+	}
+	if _, err := expandLines([]string{"ok.example.com", "h{1..1000}{1..1000}"}); err == nil {
+		t.Error("expandLines accepted a line past the expansion limit")
+	}
+}
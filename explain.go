@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// explainer writes the -explain decision trace of each host: what the lookup returned,
+// the forms of the target that were matched, every pattern tried and its outcome, the
+// dangling check and the final verdict. Lookups run in parallel, so each host's trace
+// is written as one block.
+type explainer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// newExplainer returns an explainer writing to w.
+func newExplainer(w io.Writer) *explainer {
+	return &explainer{w: w}
+}
+
+// write writes one host's trace. A failed write is not worth failing the scan over, so
+// it is ignored.
+func (e *explainer) write(trace string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	io.WriteString(e.w, trace)
+}
+
+// explainFailure returns the trace of a host whose lookup failed.
+func explainFailure(subdomain string, err error) string {
+	return fmt.Sprintf("explain %s\n  lookup: failed: %v\n  verdict: failed\n", subdomain, err)
+}
+
+// explainRecord returns the trace of how record was decided from answer: the lookup,
+// the candidates matched against patterns, the patterns tried, the dangling check,
+// confirmation and whether opts reports the record.
+func explainRecord(record Record, answer Answer, candidates []string, patterns []Pattern, opts Options) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "explain %s\n", record.Subdomain)
+
+	var lookup string
+	switch {
+	case record.PTR != "":
+		lookup = "PTR " + strings.ReplaceAll(record.PTR, "\n", ", ")
+	case len(answer.CNAMEs) > 0:
+		lookup = "CNAME " + strings.ReplaceAll(record.CNAME, "\n", ", ")
+	default:
+		lookup = "no answer (" + record.CNAME + record.PTR + ")"
+	}
+	var notes []string
+	if record.RCode != "" {
+		notes = append(notes, record.RCode)
+	}
+	if record.Authoritative {
+		notes = append(notes, "authoritative")
+	}
+	if record.DNAME {
+		notes = append(notes, "via DNAME")
+	}
+	if record.Truncated {
+		notes = append(notes, "truncated")
+	}
+	if record.AnsweredBy != "" {
+		notes = append(notes, "answered by "+record.AnsweredBy)
+	}
+	if len(notes) > 0 {
+		lookup += " (" + strings.Join(notes, ", ") + ")"
+	}
+	fmt.Fprintf(&b, "  lookup: %s\n", lookup)
+	if len(record.Chain) > 0 {
+		fmt.Fprintf(&b, "  chain: %s\n", strings.Join(record.Chain, " -> "))
+	}
+	if record.AuthorityAnswer != "" || answer.CrossChecked {
+		fmt.Fprintf(&b, "  cross-check: authority answered %q, discrepancy: %t\n", record.AuthorityAnswer, record.Discrepancy)
+	}
+
+	if len(candidates) == 0 {
+		b.WriteString("  candidates: none, no patterns tried\n")
+	} else {
+		fmt.Fprintf(&b, "  candidates: %s\n", strings.ReplaceAll(strings.Join(candidates, " | "), "\n", ", "))
+		// Matching stops at the first hit unless every match is collected
+		for i, pattern := range patterns {
+			if matchesPattern(candidates, pattern) {
+				fmt.Fprintf(&b, "  pattern %q (%s): matched\n", pattern.Value, pattern.Severity)
+				if !opts.AllMatches {
+					if rest := len(patterns) - i - 1; rest > 0 {
+						fmt.Fprintf(&b, "  %d more patterns not tried\n", rest)
+					}
+					break
+				}
+				continue
+			}
+			fmt.Fprintf(&b, "  pattern %q (%s): no match\n", pattern.Value, pattern.Severity)
+		}
+	}
+
+	if record.MatchedIP != "" {
+		fmt.Fprintf(&b, "  resolved address: %s in range %s\n", record.MatchedIP, record.MatchedRange)
+	} else if len(opts.IPPatterns) > 0 && record.PTR == "" {
+		fmt.Fprintf(&b, "  resolved address: in none of %d ranges\n", len(opts.IPPatterns))
+	}
+	if record.DelegationZone != "" {
+		var served string
+		switch {
+		case record.DelegationPattern == "":
+			served = "no pattern matched"
+		case record.LameDelegation:
+			served = fmt.Sprintf("pattern %q matched, lame", record.DelegationPattern)
+		default:
+			served = fmt.Sprintf("pattern %q matched, served", record.DelegationPattern)
+		}
+		fmt.Fprintf(&b, "  delegation: %s to %s, %s\n", record.DelegationZone, strings.Join(record.Nameservers, ", "), served)
+	}
+	if record.Resolution != "" {
+		dangling := record.Resolution
+		if record.SOAMinimum > 0 {
+			dangling += fmt.Sprintf(", NXDOMAIN cached for up to %ds", record.SOAMinimum)
+		}
+		fmt.Fprintf(&b, "  dangling check: %s\n", dangling)
+	}
+	switch {
+	case record.SampledOut:
+		b.WriteString("  confirmation: sampled out\n")
+	case record.Confirmed && record.ConfirmInsecure:
+		fmt.Fprintf(&b, "  confirmation: confirmed by %q, certificate not verified\n", record.ConfirmMatch)
+	case record.Confirmed:
+		fmt.Fprintf(&b, "  confirmation: confirmed by %q\n", record.ConfirmMatch)
+	case record.ConfirmError != "":
+		fmt.Fprintf(&b, "  confirmation: failed: %s\n", record.ConfirmError)
+	case record.HTTPStatus != 0:
+		fmt.Fprintf(&b, "  confirmation: not confirmed (HTTP %d)\n", record.HTTPStatus)
+	}
+
+	verdict := "not vulnerable"
+	if record.IsVulnerable {
+		verdict = fmt.Sprintf("vulnerable (pattern %q, severity %s, confidence %d)", record.MatchedPattern, record.Severity, record.Confidence)
+	}
+	if isReported(record, opts) {
+		verdict += ", reported"
+	} else {
+		verdict += ", filtered out"
+	}
+	fmt.Fprintf(&b, "  verdict: %s\n", verdict)
+	return b.String()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"sync"
+	"time"
+)
+
+// defaultFlushInterval is the -flush-interval default: records reach the result file
+// within about a second, without a write for every record.
+const defaultFlushInterval = time.Second
+
+// flusher is a stream that holds back written data until it is flushed, such as a
+// bufio.Writer, a gzip.Writer or an encryptWriter.
+type flusher interface {
+	Flush() error
+}
+
+// bufferedStream is the write buffer in front of a result file. Closing it flushes it,
+// so it can be closed by a closingWriter like the other streams.
+type bufferedStream struct {
+	*bufio.Writer
+}
+
+func (s bufferedStream) Close() error {
+	return s.Flush()
+}
+
+// flushingWriter buffers the records written to next and flushes streams, nearest the
+// records first, after every records or once the oldest unflushed record is interval
+// old, whichever comes first, so long scans keep the result file current without a
+// write per record. A zero every or interval disables that trigger. The timed flush runs
+// on its own goroutine; an error from it is returned by the next Write or Close.
+type flushingWriter struct {
+	mu       sync.Mutex
+	next     recordWriter
+	streams  []flusher
+	every    int
+	interval time.Duration
+	pending  int
+	timer    *time.Timer
+	err      error
+	closed   bool
+}
+
+// newFlushingWriter returns a flushingWriter in front of next.
+func newFlushingWriter(next recordWriter, streams []flusher, every int, interval time.Duration) *flushingWriter {
+	return &flushingWriter{next: next, streams: streams, every: every, interval: interval}
+}
+
+func (w *flushingWriter) Write(record Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.next.Write(record); err != nil {
+		return err
+	}
+	w.pending++
+	switch {
+	case w.every > 0 && w.pending >= w.every:
+		return w.flush()
+	case w.pending == 1 && w.interval > 0:
+		w.timer = time.AfterFunc(w.interval, w.flushTimed)
+	}
+	return nil
+}
+
+// Flush flushes the streams now. A closed writer has nothing left to flush.
+func (w *flushingWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil || w.closed {
+		return w.err
+	}
+	return w.flush()
+}
+
+// Close closes next, which flushes and closes the streams behind it.
+func (w *flushingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	err := w.next.Close()
+	if w.err != nil {
+		err = w.err
+	}
+	w.err = err
+	return err
+}
+
+// flushTimed is the timer's flush of records written interval ago.
+func (w *flushingWriter) flushTimed() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed || w.pending == 0 || w.err != nil {
+		return
+	}
+	w.err = w.flush()
+}
+
+// flush flushes every stream and starts counting records again; w.mu must be held.
+func (w *flushingWriter) flush() error {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	w.pending = 0
+	for _, stream := range w.streams {
+		if err := stream.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushGroup flushes every result file's flushingWriter, including those opened later by
+// rotation or for -parallel-files, so a checkpoint can flush all output before it
+// records hosts as done.
+type flushGroup struct {
+	mu      sync.Mutex
+	writers []*flushingWriter
+}
+
+func (g *flushGroup) add(w *flushingWriter) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.writers = append(g.writers, w)
+}
+
+func (g *flushGroup) Flush() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, w := range g.writers {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
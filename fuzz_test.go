@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Fuzz targets for the parsers that read untrusted input: subdomain and pattern files,
+// dig output and DNS messages. Run one with, for example,
+//
+//	go test -run '^$' -fuzz FuzzParseAnswer -fuzztime 30s
+//
+// The seeds below also run as plain tests with go test.
+
+func FuzzExtractWildcardDomain(f *testing.F) {
+	for _, seed := range []string{"example.com", "*.example.com", "*.*.example.com", "*", "*.", "*.*.", "a.*.example.com", "*.a.example.com\n*.*.b.example.net", "No CNAME record", "*.\x00.example.com", "*.\xff\xfe", strings.Repeat("*.", 1000)} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, cname string) {
+		stripped := extractWildcardDomain(cname)
+		for _, line := range strings.Split(stripped, "\n") {
+			if strings.HasPrefix(line, "*.") || line == "*" {
+				t.Fatalf("extractWildcardDomain(%q) = %q still has a leading wildcard", cname, stripped)
+			}
+		}
+		if again := extractWildcardDomain(stripped); again != stripped {
+			t.Fatalf("extractWildcardDomain is not idempotent on %q: %q then %q", cname, stripped, again)
+		}
+	})
+}
+
+func FuzzParseAnswer(f *testing.F) {
+	for _, seed := range []string{digChainOutput, digNXDomainOutput, "app.herokuapp.com.\n", "www.example.com. CNAME app.herokuapp.com.\n", ";; ANSWER SECTION:\n\x00 1 IN CNAME\n", ";; flags: qr aa;\n;; ->>HEADER<<- status: "} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, output string) {
+		records, _ := parseDigRecords(output)
+		for _, rr := range records {
+			if rr.Name == "" || rr.Type == "" || rr.Value == "" {
+				t.Fatalf("parseDigRecords returned an incomplete record %+v", rr)
+			}
+		}
+		parseDigAnswer(output, "CNAME")
+		parseDigSections(output)
+		parseDigCapture(output)
+		parseDigServer(output)
+		parseDigStatus(output)
+	})
+}
+
+func FuzzParseMessage(f *testing.F) {
+	query, _ := buildQuery(1, "www.example.com", typeCNAME, true)
+	response := cnameResponse()
+	f.Add(query)
+	f.Add(response)
+	f.Add(response[:len(response)-3])
+	f.Add(append(make([]byte, 12), 0xC0, 12))
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		parseMessage(msg)
+	})
+}
+
+func FuzzBuildQuery(f *testing.F) {
+	for _, seed := range []string{"www.example.com", "example.com.", "", ".", "a..b", strings.Repeat("a", 64) + ".com", "\x00.example.com", "xn--p1ai"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		query, err := buildQuery(1, name, typeCNAME, true)
+		if err != nil {
+			return
+		}
+		if _, err := parseMessage(query); err != nil {
+			t.Fatalf("the query for %q does not parse: %v", name, err)
+		}
+	})
+}
+
+func FuzzExpandBraces(f *testing.F) {
+	for _, seed := range []string{"{api,admin}.example.com", "host{1..5}.example.com", "{01..10}.example.com", "{a,{b,c}}.example.com", "{", "}{", "{1..}", "{,}"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		if len(line) > 64 {
+			return // a few groups already expand to the limit
+		}
+		expandBraces(line)
+	})
+}
+
+func FuzzSkipLongLines(f *testing.F) {
+	f.Add([]byte("a.example.com\r\nb.example.com\n\x00c.example.com"))
+	f.Add([]byte("\n\n\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 0, 64), maxLineLength)
+		scanner.Split(skipLongLines("fuzz"))
+		for scanner.Scan() {
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatalf("scanner failed: %v", err)
+		}
+	})
+}
+
+func FuzzRotatePolicy(f *testing.F) {
+	for _, seed := range []string{"100MB", "24h", "1KB", "0", "-1MB", "MB", "99999999999GB"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, value string) {
+		var policy rotatePolicy
+		policy.Set(value)
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// writeGraph writes the CNAME graph of results as a Graphviz DOT document. Each subdomain
+// has an edge to its CNAME target, and vulnerable subdomains and their targets are colored red.
+func writeGraph(results map[string]Record, output io.Writer) error {
+	vulnerable := make(map[string]bool)
+	var edges [][2]string
+	for _, record := range sortedBySubdomain(results) {
+		subdomain := record.Subdomain
+		target := graphNodeName(record.CNAME)
+		if record.IsVulnerable {
+			vulnerable[subdomain] = true
+			if target != "" {
+				vulnerable[target] = true
+			}
+		}
+		if target != "" {
+			edges = append(edges, [2]string{subdomain, target})
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph cnames {\n")
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [shape=box];\n")
+
+	nodes := make([]string, 0, len(vulnerable))
+	for node := range vulnerable {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		fmt.Fprintf(&b, "\t%q [color=red, fontcolor=red];\n", node)
+	}
+
+	for _, edge := range edges {
+		fmt.Fprintf(&b, "\t%q -> %q;\n", edge[0], edge[1])
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(output, b.String())
+	return err
+}
+
+// graphNodeName returns the node name used for a CNAME target, or "" if there is no target.
+func graphNodeName(cname string) string {
+	if cname == "No CNAME record" {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimSpace(cname), ".")
+}
+
+// writeGraphFile writes the CNAME graph of results to the named DOT file.
+func writeGraphFile(results map[string]Record, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("error creating graph file %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	if err := writeGraph(results, file); err != nil {
+		return fmt.Errorf("error writing graph file %s: %v", filename, err)
+	}
+	return nil
+}
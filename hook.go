@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hookTimeout bounds each run of the -on-finding command, so a hung hook cannot hold
+// up the end of the scan.
+const hookTimeout = time.Minute
+
+// hookOutputLimit is how much of a failed hook's stderr is logged.
+const hookOutputLimit = 512
+
+// findingHook runs the -on-finding command for every vulnerable record written through
+// it, to hand findings to ticketing or enrichment scripts. The command runs through
+// /bin/sh with the record as a JSON object on stdin and its key fields in
+// DIGCNAME_FINDING_* environment variables, a prefix no flag's variable uses. At most
+// concurrency hooks run at once; Write waits for a free slot, so a slow hook slows the
+// scan down rather than piling up processes. A hook that fails or exits non-zero is
+// logged and does not fail the scan.
+type findingHook struct {
+	next    recordWriter
+	command string
+	slots   chan struct{}
+	wg      sync.WaitGroup
+
+	ran    atomic.Int64
+	failed atomic.Int64
+}
+
+// newFindingHook returns a findingHook running command in front of next.
+func newFindingHook(next recordWriter, command string, concurrency int) *findingHook {
+	return &findingHook{next: next, command: command, slots: make(chan struct{}, max(concurrency, 1))}
+}
+
+func (h *findingHook) Write(record Record) error {
+	if err := h.next.Write(record); err != nil {
+		return err
+	}
+	if !record.IsVulnerable {
+		return nil
+	}
+	input, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	h.slots <- struct{}{}
+	h.wg.Add(1)
+	go func() {
+		defer func() {
+			<-h.slots
+			h.wg.Done()
+		}()
+		h.run(record, input)
+	}()
+	return nil
+}
+
+// Close waits for the running hooks and logs how many failed before closing next.
+func (h *findingHook) Close() error {
+	h.wg.Wait()
+	if ran := h.ran.Load(); ran > 0 {
+		slog.Info("Ran finding hooks", "command", h.command, "ran", ran, "failed", h.failed.Load())
+	}
+	return h.next.Close()
+}
+
+// run runs the command once for record, whose JSON encoding is input.
+func (h *findingHook) run(record Record, input []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", h.command)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = append(os.Environ(), hookEnv(record)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	h.ran.Add(1)
+	if err == nil {
+		return
+	}
+
+	h.failed.Add(1)
+	output := strings.TrimSpace(stderr.String())
+	if len(output) > hookOutputLimit {
+		output = output[:hookOutputLimit] + "..."
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		slog.Warn("Finding hook failed", "host", record.Subdomain, "exit_status", exitErr.ExitCode(), "stderr", output)
+		return
+	}
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	slog.Warn("Finding hook failed", "host", record.Subdomain, "error", err, "stderr", output)
+}
+
+// hookEnv returns the environment variables describing record to a hook.
+func hookEnv(record Record) []string {
+	target := record.CNAME
+	if record.PTR != "" {
+		target = record.PTR
+	}
+	const prefix = envPrefix + "FINDING_"
+	return []string{
+		prefix + "SUBDOMAIN=" + record.Subdomain,
+		prefix + "TARGET=" + strings.ReplaceAll(target, "\n", ","),
+		prefix + "MATCHED_PATTERN=" + record.MatchedPattern,
+		prefix + "SEVERITY=" + record.Severity.String(),
+		prefix + "CONFIDENCE=" + strconv.Itoa(record.Confidence),
+		prefix + "CONFIRMED=" + strconv.FormatBool(record.Confirmed),
+		prefix + "TAGS=" + formatTags(record.Tags),
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// hostsFileServer is the AnsweredBy of answers taken from -hosts-file.
+const hostsFileServer = "hosts-file"
+
+// hostOverrides are the answers of a -hosts-file, which the cachingResolver gives
+// instead of querying DNS, to scan staged targets or reproduce a report with controlled
+// answers. Each line is one of
+//
+//	<ip> <name> [<name> ...]    the names resolve to ip, and ip's PTR is the first name
+//	<name> CNAME <target>       name is an alias of target
+//	<name> NXDOMAIN             name does not exist
+//
+// as in /etc/hosts for the first form. Blank lines and text after "#" are ignored.
+// Names not in the file are looked up as usual, also as the target of an overridden CNAME.
+type hostOverrides struct {
+	cnames   map[string]string
+	addrs    map[string][]string
+	ptrs     map[string][]string
+	nxdomain map[string]bool
+}
+
+// loadHostOverrides reads the hosts file at path.
+func loadHostOverrides(path string) (*hostOverrides, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	o := &hostOverrides{
+		cnames:   make(map[string]string),
+		addrs:    make(map[string][]string),
+		ptrs:     make(map[string][]string),
+		nxdomain: make(map[string]bool),
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		if j := strings.Index(line, "#"); j >= 0 {
+			line = line[:j]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if err := o.add(fields); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, i+1, err)
+		}
+	}
+	return o, nil
+}
+
+// add records the override on one line, split into fields.
+func (o *hostOverrides) add(fields []string) error {
+	if ip := net.ParseIP(fields[0]); ip != nil {
+		if len(fields) < 2 {
+			return fmt.Errorf("address %s without a name", fields[0])
+		}
+		for _, name := range fields[1:] {
+			name = normalizeHost(name)
+			o.addrs[name] = append(o.addrs[name], ip.String())
+		}
+		o.ptrs[ip.String()] = append(o.ptrs[ip.String()], fields[1])
+		return nil
+	}
+
+	name := normalizeHost(fields[0])
+	switch {
+	case len(fields) == 3 && strings.EqualFold(fields[1], "CNAME"):
+		if _, ok := o.cnames[name]; ok {
+			return fmt.Errorf("%s has more than one CNAME", fields[0])
+		}
+		o.cnames[name] = fields[2]
+	case len(fields) == 2 && strings.EqualFold(fields[1], "NXDOMAIN"):
+		o.nxdomain[name] = true
+	default:
+		return fmt.Errorf("want \"<ip> <name>...\", \"<name> CNAME <target>\" or \"<name> NXDOMAIN\", got %q", strings.Join(fields, " "))
+	}
+	return nil
+}
+
+// has reports whether the file overrides name; it is false for a nil receiver.
+func (o *hostOverrides) has(name string) bool {
+	if o == nil {
+		return false
+	}
+	name = normalizeHost(name)
+	_, cname := o.cnames[name]
+	return cname || o.addrs[name] != nil || o.nxdomain[name]
+}
+
+// cname returns the CNAME answer for an overridden name. A name with only addresses has
+// no CNAME, as in DNS.
+func (o *hostOverrides) cname(name string) Answer {
+	answer := Answer{Authoritative: true, AnsweredBy: hostsFileServer, NXDomain: o.nxdomain[normalizeHost(name)], RCode: "NOERROR"}
+	if answer.NXDomain {
+		answer.RCode = "NXDOMAIN"
+	}
+	if target, ok := o.cnames[normalizeHost(name)]; ok {
+		answer.CNAMEs = []string{target}
+		answer.Records = []ResourceRecord{{Name: name, Type: "CNAME", Value: target}}
+	}
+	return answer
+}
+
+// ptr returns the PTR answer for ip and whether the file lists it.
+func (o *hostOverrides) ptr(ip string) (Answer, bool) {
+	if o == nil {
+		return Answer{}, false
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil || o.ptrs[addr.String()] == nil {
+		return Answer{}, false
+	}
+	return Answer{PTRs: o.ptrs[addr.String()], Authoritative: true, AnsweredBy: hostsFileServer, RCode: "NOERROR"}, true
+}
+
+// lookupAddrs resolves an overridden name to its addresses, following CNAMEs through the
+// file and handing the first name it does not list to next.
+func (o *hostOverrides) lookupAddrs(ctx context.Context, name string, next func(context.Context, string) (Answer, error)) (Answer, error) {
+	var records []ResourceRecord
+	current := name
+	for hops := 0; hops < maxChainLength; hops++ {
+		key := normalizeHost(current)
+		switch {
+		case o.addrs[key] != nil:
+			for _, addr := range o.addrs[key] {
+				rrType := "A"
+				if net.ParseIP(addr).To4() == nil {
+					rrType = "AAAA"
+				}
+				records = append(records, ResourceRecord{Name: current, Type: rrType, Value: addr})
+			}
+			return Answer{Addrs: o.addrs[key], Authoritative: true, AnsweredBy: hostsFileServer, Records: records, RCode: "NOERROR"}, nil
+		case o.nxdomain[key]:
+			return Answer{Authoritative: true, AnsweredBy: hostsFileServer, Records: records, NXDomain: true, RCode: "NXDOMAIN"}, nil
+		}
+		target, ok := o.cnames[key]
+		if !ok {
+			answer, err := next(ctx, current)
+			if err != nil {
+				return Answer{}, err
+			}
+			answer.Records = append(records, answer.Records...)
+			return answer, nil
+		}
+		records = append(records, ResourceRecord{Name: current, Type: "CNAME", Value: target})
+		current = target
+	}
+	return Answer{}, fmt.Errorf("CNAME chain of %s in the hosts file is longer than %d hops", name, maxChainLength)
+}
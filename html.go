@@ -0,0 +1,215 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// htmlWriter writes -format html: a single self-contained HTML page, with its styles
+// inline and no external assets so it can be mailed or attached to a ticket, for readers
+// who will not open JSON. Records are held until Close, which renders the summary and
+// the findings grouped by matched pattern.
+type htmlWriter struct {
+	output  io.Writer
+	records []Record
+}
+
+func (w *htmlWriter) Write(record Record) error {
+	w.records = append(w.records, record)
+	return nil
+}
+
+func (w *htmlWriter) Close() error {
+	return htmlReport.Execute(w.output, newHTMLReportData(w.records, time.Now()))
+}
+
+// htmlReportData is what htmlReport renders.
+type htmlReportData struct {
+	Generated string
+	Hosts     int
+	Findings  int
+	Confirmed int
+	Failed    int
+	// Severities counts the findings per severity, most severe first.
+	Severities []htmlCount
+	Groups     []htmlGroup
+}
+
+// htmlCount is a labelled count in the report summary.
+type htmlCount struct {
+	Label string
+	Count int
+}
+
+// htmlGroup is the findings of one matched pattern.
+type htmlGroup struct {
+	Pattern  string
+	Findings []htmlFinding
+}
+
+// htmlFinding is one vulnerable record as the report shows it.
+type htmlFinding struct {
+	Subdomain   string
+	Target      string
+	Severity    string
+	Confidence  int
+	Level       string
+	Confirmed   string
+	Resolution  string
+	Remediation string
+	Tags        string
+}
+
+// confidenceLevel buckets a confidence score for the report's coloring.
+func confidenceLevel(score int) string {
+	switch {
+	case score >= 80:
+		return "high"
+	case score >= 50:
+		return "medium"
+	default:
+		return "low"
+	}
+}
+
+// newHTMLReportData summarizes records for the report generated at generated. Groups
+// are ordered by their most severe finding, then by size, and findings by confidence.
+func newHTMLReportData(records []Record, generated time.Time) htmlReportData {
+	data := htmlReportData{Generated: generated.UTC().Format(time.RFC1123), Hosts: len(records)}
+	severities := make(map[Severity]int)
+	groups := make(map[string]*htmlGroup)
+	worst := make(map[string]Severity)
+	for _, record := range records {
+		if record.Error != "" {
+			data.Failed++
+		}
+		if !record.IsVulnerable {
+			continue
+		}
+		data.Findings++
+		severities[record.Severity]++
+
+		finding := htmlFinding{
+			Subdomain:   record.Subdomain,
+			Target:      strings.ReplaceAll(record.CNAME, "\n", ", "),
+			Severity:    record.Severity.String(),
+			Confidence:  record.Confidence,
+			Level:       confidenceLevel(record.Confidence),
+			Confirmed:   "not checked",
+			Resolution:  record.Resolution,
+			Remediation: record.Remediation,
+			Tags:        formatTags(record.Tags),
+		}
+		if record.PTR != "" {
+			finding.Target = strings.ReplaceAll(record.PTR, "\n", ", ")
+		}
+		switch {
+		case record.MatchedIP != "" && emptyAnswer(record):
+			finding.Target = record.MatchedIP
+		case record.MatchedIP != "":
+			finding.Target += " (" + record.MatchedIP + ")"
+		}
+		switch {
+		case record.Confirmed:
+			finding.Confirmed = "yes"
+			data.Confirmed++
+		case record.SampledOut:
+			finding.Confirmed = "sampled out"
+		case record.HTTPStatus != 0 || record.ConfirmError != "":
+			finding.Confirmed = "no"
+		}
+
+		group, ok := groups[record.MatchedPattern]
+		if !ok {
+			group = &htmlGroup{Pattern: record.MatchedPattern}
+			groups[record.MatchedPattern] = group
+		}
+		group.Findings = append(group.Findings, finding)
+		worst[record.MatchedPattern] = max(worst[record.MatchedPattern], record.Severity)
+	}
+
+	for severity := SeverityCritical; severity > SeverityNone; severity-- {
+		if severities[severity] > 0 {
+			data.Severities = append(data.Severities, htmlCount{Label: severity.String(), Count: severities[severity]})
+		}
+	}
+	for _, group := range groups {
+		sort.SliceStable(group.Findings, func(i, j int) bool {
+			if group.Findings[i].Confidence != group.Findings[j].Confidence {
+				return group.Findings[i].Confidence > group.Findings[j].Confidence
+			}
+			return group.Findings[i].Subdomain < group.Findings[j].Subdomain
+		})
+		data.Groups = append(data.Groups, *group)
+	}
+	sort.Slice(data.Groups, func(i, j int) bool {
+		a, b := data.Groups[i], data.Groups[j]
+		switch {
+		case worst[a.Pattern] != worst[b.Pattern]:
+			return worst[a.Pattern] > worst[b.Pattern]
+		case len(a.Findings) != len(b.Findings):
+			return len(a.Findings) > len(b.Findings)
+		default:
+			return a.Pattern < b.Pattern
+		}
+	})
+	return data
+}
+
+// htmlReport is the page written by htmlWriter.
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>digcname report</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.6em; margin-bottom: 0.2em; }
+h2 { font-size: 1.2em; margin-top: 2em; border-bottom: 1px solid #ddd; padding-bottom: 0.3em; }
+p.generated { color: #666; margin-top: 0; }
+table { border-collapse: collapse; margin-top: 0.5em; }
+th, td { text-align: left; padding: 0.35em 0.8em; border-bottom: 1px solid #eee; vertical-align: top; }
+th { background: #f4f4f4; }
+table.summary td:first-child { font-weight: bold; text-align: right; }
+td.confidence { font-weight: bold; text-align: right; }
+tr.high td.confidence { background: #f8d7da; color: #721c24; }
+tr.medium td.confidence { background: #fff3cd; color: #856404; }
+tr.low td.confidence { background: #e2e3e5; color: #383d41; }
+td.remediation { color: #555; font-size: 0.9em; max-width: 30em; }
+.none { color: #2e7d32; }
+</style>
+</head>
+<body>
+<h1>Subdomain takeover report</h1>
+<p class="generated">Generated {{.Generated}}</p>
+
+<h2>Summary</h2>
+<table class="summary">
+<tr><td>{{.Hosts}}</td><td>hosts reported</td></tr>
+<tr><td>{{.Findings}}</td><td>findings</td></tr>
+<tr><td>{{.Confirmed}}</td><td>confirmed findings</td></tr>
+<tr><td>{{.Failed}}</td><td>failed lookups</td></tr>
+{{- range .Severities}}
+<tr><td>{{.Count}}</td><td>{{.Label}} severity</td></tr>
+{{- end}}
+</table>
+
+{{- if not .Groups}}
+<p class="none">No findings.</p>
+{{- end}}
+{{- range .Groups}}
+
+<h2>{{.Pattern}} ({{len .Findings}})</h2>
+<table>
+<tr><th>Subdomain</th><th>Target</th><th>Severity</th><th>Confidence</th><th>Confirmed</th><th>Resolution</th><th>Remediation</th><th>Tags</th></tr>
+{{- range .Findings}}
+<tr class="{{.Level}}"><td>{{.Subdomain}}</td><td>{{.Target}}</td><td>{{.Severity}}</td><td class="confidence">{{.Confidence}}</td><td>{{.Confirmed}}</td><td>{{.Resolution}}</td><td class="remediation">{{.Remediation}}</td><td>{{.Tags}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
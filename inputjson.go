@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// loadInputRecords reads the records of an earlier scan from a -format json or jsonl
+// result file, for -input-json. It returns the subdomains in the order of the file and
+// their records; a subdomain listed more than once, as in a file written with -append,
+// keeps its last record.
+func loadInputRecords(path string) ([]string, map[string]Record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	var records []Record
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &records); err != nil {
+			return nil, nil, fmt.Errorf("error parsing %s: %v", path, err)
+		}
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var record Record
+			if err := decoder.Decode(&record); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, nil, fmt.Errorf("error parsing %s at record %d: %v", path, len(records)+1, err)
+			}
+			records = append(records, record)
+		}
+	}
+
+	var subdomains []string
+	byHost := make(map[string]Record, len(records))
+	for i, record := range records {
+		if record.Subdomain == "" {
+			return nil, nil, fmt.Errorf("%s: record %d has no subdomain; was it written with -fields?", path, i+1)
+		}
+		if _, ok := byHost[record.Subdomain]; !ok {
+			subdomains = append(subdomains, record.Subdomain)
+		}
+		byHost[record.Subdomain] = record
+	}
+	return subdomains, byHost, nil
+}
+
+// rematchRecord decides stored, a record of an earlier scan, again against patterns
+// without querying DNS: the answer, chain and dangling check are kept as they were
+// stored, and the verdict and everything derived from it are recomputed. A stored
+// failed lookup stays failed. Confirmation runs again when opts asks for it, since an
+// earlier confirmation belongs to the earlier verdict.
+func rematchRecord(ctx context.Context, stored Record, patterns []Pattern, opts Options) (Record, error) {
+	if stored.Error != "" {
+		err := errors.New(stored.Error)
+		if opts.Explain != nil {
+			opts.Explain.write(explainFailure(stored.Subdomain, err))
+		}
+		return Record{Subdomain: stored.Subdomain, Error: stored.Error}, err
+	}
+
+	record := stored
+	record.IsVulnerable, record.MatchedPattern, record.Severity = false, "", SeverityNone
+	record.MatchedPatterns, record.MatchedIP, record.MatchedRange = nil, "", ""
+	record.Remediation, record.TTLWarning, record.Confidence = "", "", 0
+	record.Confirmed, record.HTTPStatus, record.ConfirmMatch = false, 0, ""
+	record.ConfirmError, record.ConfirmProtocol, record.SampledOut = "", "", false
+	record.ConfirmInsecure = false
+	record.Change, record.BaselineCNAME, record.BaselineVulnerable = "", "", false
+	record.BaselineSeverity = SeverityNone
+
+	var answer Answer
+	var target string
+	if record.PTR != "" {
+		if record.PTR != "No PTR record" {
+			target = record.PTR
+			answer.PTRs = strings.Split(record.PTR, "\n")
+		}
+	} else {
+		if opts.LowercaseCNAME {
+			record.CNAME = strings.ToLower(record.CNAME)
+			chain := make([]string, len(record.Chain))
+			for i, hop := range record.Chain {
+				chain[i] = strings.ToLower(hop)
+			}
+			record.Chain = chain
+		}
+		target = record.CNAME
+		if !emptyAnswer(record) {
+			answer.CNAMEs = strings.Split(record.CNAME, "\n")
+		}
+		if len(record.Chain) > 0 {
+			target = strings.Join(append([]string{record.CNAME}, record.Chain[1:]...), "\n")
+		}
+	}
+	answer.CrossChecked = record.AuthorityAnswer != ""
+
+	candidates := matchCandidates(target, opts.MatchLiteral)
+	match, isVulnerable := matchesAnyPattern(candidates, patterns)
+	// The stored delegation is matched against the current patterns too
+	record.DelegationPattern = ""
+	if len(record.Nameservers) > 0 {
+		delegationMatch, ok := matchesAnyPattern(matchCandidates(strings.Join(record.Nameservers, "\n"), false), patterns)
+		if ok {
+			record.DelegationPattern = delegationMatch.Value
+		}
+		if ok && record.LameDelegation && !isVulnerable {
+			match, isVulnerable = delegationMatch, true
+		}
+	}
+	applyVerdict(&record, match, isVulnerable, candidates, patterns, ipPattern{}, opts)
+
+	if opts.Confirmer != nil && isVulnerable {
+		opts.Confirmer.confirm(ctx, &record, match)
+	}
+
+	if opts.Explain != nil {
+		opts.Explain.write(explainRecord(record, answer, candidates, patterns, opts))
+	}
+	return record, nil
+}
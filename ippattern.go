@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// ipPattern is a line of an -ip-patterns file: a provider address range whose hosts are
+// takeover candidates even without a recognizable CNAME, for providers that have
+// customers point A or AAAA records at them. Lines use the patterns file syntax, with an
+// address or CIDR range as the pattern, e.g. "severity:high 192.0.2.0/24".
+type ipPattern struct {
+	Pattern
+	network *net.IPNet
+}
+
+// loadIPPatterns reads the address ranges of an -ip-patterns file. A single address is
+// taken as a range of its own.
+func loadIPPatterns(path string) ([]ipPattern, error) {
+	lines, err := readLinesFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	patterns, err := parsePatterns(lines)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	ranges := make([]ipPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		_, network, err := net.ParseCIDR(pattern.Value)
+		if err != nil {
+			ip := net.ParseIP(pattern.Value)
+			if ip == nil {
+				return nil, fmt.Errorf("error parsing %s: %q is not an IP address or CIDR range", path, pattern.Value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+		ranges = append(ranges, ipPattern{Pattern: pattern, network: network})
+	}
+	return ranges, nil
+}
+
+// matchResolvedIP resolves subdomain, through its CNAME chain if it has one, and returns
+// the first address in one of ranges along with the range. Ok is false when none is, or
+// the lookup fails.
+func matchResolvedIP(ctx context.Context, subdomain string, resolver Resolver, ranges []ipPattern) (string, ipPattern, bool) {
+	answer, err := resolver.LookupAddrs(ctx, subdomain)
+	if err != nil {
+		return "", ipPattern{}, false
+	}
+	for _, addr := range answer.Addrs {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+		for _, r := range ranges {
+			if r.network.Contains(ip) {
+				return ip.String(), r, true
+			}
+		}
+	}
+	return "", ipPattern{}, false
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// hostTags are the extra fields of the hosts in a JSON subdomains file, by normalized
+// host name, carried into each host's record as Record.Tags.
+type hostTags map[string]map[string]string
+
+// tagsFor returns the tags of subdomain, or nil when it has none.
+func (t hostTags) tagsFor(subdomain string) map[string]string {
+	return t[normalizeHost(subdomain)]
+}
+
+// keys returns every tag name any host has, in order.
+func (t hostTags) keys() []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, tags := range t {
+		for key := range tags {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// readSubdomainsFile reads the subdomains in filename. A file named *.json, or whose
+// content starts with "[", is read as JSON by decodeJSONHosts, with the tags of its
+// hosts; any other file is read a host per line.
+func readSubdomainsFile(filename string) ([]string, hostTags, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening file %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	if strings.EqualFold(filepath.Ext(filename), ".json") || startsJSONArray(r) {
+		return decodeJSONHosts(r, filename)
+	}
+	lines, err := readLines(r, filename)
+	return lines, nil, err
+}
+
+// startsJSONArray reports whether the first byte of r other than whitespace is "[",
+// without consuming it. A host name never starts with one.
+func startsJSONArray(r *bufio.Reader) bool {
+	for n := 1; ; n++ {
+		peeked, err := r.Peek(n)
+		if len(peeked) < n {
+			return false
+		}
+		switch c := peeked[n-1]; c {
+		case ' ', '\t', '\r', '\n':
+			if err != nil {
+				return false
+			}
+		default:
+			return c == '['
+		}
+	}
+}
+
+// decodeJSONHosts reads a JSON array of subdomains from r: either strings, or objects
+// whose "host" field is the subdomain, as other tools emit them. The other fields of an
+// object become the host's tags, strings as they are and any other value as its JSON
+// text. A host listed twice keeps the tags of its last entry. The name identifies r in
+// error messages.
+func decodeJSONHosts(r io.Reader, name string) ([]string, hostTags, error) {
+	var entries []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, nil, fmt.Errorf("error reading JSON hosts from %s: %v", name, err)
+	}
+
+	hosts := make([]string, 0, len(entries))
+	tags := make(hostTags)
+	for i, entry := range entries {
+		var host string
+		if err := json.Unmarshal(entry, &host); err == nil {
+			if host = strings.TrimSpace(host); host != "" {
+				hosts = append(hosts, host)
+			}
+			continue
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(entry, &fields); err != nil {
+			return nil, nil, fmt.Errorf("%s: entry %d is neither a string nor an object", name, i+1)
+		}
+		if err := json.Unmarshal(fields["host"], &host); err != nil || strings.TrimSpace(host) == "" {
+			return nil, nil, fmt.Errorf("%s: entry %d has no host string", name, i+1)
+		}
+		host = strings.TrimSpace(host)
+		hosts = append(hosts, host)
+		delete(fields, "host")
+		if len(fields) == 0 {
+			continue
+		}
+		entryTags := make(map[string]string, len(fields))
+		for key, value := range fields {
+			var text string
+			if err := json.Unmarshal(value, &text); err != nil {
+				var compact bytes.Buffer
+				json.Compact(&compact, value)
+				text = compact.String()
+			}
+			entryTags[key] = text
+		}
+		tags[normalizeHost(host)] = entryTags
+	}
+	return hosts, tags, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Log formats accepted by -log-format.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// setupLogging configures the default slog logger, which the log package also writes
+// through. The text format keeps the log package's timestamped lines with attributes
+// appended as key=value pairs; json writes one object per entry to stderr, for log
+// pipelines. Debug entries, such as one per lookup, are only logged when verbose is set.
+func setupLogging(format string, verbose bool) error {
+	level := slog.LevelInfo
+	if verbose {
+		level = slog.LevelDebug
+	}
+	switch format {
+	case logFormatText:
+		slog.SetLogLoggerLevel(level)
+	case logFormatJSON:
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	default:
+		return fmt.Errorf("unknown log format %q (want text or json)", format)
+	}
+	return nil
+}
+
+// fatal logs msg with err at error level, writes any profiles and exits with exitError.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	stopProfiles()
+	os.Exit(int(exitError))
+}
@@ -3,96 +3,983 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net"
 	"os"
-	"os/exec"
+	"os/signal"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 // Record holds the details for each DNS query, including CNAME record and vulnerability status.
+// Records for IP address inputs hold the PTR names of the address instead of a CNAME.
 type Record struct {
-	CNAME       string
-	IsVulnerable bool
+	Subdomain      string   `json:"subdomain"`
+	CNAME          string   `json:"cname"`
+	PTR            string   `json:"ptr"`
+	IsVulnerable   bool     `json:"vulnerable"`
+	MatchedPattern string   `json:"matched_pattern"`
+	Severity       Severity `json:"severity"`
+	Authoritative  bool     `json:"authoritative"`
+	AnswerHash     string   `json:"answer_hash"`
+	Error          string   `json:"error"`
+
+	// MatchedPatterns lists every matching pattern when Options.AllMatches is set.
+	MatchedPatterns []string `json:"matched_patterns"`
+
+	// MatchedIP is the address the host resolves to that lies in MatchedRange, a range of
+	// Options.IPPatterns. A host whose CNAME matches no pattern is vulnerable through the
+	// range's pattern.
+	MatchedIP    string `json:"matched_ip"`
+	MatchedRange string `json:"matched_range"`
+
+	// SubdomainRegistrable and CNAMERegistrable are the registrable domains (public suffix
+	// plus one label) of the subdomain and its first CNAME target, or their last two
+	// labels when the Public Suffix List gives none.
+	SubdomainRegistrable string `json:"subdomain_registrable"`
+	CNAMERegistrable     string `json:"cname_registrable"`
+
+	// Confidence scores from 0 to 100 how likely a vulnerable record is a real finding.
+	Confidence int `json:"confidence"`
+
+	// MultipleCNAMEs reports that the name returned more than one CNAME, which is invalid
+	// DNS and points at a misconfigured zone. All of them are kept in CNAME.
+	MultipleCNAMEs bool `json:"multiple_cnames"`
+
+	// Truncated reports that the CNAME or PTR answer was longer than -max-cname-length
+	// and was cut to fit, so CNAME or PTR holds only its beginning.
+	Truncated bool `json:"truncated"`
+
+	// Confirmed reports whether the HTTP confirmation stage (Options.Confirmer) found
+	// the takeover fingerprint. HTTPStatus is the final status after redirects,
+	// ConfirmMatch the fingerprint that matched and ConfirmError why confirmation failed.
+	// SampledOut is set when -confirm-sample left the record unconfirmed.
+	// ConfirmProtocol is the protocol of the final response, such as "HTTP/2.0", and
+	// ConfirmInsecure reports that it came over HTTPS without verifying the certificate,
+	// with -insecure-confirm.
+	Confirmed       bool   `json:"confirmed"`
+	HTTPStatus      int    `json:"http_status"`
+	ConfirmMatch    string `json:"confirm_match"`
+	ConfirmError    string `json:"confirm_error"`
+	ConfirmProtocol string `json:"confirm_protocol"`
+	ConfirmInsecure bool   `json:"confirm_insecure"`
+	SampledOut      bool   `json:"sampled_out"`
+
+	// Chain lists every CNAME hop from the first target on when Options.FollowChain is set.
+	Chain []string `json:"chain"`
+
+	// Resolution tells whether the CNAME chain finally resolves to an address: one of
+	// resolutionResolves, resolutionDangling or resolutionFailed, or empty for hosts
+	// without a CNAME.
+	Resolution string `json:"resolution"`
+
+	// SOAMinimum is the minimum field of the SOA returned with a dangling target's
+	// NXDOMAIN, in seconds: how long resolvers may cache that the target does not exist,
+	// and so how long after it is claimed the takeover stays invisible to them. It is
+	// zero when the target exists or the backend did not report the SOA.
+	SOAMinimum uint32 `json:"soa_minimum"`
+
+	// TargetASN and TargetOrg are the autonomous system, from -asn-db, of the first
+	// address the CNAME chain resolves to; they are empty when it does not resolve or the
+	// database has no network for it.
+	TargetASN uint32 `json:"target_asn"`
+	TargetOrg string `json:"target_org"`
+
+	// RCode is the response code of the host's own CNAME (or PTR) query, such as NOERROR,
+	// NXDOMAIN or SERVFAIL, so a host without a CNAME that exists can be told from one that
+	// does not. It is empty when the backend does not report it, as with dig +short.
+	RCode string `json:"rcode"`
+
+	// AnsweredBy is the address of the nameserver that answered the query, when known.
+	AnsweredBy string `json:"answered_by"`
+
+	// AuthorityAnswer is the answer of the zone's authoritative nameservers when
+	// Options.CrossCheck is set, and Discrepancy reports whether it differs from the answer above.
+	AuthorityAnswer string `json:"authority_answer"`
+	Discrepancy     bool   `json:"discrepancy"`
+
+	// DNAME reports that a DNAME record redirected the name or a hop of its chain.
+	DNAME bool `json:"dname"`
+
+	// DelegationZone is the zone enclosing the host and Nameservers the nameservers it is
+	// delegated to, when Options.Delegation is set. DelegationPattern is the pattern the
+	// nameservers matched, and LameDelegation reports that none of them serves the zone,
+	// so the whole zone can be taken over at the provider; a host not vulnerable through
+	// its own answer is vulnerable through its zone's delegation.
+	DelegationZone    string   `json:"delegation_zone"`
+	Nameservers       []string `json:"nameservers"`
+	DelegationPattern string   `json:"delegation_pattern"`
+	LameDelegation    bool     `json:"lame_delegation"`
+
+	// Change tells how the host changed since the previous scan when -only-changed is set,
+	// or between the two resolver sets of -compare-two. BaselineCNAME,
+	// BaselineVulnerable and BaselineSeverity are what the previous scan, or the -server
+	// resolvers, saw.
+	Change             string   `json:"change"`
+	BaselineCNAME      string   `json:"baseline_cname"`
+	BaselineVulnerable bool     `json:"baseline_vulnerable"`
+	BaselineSeverity   Severity `json:"baseline_severity"`
+
+	// TTL is the TTL in seconds of the host's CNAME (or PTR) record, zero when the backend
+	// did not report one. Through a recursive resolver it is the time left in its cache.
+	// TTLWarning is ttlWarnLong or ttlWarnShort for vulnerable records outside -ttl-warn.
+	TTL        uint32 `json:"ttl"`
+	TTLWarning string `json:"ttl_warning"`
+
+	// Remediation says how to fix a vulnerable record: the matched fingerprint's note, or
+	// genericRemediation when it has none.
+	Remediation string `json:"remediation"`
+
+	// Tags are the extra fields of the host's entry in a JSON subdomains file, such as
+	// the source or owner another tool recorded for it.
+	Tags map[string]string `json:"tags"`
+}
+
+// genericRemediation is the Remediation of vulnerable records whose fingerprint has no note.
+const genericRemediation = "Remove the DNS record pointing at the unclaimed resource, or claim the resource at the provider again before someone else does"
+
+// sortedBySubdomain returns the records of results in subdomain order, for output that
+// must not depend on map iteration order. Each Record carries its Subdomain, so the
+// slice stands on its own.
+func sortedBySubdomain(results map[string]Record) []Record {
+	records := make([]Record, 0, len(results))
+	for _, record := range results {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Subdomain < records[j].Subdomain })
+	return records
 }
 
-// checkCNAMERecords takes a list of subdomains, patterns, and an io.Writer for output.
+// Options controls how subdomains are queried and which results are reported.
+type Options struct {
+	// MinSeverity is the lowest severity written to output; SeverityNone reports everything.
+	MinSeverity Severity
+	// MinConfidence is the lowest confidence score written to output; zero reports everything.
+	MinConfidence int
+	// SkipResolvable drops records whose CNAME target resolves to an address, which are
+	// almost never takeovers.
+	SkipResolvable bool
+	// All writes every record regardless of MinSeverity, MinConfidence and SkipResolvable.
+	All bool
+	// TTLWarn flags vulnerable records whose TTL is outside its thresholds.
+	TTLWarn ttlThresholds
+	// RequireCNAME drops the records of hosts that have no CNAME, or IP addresses that have
+	// no PTR, from the output and the results; it applies even with All. Failed lookups
+	// are kept.
+	RequireCNAME bool
+	// Backend selects the resolver backend: "auto", "native", "dig" or "replay".
+	Backend string
+	// ReplayDir holds the captured dig output read by the replay backend.
+	ReplayDir string
+	// InputRecords holds the records of an earlier scan read by -input-json, by subdomain.
+	// When set, each host's stored answer is matched again instead of being looked up.
+	InputRecords map[string]Record
+	// HostTags are the tags of the hosts read from JSON subdomains files, set on their records.
+	HostTags hostTags
+	// DigPath is the dig binary of the dig backend (empty means dig on PATH), and DigArgs
+	// are extra options passed on every dig query.
+	DigPath string
+	DigArgs []string
+	// MinCNAMELength is the shortest CNAME target taken as an answer. Shorter targets, and
+	// targets that are not valid host names, are garbage from the server and treated as no
+	// CNAME.
+	MinCNAMELength int
+	// MaxCNAMELength caps the bytes of a CNAME or PTR answer kept in a record, so a hostile
+	// or broken server cannot bloat memory and output with an enormous answer. Zero means
+	// no cap.
+	MaxCNAMELength int
+	// LowercaseCNAME stores CNAME targets and chains lowercased instead of exactly as the
+	// server returned them; matching ignores case either way.
+	LowercaseCNAME bool
+	// Server is the nameserver queried directly, an https:// DNS-over-HTTPS URL or a
+	// tls:// DNS-over-TLS server; empty means the system resolver.
+	Server string
+	// DoHConcurrency bounds the DNS-over-HTTPS requests in flight; zero leaves it to Concurrency.
+	DoHConcurrency int
+	// DoTPins are the SHA-256 digests of the public keys a DNS-over-TLS server may
+	// present, on top of normal certificate verification; nil accepts any valid certificate.
+	DoTPins [][]byte
+	// TraceDNS logs every DNS message the native and DNS-over-HTTPS backends send and
+	// receive, as hex and decoded, at debug level.
+	TraceDNS bool
+	// NoRecursion clears the recursion-desired bit so the server answers from its cache or authority only.
+	NoRecursion bool
+	// Verbose adds debugging details, such as the answering nameserver, to text output.
+	Verbose bool
+	// ShowAnswerHash includes each record's AnswerHash in output.
+	ShowAnswerHash bool
+	// Collapse writes one entry per CNAME target with the hosts pointing at it, instead of
+	// a line per host.
+	Collapse bool
+	// AllMatches collects every matching pattern instead of stopping at the first.
+	AllMatches bool
+	// MatchLiteral also matches targets starting with "*." in their literal form, so
+	// fingerprints written with the wildcard syntax are not missed.
+	MatchLiteral bool
+	// IPPatterns are provider address ranges that the addresses hosts finally resolve to
+	// are matched against, alongside the CNAME patterns.
+	IPPatterns []ipPattern
+	// FollowChain follows CNAME targets hop by hop, recording and matching the whole chain.
+	FollowChain bool
+	// ASNDB, when set, enriches records whose CNAME target resolves with the target's
+	// autonomous system.
+	ASNDB *asnDB
+	// Header is the comment written at the top of text output, for -header.
+	Header string
+	// Explain, when set, receives the decision trace of every host.
+	Explain *explainer
+	// Confirmer, when set, confirms vulnerable records over HTTP.
+	Confirmer *confirmer
+	// Delegation, when set, checks the delegation of each host's zone for nameservers at
+	// a provider that no longer serves it.
+	Delegation *delegationChecker
+	// CrossCheck repeats each lookup against the zone's authoritative nameservers and flags
+	// records whose answers disagree.
+	CrossCheck bool
+	// Timeout bounds each individual lookup; zero means no per-lookup limit.
+	Timeout time.Duration
+	// Jitter is the upper bound of a random delay added before each lookup to smooth out query bursts.
+	Jitter time.Duration
+	// JitterSeed seeds the jitter delays so runs are reproducible; zero draws them from Rand.
+	JitterSeed uint64
+	// RateLimiter, when set, paces lookups at -rate, or tunes the pace with -auto-rate.
+	RateLimiter *rateLimiter
+	// RetryBackoff spaces out the retries of a lookup that failed on one -server; nil
+	// retries at once.
+	RetryBackoff *backoff
+	// Rand is the run's shared source of randomness; nil means a randomly seeded one.
+	Rand *runRand
+	// Checkpoint, when set, records every host whose lookup completed.
+	Checkpoint *checkpoint
+	// MaxErrors aborts the scan once this many lookups have failed; zero means unlimited.
+	MaxErrors int
+	// MaxConsecutiveErrors aborts the scan after this many lookups fail in a row; zero means unlimited.
+	MaxConsecutiveErrors int
+	// FindingsLimit stops writing vulnerable records once this many have been written, a
+	// safety valve for a wildcard zone that matches every host; zero means unlimited. With
+	// AbortAtFindingsLimit the scan is aborted there instead of running to the end.
+	FindingsLimit        int
+	AbortAtFindingsLimit bool
+	// Concurrency is the number of lookups run in parallel; values below one mean one.
+	Concurrency int
+	// DiscardResults stops checkCNAMERecords from keeping every record in memory, for
+	// streaming input of unbounded length.
+	DiscardResults bool
+}
+
+// defaultConcurrency is the default number of parallel lookups.
+const defaultConcurrency = 10
+
+// checkCNAMERecords takes a channel of subdomains, patterns, a Resolver, Options, and a recordWriter for output.
 // It returns a map where the keys are subdomain names and the values are Records containing CNAME records and whether they are vulnerable based on wildcard domain matching.
-func checkCNAMERecords(subdomains []string, patterns []string, output io.Writer) (map[string]Record, error) {
+// Subdomains are looked up by opts.Concurrency workers as they arrive and each record is written as soon as it is found,
+// so output order follows lookup completion. With opts.DiscardResults the returned map stays empty, keeping memory bounded.
+// Only records passing the output filters in opts (see isReported) are written to output, unless opts.All is set; opts.RequireCNAME applies either way.
+// Failed lookups are recorded with their Error set. If the error limits in opts are exceeded the scan stops
+// early and the records gathered so far are returned together with the error. The Summary
+// counts what the scan checked and found, and how it ended.
+// Each lookup gets its own opts.Timeout; when ctx itself ends (for example at the -max-runtime deadline)
+// outstanding lookups are cancelled and the scan stops with the records gathered so far.
+// Total is the number of subdomains expected, used in messages; zero means unknown.
+func checkCNAMERecords(ctx context.Context, subdomains <-chan string, total int, patterns []Pattern, resolver Resolver, opts Options, output recordWriter) (map[string]Record, Summary, error) {
 	results := make(map[string]Record)
+	var summary Summary
+	consecutiveFailures := 0
+	jitter := newJitter(opts.Jitter, opts.JitterSeed, opts.Rand)
+
+	// scanCtx is also cancelled when the scan aborts itself, stopping the other workers
+	scanCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan string)
+	go func() {
+		defer close(jobs)
+		for subdomain := range subdomains {
+			jitter.sleep(scanCtx)
+			opts.RateLimiter.wait(scanCtx)
+			select {
+			case jobs <- subdomain:
+			case <-scanCtx.Done():
+				return
+			}
+		}
+	}()
+
+	type lookupResult struct {
+		record Record
+		err    error
+	}
+	found := make(chan lookupResult)
+	var workers sync.WaitGroup
+	for i := 0; i < max(opts.Concurrency, 1); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for subdomain := range jobs {
+				started := time.Now()
+				var record Record
+				var err error
+				if opts.InputRecords != nil {
+					record, err = rematchRecord(scanCtx, opts.InputRecords[subdomain], patterns, opts)
+				} else {
+					record, err = lookupRecord(scanCtx, subdomain, patterns, resolver, opts)
+				}
+				if tags := opts.HostTags.tagsFor(subdomain); tags != nil {
+					record.Tags = tags
+				}
+				if err != nil {
+					slog.Debug("Lookup failed", "host", subdomain, "duration", time.Since(started), "error", err)
+				} else {
+					slog.Debug("Looked up host", "host", subdomain, "duration", time.Since(started))
+				}
+				found <- lookupResult{record, err}
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(found)
+	}()
+
+	var scanErr error
+	withheld := 0
+	for result := range found {
+		record, err := result.record, result.err
+		if scanErr != nil || (err != nil && scanCtx.Err() != nil) {
+			// The scan ended mid-lookup; this host was never really checked
+			continue
+		}
+		summary.Scanned++
+		opts.RateLimiter.observe(err != nil)
+		if !opts.DiscardResults && !(opts.RequireCNAME && emptyAnswer(record)) {
+			results[record.Subdomain] = record
+		}
+
+		if isReported(record, opts) && record.IsVulnerable && opts.FindingsLimit > 0 && summary.Findings >= opts.FindingsLimit {
+			withheld++
+		} else if isReported(record, opts) {
+			if writeErr := output.Write(record); writeErr != nil {
+				scanErr = fmt.Errorf("error writing result for %s: %v", record.Subdomain, writeErr)
+				summary.WriteFailed = true
+				cancel()
+				continue
+			}
+			if record.IsVulnerable {
+				summary.Findings++
+				if summary.Findings == opts.FindingsLimit {
+					if opts.AbortAtFindingsLimit {
+						scanErr = fmt.Errorf("%d findings written (limit %d), a wildcard zone may be matching every host", summary.Findings, opts.FindingsLimit)
+						summary.Aborted = true
+						cancel()
+					} else {
+						slog.Warn("Findings limit reached, writing no more findings", "limit", opts.FindingsLimit)
+					}
+				}
+			}
+		}
+		if err == nil && opts.Checkpoint != nil {
+			// Failed lookups stay out of the checkpoint so a resumed scan retries them
+			if cpErr := opts.Checkpoint.markDone(record.Subdomain); cpErr != nil {
+				scanErr = cpErr
+				summary.WriteFailed = true
+				cancel()
+				continue
+			}
+		}
 
-	for _, subdomain := range subdomains {
-		cname, err := getCNAMERecord(subdomain)
 		if err != nil {
-			return nil, err
+			summary.Failed++
+			consecutiveFailures++
+			if opts.MaxErrors > 0 && summary.Failed >= opts.MaxErrors {
+				scanErr = fmt.Errorf("%d lookups failed (limit %d), the resolver may be down: last error: %v", summary.Failed, opts.MaxErrors, err)
+				summary.Aborted = true
+				cancel()
+			} else if opts.MaxConsecutiveErrors > 0 && consecutiveFailures >= opts.MaxConsecutiveErrors {
+				scanErr = fmt.Errorf("%d consecutive lookups failed, the resolver may be down: last error: %v", consecutiveFailures, err)
+				summary.Aborted = true
+				cancel()
+			}
+			continue
 		}
+		consecutiveFailures = 0
+	}
+
+	if withheld > 0 {
+		slog.Warn("Findings not written over -findings-limit", "withheld", withheld, "limit", opts.FindingsLimit)
+	}
+	if scanErr == nil && ctx.Err() != nil {
+		scanErr = scanStopped(ctx.Err(), summary.Scanned, total)
+		summary.Stopped = true
+	}
+	return results, summary, scanErr
+}
+
+// scanStopped describes a scan that ended early because its context was done. A total
+// of zero means the number of subdomains is not known.
+func scanStopped(err error, done, total int) error {
+	progress := fmt.Sprintf("%d of %d subdomains", done, total)
+	if total == 0 {
+		progress = fmt.Sprintf("%d subdomains", done)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("maximum runtime exceeded after %s", progress)
+	}
+	return fmt.Errorf("scan cancelled after %s: %v", progress, err)
+}
 
-		wildcardDomain := extractWildcardDomain(cname)
-		isVulnerable := matchesAnyPattern(wildcardDomain, patterns)
+// lookupRecord resolves a single subdomain and matches its CNAME against patterns. IP
+// addresses are looked up in reverse and their PTR names are matched instead. A failed
+// lookup returns a Record with Error set along with the error. The lookup is bounded by
+// opts.Timeout without affecting ctx, so one slow host only fails itself.
+func lookupRecord(ctx context.Context, subdomain string, patterns []Pattern, resolver Resolver, opts Options) (Record, error) {
+	isIP := net.ParseIP(subdomain) != nil
 
-		results[subdomain] = Record{
-			CNAME:       cname,
-			IsVulnerable: isVulnerable,
+	// HTTP confirmation has its own timeout, -confirm-timeout, so it runs under ctx
+	// rather than under the DNS lookup's opts.Timeout
+	confirmCtx := ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	var answer Answer
+	var err error
+	if isIP {
+		answer, err = resolver.LookupPTR(ctx, subdomain)
+	} else {
+		answer, err = resolver.LookupCNAME(ctx, subdomain)
+	}
+	if err != nil {
+		if opts.Explain != nil {
+			opts.Explain.write(explainFailure(subdomain, err))
 		}
+		record := Record{Subdomain: subdomain, Error: err.Error()}
+		var rcodeErr *rcodeError
+		if errors.As(err, &rcodeErr) {
+			record.RCode = rcodeErr.rcode
+		}
+		return record, err
+	}
 
-		// Output the result to the writer
-		if isVulnerable {
-			fmt.Fprintf(output, "Subdomain: %s, CNAME: %s, Vulnerable: Yes\n", subdomain, cname)
+	record := Record{Subdomain: subdomain, RCode: answer.RCode}
+	if !isIP && len(answer.Records) > 0 {
+		// A DNAME above the name implies a CNAME even if the server did not synthesize one
+		answer.CNAMEs, record.DNAME = nextHops(subdomain, answer.Records)
+	}
+	if !isIP {
+		answer.CNAMEs = usableCNAMEs(subdomain, answer.CNAMEs, opts.MinCNAMELength)
+	}
+	var target string
+	if isIP {
+		var ptrs []string
+		ptrs, record.Truncated = capAnswer(subdomain, answer.PTRs, opts.MaxCNAMELength)
+		record.PTR = strings.Join(ptrs, "\n")
+		if record.PTR == "" {
+			record.PTR = "No PTR record"
+		}
+		target = strings.Join(ptrs, "\n")
+		record.AnswerHash = answerHash(target)
+		if reverse, err := reverseName(subdomain); err == nil {
+			record.TTL = answerTTL(reverse, "PTR", answer.Records)
+		}
+	} else {
+		var cnames []string
+		cnames, record.Truncated = capAnswer(subdomain, answer.CNAMEs, opts.MaxCNAMELength)
+		record.CNAME = strings.Join(cnames, "\n")
+		if opts.LowercaseCNAME {
+			record.CNAME = strings.ToLower(record.CNAME)
+		}
+		if record.CNAME == "" {
+			record.CNAME = "No CNAME record"
+		}
+		target = record.CNAME
+		record.AnswerHash = answerHash(record.CNAME)
+		record.MultipleCNAMEs = len(answer.CNAMEs) > 1
+		record.TTL = answerTTL(subdomain, "CNAME", answer.Records)
+		record.SubdomainRegistrable = registrableOrEmpty(subdomain)
+		if len(answer.CNAMEs) > 0 {
+			record.CNAMERegistrable = registrableOrEmpty(answer.CNAMEs[0])
+		}
+	}
+	record.Authoritative = answer.Authoritative
+	record.AnsweredBy = answer.AnsweredBy
+	if opts.FollowChain && len(answer.CNAMEs) > 0 {
+		var viaDNAME bool
+		record.Chain, viaDNAME = followChain(ctx, answer.CNAMEs[0], answer.Records, resolver)
+		record.DNAME = record.DNAME || viaDNAME
+		if opts.LowercaseCNAME {
+			for i, hop := range record.Chain {
+				record.Chain[i] = strings.ToLower(hop)
+			}
+		}
+		// Every hop is matched, so a fingerprint further down the chain is still found
+		target = strings.Join(append([]string{record.CNAME}, record.Chain[1:]...), "\n")
+	}
+	if !isIP && len(answer.CNAMEs) > 0 {
+		record.Resolution, record.SOAMinimum = resolveTarget(ctx, subdomain, resolver)
+		if opts.ASNDB != nil && record.Resolution == resolutionResolves {
+			record.TargetASN, record.TargetOrg = targetNetwork(ctx, subdomain, resolver, opts.ASNDB)
+		}
+	}
+	if answer.CrossChecked {
+		record.AuthorityAnswer = strings.Join(answer.Authority, "\n")
+		primary := answer.CNAMEs
+		if isIP {
+			primary = answer.PTRs
+		}
+		record.Discrepancy = answersDiffer(primary, answer.Authority)
+	}
+
+	candidates := matchCandidates(target, opts.MatchLiteral)
+	match, isVulnerable := matchesAnyPattern(candidates, patterns)
+	var ipMatch ipPattern
+	if !isIP && len(opts.IPPatterns) > 0 {
+		var ok bool
+		if record.MatchedIP, ipMatch, ok = matchResolvedIP(ctx, subdomain, resolver, opts.IPPatterns); ok {
+			record.MatchedRange = ipMatch.network.String()
+			if !isVulnerable {
+				match, isVulnerable = ipMatch.Pattern, true
+			}
+		}
+	}
+	if !isIP && opts.Delegation != nil {
+		found, err := opts.Delegation.check(ctx, subdomain)
+		if err != nil {
+			slog.Warn("Failed to check delegation", "host", subdomain, "error", err)
 		} else {
-			fmt.Fprintf(output, "Subdomain: %s, CNAME: %s, Vulnerable: No\n", subdomain, cname)
+			record.DelegationZone, record.Nameservers, record.LameDelegation = found.zone, found.nameservers, found.lame
+			if found.matched {
+				record.DelegationPattern = found.pattern.Value
+			}
+			if found.lame && !isVulnerable {
+				match, isVulnerable = found.pattern, true
+			}
 		}
 	}
+	applyVerdict(&record, match, isVulnerable, candidates, patterns, ipMatch, opts)
 
-	return results, nil
+	if opts.Confirmer != nil && isVulnerable {
+		opts.Confirmer.confirm(confirmCtx, &record, match)
+	}
+
+	if opts.Explain != nil {
+		opts.Explain.write(explainRecord(record, answer, candidates, patterns, opts))
+	}
+	return record, nil
 }
 
-// getCNAMERecord performs the dig command to get the CNAME record for a single subdomain.
-func getCNAMERecord(subdomain string) (string, error) {
-	cmd := exec.Command("dig", "+short", "CNAME", subdomain)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
-	if err != nil {
-		return "", fmt.Errorf("error executing dig command for %s: %v", subdomain, err)
+// applyVerdict sets the verdict of record from match, the pattern matched against
+// candidates if isVulnerable, and ipMatch, the IP pattern matching its address if any.
+func applyVerdict(record *Record, match Pattern, isVulnerable bool, candidates []string, patterns []Pattern, ipMatch ipPattern, opts Options) {
+	record.IsVulnerable = isVulnerable
+	record.MatchedPattern = match.Value
+	record.Severity = match.Severity
+	if isVulnerable {
+		record.Remediation = match.Remediation
+		if record.Remediation == "" {
+			record.Remediation = genericRemediation
+		}
+		record.TTLWarning = opts.TTLWarn.check(record.TTL)
 	}
 
-	cname := strings.TrimSpace(out.String())
-	if cname == "" {
-		return "No CNAME record", nil
+	if opts.AllMatches && isVulnerable {
+		for _, pattern := range matchAllPatterns(candidates, patterns) {
+			record.MatchedPatterns = append(record.MatchedPatterns, pattern.Value)
+		}
+		if record.MatchedRange != "" {
+			record.MatchedPatterns = append(record.MatchedPatterns, ipMatch.Value)
+		}
 	}
+	record.Confidence = confidence(*record, candidates)
+}
 
-	return cname, nil
+// usableCNAMEs returns the cnames of subdomain that are plausible targets, logging each
+// one dropped: a target that is not a valid host name or is shorter than minLength is a
+// garbled answer, and matching it would only produce spurious findings.
+func usableCNAMEs(subdomain string, cnames []string, minLength int) []string {
+	usable := make([]string, 0, len(cnames))
+	for _, cname := range cnames {
+		err := checkHostname(cname)
+		if err == nil && len(strings.TrimSuffix(cname, ".")) < minLength {
+			err = fmt.Errorf("shorter than %d characters", minLength)
+		}
+		if err != nil {
+			slog.Warn("Ignoring unusable CNAME", "host", subdomain, "cname", cname, "reason", err)
+			continue
+		}
+		usable = append(usable, cname)
+	}
+	return usable
+}
+
+// capAnswer returns the leading values of the answer for subdomain that fit in limit
+// bytes when joined by newlines, and whether any were cut. A first value longer than
+// limit is cut to limit bytes; a limit of zero keeps every value.
+func capAnswer(subdomain string, values []string, limit int) ([]string, bool) {
+	if limit <= 0 {
+		return values, false
+	}
+	length := 0
+	for i, value := range values {
+		if i > 0 {
+			length++
+		}
+		length += len(value)
+		if length <= limit {
+			continue
+		}
+		slog.Warn("Truncating oversized answer", "host", subdomain, "values", len(values), "max", limit)
+		if i == 0 {
+			return []string{value[:limit]}, true
+		}
+		return values[:i], true
+	}
+	return values, false
+}
+
+// maxChainLength bounds how many CNAME hops followChain walks.
+const maxChainLength = 10
+
+// followChain walks the CNAME chain starting at first and returns every hop in order,
+// beginning with first, and whether a DNAME redirected any hop. A DNAME covering a hop
+// without a CNAME is followed through the CNAME it implies. The walk stops at a name
+// without a CNAME, a loop, a failed lookup, or after maxChainLength hops. Hops found in
+// known, the typed records of an earlier answer, are taken from there; the rest are
+// looked up through resolver, so with a cachingResolver a tail shared by many hosts is
+// only queried once.
+func followChain(ctx context.Context, first string, known []ResourceRecord, resolver Resolver) ([]string, bool) {
+	known = append([]ResourceRecord(nil), known...) // the answer may be shared through the cache
+	chain := []string{first}
+	seen := map[string]bool{normalizeHost(first): true}
+	dname := false
+	for len(chain) < maxChainLength {
+		current := chain[len(chain)-1]
+		targets, viaDNAME := nextHops(current, known)
+		if len(targets) == 0 {
+			answer, err := resolver.LookupCNAME(ctx, current)
+			if err != nil {
+				break
+			}
+			known = append(known, answer.Records...)
+			if targets, viaDNAME = nextHops(current, known); len(answer.Records) == 0 {
+				targets = answer.CNAMEs // a backend without typed records
+			}
+			if len(targets) == 0 {
+				break
+			}
+		}
+		next := targets[0]
+		if seen[normalizeHost(next)] {
+			break
+		}
+		seen[normalizeHost(next)] = true
+		dname = dname || viaDNAME
+		chain = append(chain, next)
+	}
+	return chain, dname
 }
 
-// extractWildcardDomain filters out only the wildcard domains from the CNAME record.
+// Values of Record.Resolution.
+const (
+	resolutionResolves = "resolves"
+	resolutionDangling = "dangling"
+	resolutionFailed   = "failed"
+)
+
+// resolveTarget follows the CNAME chain of subdomain to its final addresses and reports
+// whether it resolves, dangles (no addresses or NXDOMAIN) or could not be checked. For a
+// target that does not exist it also returns the SOA minimum of the target's zone, zero
+// when the backend did not report the authority section.
+func resolveTarget(ctx context.Context, subdomain string, resolver Resolver) (string, uint32) {
+	answer, err := resolver.LookupAddrs(ctx, subdomain)
+	switch {
+	case err != nil:
+		return resolutionFailed, 0
+	case len(answer.Addrs) > 0:
+		return resolutionResolves, 0
+	case answer.NXDomain:
+		return resolutionDangling, soaMinimum(answer.Authorities)
+	default:
+		return resolutionDangling, 0
+	}
+}
+
+// isReported reports whether a record passes the output filters in opts. Names with several
+// CNAMEs are always reported unless -skip-resolvable drops them.
+func isReported(record Record, opts Options) bool {
+	if opts.RequireCNAME && emptyAnswer(record) {
+		return false
+	}
+	if opts.All {
+		return true
+	}
+	if opts.SkipResolvable && record.Resolution == resolutionResolves && record.MatchedRange == "" {
+		return false // an address range match is about where the target resolves to
+	}
+	if record.MultipleCNAMEs {
+		return true // a broken zone is worth seeing whatever it points at
+	}
+	if opts.MinSeverity != SeverityNone && (!record.IsVulnerable || record.Severity < opts.MinSeverity) {
+		return false
+	}
+	return record.Confidence >= opts.MinConfidence
+}
+
+// emptyAnswer reports whether a successful lookup found no CNAME, or no PTR for an IP
+// address.
+func emptyAnswer(record Record) bool {
+	return record.Error == "" && (record.CNAME == "No CNAME record" || record.PTR == "No PTR record")
+}
+
+// jitter delays lookups by a random duration in [0, max).
+type jitter struct {
+	max time.Duration
+	rng interface{ Int64N(int64) int64 }
+}
+
+// newJitter returns a jitter with delays up to max, seeded with seed, or drawn from
+// shared if seed is zero.
+func newJitter(max time.Duration, seed uint64, shared *runRand) *jitter {
+	if seed != 0 {
+		return &jitter{max: max, rng: rand.New(rand.NewPCG(seed, seed))}
+	}
+	if shared == nil {
+		shared = newRunRand(0)
+	}
+	return &jitter{max: max, rng: shared}
+}
+
+// sleep waits for the next random delay or until ctx is done; it returns immediately when
+// jitter is disabled.
+func (j *jitter) sleep(ctx context.Context) {
+	if j.max <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(time.Duration(j.rng.Int64N(int64(j.max))))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// answerHash returns a hex SHA-256 of the normalized, sorted answers in a CNAME record so
+// that two scans can be compared for changes without storing the answers themselves.
+func answerHash(cname string) string {
+	var answers []string
+	if cname != "No CNAME record" {
+		for _, answer := range strings.Fields(cname) {
+			answers = append(answers, strings.TrimSuffix(strings.ToLower(answer), "."))
+		}
+	}
+	sort.Strings(answers)
+
+	sum := sha256.Sum256([]byte(strings.Join(answers, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// extractWildcardDomain strips every leading wildcard label from a CNAME target, so
+// "*.*.example.com" becomes "example.com" and a target of only "*." becomes empty. Each
+// line of a multi-line target is stripped on its own.
 func extractWildcardDomain(cname string) string {
 	if cname == "No CNAME record" {
 		return ""
 	}
 
-	// Remove the leading '*' if present
-	cname = strings.TrimSpace(cname)
-	if strings.HasPrefix(cname, "*.") {
-		cname = cname[2:] // Remove the "*." from the start
+	lines := strings.Split(strings.TrimSpace(cname), "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		for strings.HasPrefix(line, "*.") {
+			line = strings.TrimSpace(line[2:])
+		}
+		if line == "*" {
+			line = ""
+		}
+		lines[i] = line
 	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
 
-	return cname
+// matchCandidates returns the forms of target that are matched against patterns: the
+// target with its leading "*." labels stripped and, when literal is set and the target really
+// starts with "*.", the untouched target as well. DNS names are case-insensitive, so the
+// forms are lowercased; the record keeps the target as the server returned it.
+func matchCandidates(target string, literal bool) []string {
+	stripped := strings.ToLower(extractWildcardDomain(target))
+	if stripped == "" {
+		return nil
+	}
+	candidates := []string{stripped}
+	if trimmed := strings.ToLower(strings.TrimSpace(target)); literal && trimmed != stripped {
+		candidates = append(candidates, trimmed)
+	}
+	return candidates
 }
 
-// matchesAnyPattern checks if the domain matches any of the given patterns.
-func matchesAnyPattern(domain string, patterns []string) bool {
-	if domain == "" {
-		return false
+// matchesAnyPattern checks if any of the domains matches any of the given patterns and
+// returns the first match. Patterns are expected in descending severity order (as
+// loadPatterns returns them), so the first match is also the highest-severity one.
+//
+// Pattern order takes precedence over domain order: every domain form is tried against a
+// pattern before moving on to the next pattern. The stripped form is tried before the
+// literal "*." form, which only decides the match when both forms hit the same pattern.
+func matchesAnyPattern(domains []string, patterns []Pattern) (Pattern, bool) {
+	for _, pattern := range patterns {
+		if matchesPattern(domains, pattern) {
+			return pattern, true
+		}
 	}
+	return Pattern{}, false
+}
+
+// matchAllPatterns returns every pattern any of the domains matches, in pattern order.
+func matchAllPatterns(domains []string, patterns []Pattern) []Pattern {
+	var matches []Pattern
 	for _, pattern := range patterns {
-		if strings.Contains(domain, pattern) {
+		if matchesPattern(domains, pattern) {
+			matches = append(matches, pattern)
+		}
+	}
+	return matches
+}
+
+// matchesPattern reports whether any of the domains, lowercased by matchCandidates,
+// contains the pattern regardless of its case, or satisfies it when the pattern is a
+// match expression.
+func matchesPattern(domains []string, pattern Pattern) bool {
+	if pattern.expr != nil {
+		for _, domain := range domains {
+			if domain != "" && pattern.expr.eval(strings.Split(domain, "\n")) {
+				return true
+			}
+		}
+		return false
+	}
+	value := strings.ToLower(pattern.Value)
+	for _, domain := range domains {
+		if domain != "" && strings.Contains(domain, value) {
 			return true
 		}
 	}
 	return false
 }
 
+// openResultFile creates (truncating) the result file, or with appendMode opens it for
+// appending, creating it if needed. It reports whether earlier results are being continued.
+// The name "-" selects stdout.
+func openResultFile(name string, appendMode bool) (*os.File, bool, error) {
+	if name == stdioName {
+		return os.Stdout, false, nil
+	}
+	if !appendMode {
+		file, err := os.Create(name)
+		return file, false, err
+	}
+
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, false, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, false, err
+	}
+	return file, info.Size() > 0, nil
+}
+
+// normalizeHost lowercases a host name and strips surrounding space and any trailing dot.
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+// maxHostnameLength is the longest host name in presentation form, without the trailing dot.
+const maxHostnameLength = 253
+
+// checkHostname reports why name is not a valid host name: every label is 1 to 63
+// letters, digits, hyphens or underscores, not starting or ending with a hyphen, and the
+// whole name fits in maxHostnameLength. A leading "*" label is accepted for wildcards.
+// It checks both input hosts and the CNAME targets servers return.
+func checkHostname(name string) error {
+	host := strings.TrimSuffix(name, ".")
+	if host == "" {
+		return errors.New("empty name")
+	}
+	if len(host) > maxHostnameLength {
+		return fmt.Errorf("longer than %d characters", maxHostnameLength)
+	}
+	for i, label := range strings.Split(host, ".") {
+		switch {
+		case label == "":
+			return errors.New("empty label")
+		case label == "*" && i == 0:
+			continue
+		case len(label) > 63:
+			return fmt.Errorf("label %q longer than 63 characters", label)
+		case label[0] == '-' || label[len(label)-1] == '-':
+			return fmt.Errorf("label %q starts or ends with a hyphen", label)
+		}
+		for _, c := range label {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+				return fmt.Errorf("label %q has invalid character %q", label, c)
+			}
+		}
+	}
+	return nil
+}
+
+// maxLineLength is the longest line readLinesFromFile accepts. Longer lines are skipped.
+const maxLineLength = 4 * 1024 * 1024
+
+// skipLongLines returns a bufio.SplitFunc that splits like bufio.ScanLines but skips
+// (and logs) lines longer than maxLineLength instead of failing with bufio.ErrTooLong.
+func skipLongLines(filename string) bufio.SplitFunc {
+	lineNumber := 0
+	skipping := false
+
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if skipping {
+			if i := bytes.IndexByte(data, '\n'); i >= 0 {
+				skipping = false
+				return i + 1, nil, nil
+			}
+			return len(data), nil, nil
+		}
+
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil {
+			lineNumber++
+			return advance, token, err
+		}
+
+		// The buffer is full and still holds no complete line; drop it and discard
+		// the rest of the line when it finally arrives.
+		if advance == 0 && len(data) >= maxLineLength {
+			lineNumber++
+			skipping = true
+			slog.Warn("Skipping line longer than the limit", "file", filename, "line", lineNumber, "max_bytes", maxLineLength)
+			return len(data), nil, nil
+		}
+
+		return advance, token, err
+	}
+}
+
 // readLinesFromFile reads lines from a text file and returns them as a slice of strings.
 func readLinesFromFile(filename string) ([]string, error) {
 	file, err := os.Open(filename)
@@ -101,8 +988,15 @@ func readLinesFromFile(filename string) ([]string, error) {
 	}
 	defer file.Close()
 
+	return readLines(file, filename)
+}
+
+// readLines reads the non-empty, trimmed lines from r. The name identifies r in log and error messages.
+func readLines(r io.Reader, name string) ([]string, error) {
 	var lines []string
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+	scanner.Split(skipLongLines(name))
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if line != "" {
@@ -111,42 +1005,1147 @@ func readLinesFromFile(filename string) ([]string, error) {
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading file %s: %v", filename, err)
+		return nil, fmt.Errorf("error reading file %s: %v", name, err)
 	}
 
 	return lines, nil
 }
 
-func main() {
-	if len(os.Args) < 4 {
-		log.Fatalf("Usage: %s <subdomains-file> <patterns-file> <result-file>", os.Args[0])
+// runScan implements the scan command: it resolves the subdomains, matches them against
+// the patterns and writes the results.
+func runScan(args []string) {
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	var fingerprints stringList
+	fs.Var(&fingerprints, "fingerprints", "load patterns from this file, glob or http(s):// URL instead of the patterns-file argument; repeatable, or comma-separated, merging the patterns")
+	withDefaults := fs.Bool("with-defaults", false, "add the built-in default fingerprints to the patterns file or -fingerprints source")
+	fingerprintsCache := fs.String("fingerprints-cache", "", "cache fetched -fingerprints URLs in this file and use it when a fetch fails")
+	format := fs.String("format", formatText, "output format: text, json, jsonl, csv, compact (tab-separated subdomain, cname and matched_pattern of vulnerable hosts), or html (a standalone report of the findings)")
+	flushEvery := fs.Int("flush-every", 0, "flush the result file after this many records (0 leaves it to -flush-interval)")
+	flushInterval := fs.Duration("flush-interval", defaultFlushInterval, "flush the result file at most this long after a record is written (0 flushes only with -flush-every and at the end)")
+	header := fs.Bool("header", false, "start text output with \"# \" comment lines naming the version, time, resolver and pattern and host counts of the scan")
+	appendOutput := fs.Bool("append", false, "append to the result file instead of truncating it (text, jsonl and csv; the csv header is not repeated, so the file must have the same columns)")
+	fieldList := fs.String("fields", "", "comma-separated record fields to include in json, jsonl and csv output, in order (e.g. subdomain,cname,matched_pattern)")
+	redact := fs.Bool("redact", false, "replace subdomain names in the result file with tokens, keeping patterns and verdicts (needs -redact-map)")
+	redactCNAME := fs.Bool("redact-cname", false, "also redact CNAME targets (implies -redact)")
+	redactMap := fs.String("redact-map", "", "token-to-name mapping file for -redact, reused across runs; restore names with the unredact command")
+	gzipOutput := fs.Bool("gzip-output", false, "gzip the result file, adding .gz to its name")
+	outputEncrypt := fs.Bool("output-encrypt", false, "encrypt the result file with AES-256-GCM (needs -encrypt-passphrase or -encrypt-keyfile); read it back with the decrypt command")
+	encryptPassphrase := fs.String("encrypt-passphrase", "", "passphrase for -output-encrypt; prefer setting "+envName("encrypt-passphrase"))
+	encryptKeyFile := fs.String("encrypt-keyfile", "", "file holding the key material for -output-encrypt (at least 16 bytes)")
+	countOnly := fs.Bool("count-only", false, "only count how many hosts have a CNAME, match a pattern, dangle or fail, and print the counts instead of writing records; no confirmation is done")
+	syslogOutput := fs.Bool("syslog", false, "send each record to syslog as a JSON message instead of writing a result file (vulnerable records at warning severity)")
+	syslogAddr := fs.String("syslog-addr", "", "remote syslog server for -syslog, as udp://host:port, tcp://host:port or host:port (default the local daemon)")
+	syslogFacility := fs.String("syslog-facility", "user", "syslog facility for -syslog, e.g. daemon or local0")
+	syslogTag := fs.String("syslog-tag", "digcname", "syslog tag for -syslog")
+	onFinding := fs.String("on-finding", "", "run this shell command for every vulnerable host reported, with the record as JSON on stdin and its key fields in "+envPrefix+"FINDING_* environment variables")
+	onFindingConcurrency := fs.Int("on-finding-concurrency", 4, "maximum -on-finding commands running at once")
+	esURL := fs.String("es-url", "", "index results into the Elasticsearch/OpenSearch cluster at this URL via the bulk API")
+	esIndex := fs.String("es-index", "", "index name for -es-url")
+	esUser := fs.String("es-user", "", "basic auth username for -es-url")
+	esPassword := fs.String("es-password", "", "basic auth password for -es-url")
+	esBatchSize := fs.Int("es-batch-size", defaultESBatchSize, "number of records per bulk request for -es-url")
+	sqliteFile := fs.String("sqlite", "", "upsert results into the records table of this SQLite database (requires the sqlite3 command)")
+	graphFile := fs.String("graph", "", "write the CNAME graph to this Graphviz DOT file")
+	summaryJSON := fs.Bool("summary-json", false, "write a JSON summary of the scan (hosts scanned, findings, failures, exit status and duration) to stdout at the end and nothing else there, for orchestrators; needs a result file other than stdout")
+	listProviders := fs.Bool("list-providers", false, "after the scan, print how many vulnerable hosts each pattern matched, most first")
+	minConfidence := fs.Int("min-confidence", 0, "only report records whose confidence score (0-100) is at least this")
+	var ttlWarn ttlThresholds
+	fs.Var(&ttlWarn, "ttl-warn", "flag vulnerable records whose TTL is above this duration (slow to clear after a fix), or outside short,long such as 30s,24h")
+	minCNAMELength := fs.Int("min-cname-length", 4, "treat CNAME targets shorter than this, or that are not valid host names, as no CNAME")
+	maxCNAMELength := fs.Int("max-cname-length", 512, "cut CNAME and PTR answers longer than this many bytes and mark the record truncated (0 for no limit)")
+	requireCNAME := fs.Bool("require-cname", false, "drop hosts that returned no CNAME (IP addresses with no PTR) from the output and results, even with -all")
+	skipResolvable := fs.Bool("skip-resolvable", false, "drop hosts whose CNAME chain still resolves to an address, keeping only dangling ones")
+	all := fs.Bool("all", false, "report every record, ignoring -min-severity, -min-confidence and -skip-resolvable")
+	minSeverityName := fs.String("min-severity", "", "only report vulnerable records at or above this severity (info, low, medium, high, critical)")
+	resolversFile := fs.String("resolvers-file", "", "add the servers in this file, one per line as -server takes them with an optional weight after = or a space, to the -server list")
+	server := fs.String("server", "", "query this nameserver directly instead of the system resolver, a DNS-over-HTTPS endpoint given as an https:// URL, or a DNS-over-TLS server given as tls://host[:port]; a comma-separated list spreads lookups over several, favoring healthy ones, or in proportion to weights given as server=weight")
+	dotServer := fs.String("dot", "", "resolve over DNS-over-TLS through this server (host or host:port, port 853 by default), the same as -server tls://<host>")
+	dotPin := fs.String("dot-pin", "", "only accept DNS-over-TLS servers whose certificate public key has one of these comma-separated hex SHA-256 digests (of the DER SubjectPublicKeyInfo)")
+	resolverFromSystem := fs.Bool("resolver-from-system", false, "configure lookups from "+systemResolvConf+": query all of its nameservers, expand bare names with its search domains and ndots, and bound lookups by its timeout and attempts unless -timeout is given")
+	compareTwo := fs.String("compare-two", "", "scan with the -server resolvers and then with this comma-separated resolver list, and write only the hosts whose CNAME or verdict differs; the filter flags are ignored")
+	resolverCooldown := fs.Duration("resolver-cooldown", defaultResolverCooldown, "with several -server resolvers, bench a failing one for this long before using it again")
+	useDig := fs.Bool("use-dig", false, "always resolve with the dig command")
+	useNative := fs.Bool("use-native", false, "always resolve with the built-in DNS client")
+	replayDir := fs.String("replay", "", "answer lookups from captured dig output in this directory (<dir>/<host>.txt) instead of the network")
+	digPath := fs.String("dig-path", "", "run this dig binary for the dig backend instead of dig on PATH")
+	digArgs := fs.String("dig-args", "", "extra options for every dig query, separated by spaces (e.g. \"+bufsize=4096 +tcp\")")
+	showAnswerHash := fs.Bool("answer-hash", false, "include a SHA-256 of each host's normalized answers in output for change detection")
+	collapse := fs.Bool("collapse", false, "write one entry per CNAME target listing every host pointing at it, instead of one per host, in text, json or jsonl output written at the end of the scan")
+	noRecursion := fs.Bool("no-recursion", false, "send queries with recursion desired cleared (RD=0) and record whether answers were authoritative or cached")
+	var targets stringList
+	fs.Var(&targets, "target", "scan this subdomain too, without a subdomains file; repeatable, or comma-separated")
+	parallelFiles := fs.String("parallel-files", "", "scan every subdomains file given as an argument in one run, sharing the resolver, cache and workers, and write each file's results to <input-name>.<format> in this directory (default format json)")
+	preserveCNAMECase := fs.Bool("preserve-cname-case", true, "write CNAME targets exactly as the server returned them, as evidence; false lowercases them (matching ignores case either way)")
+	hostsFile := fs.String("hosts-file", "", "answer the names in this file, of \"<ip> <name>...\", \"<name> CNAME <target>\" or \"<name> NXDOMAIN\" lines, instead of querying DNS")
+	asnDBFile := fs.String("asn-db", "", "MaxMind DB file, such as GeoLite2-ASN.mmdb, to look up the autonomous system of each resolving CNAME target in")
+	inputJSON := fs.String("input-json", "", "match the stored answers of an earlier -format json or jsonl result file against the current patterns instead of querying DNS")
+	dryRun := fs.Bool("dry-run", false, "print a sample of the subdomains that would be queried after expansion, canonicalization and exclusions, and their total, without querying them")
+	expand := fs.Bool("expand", false, "expand brace patterns in subdomains, e.g. {api,admin}.example.com or host{1..5}.example.com")
+	stripScheme := fs.Bool("strip-scheme", false, "reduce URL-like input lines (https://host:port/path) to the host name")
+	lowercase := fs.Bool("lowercase", false, "lowercase subdomains before scanning")
+	stripTrailingDot := fs.Bool("strip-trailing-dot", false, "remove the trailing dot of fully qualified subdomains")
+	stripWWW := fs.Bool("strip-www", false, "scan www.example.com as example.com")
+	strictTLD := fs.Bool("strict-tld", false, "skip subdomains that are not valid host names or whose TLD is not on the embedded public suffix list, logging them as invalid")
+	checkpointFile := fs.String("checkpoint", "", "record completed hosts in this append-only file and skip them when the scan is run again, appending to the earlier results")
+	excludeFile := fs.String("exclude", "", "skip subdomains listed in this file (exact names, or *.suffix / .suffix for whole subtrees)")
+	shardFlag := fs.String("shard", "", "scan only shard M of N of the hosts, as in 2/5, chosen by an FNV-1a hash of each lowercased name so N nodes with shards 1/N to N/N cover the list exactly once")
+	allMatches := fs.Bool("all-matches", false, "report every pattern a CNAME matches instead of only the first (most severe)")
+	ipPatternsFile := fs.String("ip-patterns", "", "also match the addresses each host finally resolves to against the IP addresses and CIDR ranges in this file, one per line with an optional severity prefix")
+	matchLiteral := fs.Bool("match-literal", false, "also match CNAMEs starting with \"*.\" in their literal form, not only with the wildcard stripped")
+	confirm := fs.Bool("confirm", false, "confirm vulnerable records over HTTP by checking the response status and body for the takeover fingerprint")
+	confirmTimeout := fs.Duration("confirm-timeout", defaultConfirmTimeout, "maximum time to confirm each record, including redirects and the fallback from HTTPS to HTTP")
+	confirmMaxRedirects := fs.Int("confirm-max-redirects", defaultConfirmMaxRedirects, "follow at most this many redirects when confirming")
+	probePath := fs.String("probe-path", "/", "URL path to request when confirming, for fingerprints that do not name their own path")
+	insecureConfirm := fs.Bool("insecure-confirm", false, "do not verify HTTPS certificates when confirming, since unclaimed resources often serve invalid ones; findings read this way are marked confirm_insecure. DNS-over-HTTPS and other connections still verify")
+	confirmSample := fs.Float64("confirm-sample", 1, "confirm only this fraction of vulnerable records, chosen at random (e.g. 0.1), to estimate the confirmation rate cheaply")
+	confirmSeed := fs.Uint64("confirm-sample-seed", 0, "seed for -confirm-sample, for reproducible runs (0 derives one from -seed)")
+	followChainFlag := fs.Bool("follow-chain", false, "follow CNAME targets hop by hop and match patterns against every hop")
+	checkDelegation := fs.Bool("check-delegation", false, "also find the nameservers of each host's zone, match them against the patterns and flag lame delegations, where none of the matching nameservers serves the zone, as takeovers of the whole zone")
+	crossCheck := fs.Bool("cross-check", false, "repeat every lookup against the zone's authoritative nameservers and flag answers that disagree")
+	timeout := fs.Duration("timeout", defaultQueryTimeout, "maximum time for each individual lookup; a slow host fails on its own without stopping the scan")
+	maxRuntime := fs.Duration("max-runtime", 0, "maximum time for the whole scan; outstanding lookups are cancelled when it is reached (0 means unlimited)")
+	watchInterval := fs.Duration("watch", 0, "rescan the subdomains file at this interval until interrupted (e.g. 15m)")
+	onlyChanged := fs.Bool("only-changed", false, "write only hosts whose verdict or CNAME changed since the previous scan; the filter flags are ignored")
+	var rotate rotatePolicy
+	fs.Var(&rotate, "rotate", "with -watch, move the result file aside with a timestamp and start a new one once it reaches this size or age (e.g. 100MB or 24h)")
+	stateFile := fs.String("state-file", "", "keep the -only-changed host state in this file, so changes are also detected across runs")
+	compareBaselineSeverity := fs.String("compare-baseline-severity", "", "with -only-changed, write only changes for the worse: a vulnerable host whose severity rose, or a host newly vulnerable at this severity or above (e.g. high); other changes are only logged")
+	rate := fs.Float64("rate", 0, "maximum lookups per second across all workers (0 means unlimited); with -auto-rate, the ceiling the tuner may reach")
+	autoRate := fs.Bool("auto-rate", false, fmt.Sprintf("tune the lookup rate while scanning: start at -rate, or %d per second, raise it while lookups succeed and halve it when more than %g%% fail", autoRateStart, autoRateBackoff*100))
+	jitterMax := fs.Duration("jitter", 0, "add a random delay of up to this duration before each lookup (e.g. 50ms)")
+	timeoutJitter := fs.Duration("timeout-jitter", 0, fmt.Sprintf("base of the random backoff before a lookup that failed on one -server is retried on another: retry n waits a random time below min(%s, base*2^n); 0 retries at once", maxRetryBackoff))
+	jitterSeed := fs.Uint64("jitter-seed", 0, "seed for -jitter delays, for reproducible runs (0 draws them from -seed)")
+	seed := fs.Uint64("seed", 0, "seed for all randomization (jitter, sampling), so a run can be repeated; with -replay results are deterministic (0 picks a random seed)")
+	concurrency := fs.Int("concurrency", defaultConcurrency, "number of lookups to run in parallel")
+	benchmark := fs.Duration("benchmark", 0, fmt.Sprintf("instead of scanning, look up up to %d of the hosts over and over for this long (e.g. 10s) with the resolver, -concurrency, -rate and -timeout given, and print the queries per second, latency percentiles and error rate reached", benchmarkSample))
+	dohConcurrency := fs.Int("doh-concurrency", 0, "maximum DNS-over-HTTPS requests in flight when -server is an https:// URL (0 means -concurrency)")
+	maxErrors := fs.Int("max-errors", 0, "abort the scan after this many failed lookups (0 means unlimited)")
+	maxConsecutiveErrors := fs.Int("max-consecutive-errors", 0, "abort the scan after this many failed lookups in a row (0 means unlimited)")
+	findingsLimit := fs.Int("findings-limit", 0, "stop writing findings after this many, warning how many more were found, to contain a wildcard zone that matches every host (0 means unlimited)")
+	findingsLimitAbort := fs.Bool("findings-limit-abort", false, "abort the scan when -findings-limit is reached instead of scanning on without writing findings")
+	validate := fs.Bool("validate-patterns", false, "check the patterns for duplicates, redundant or malformed entries and exit without scanning")
+	explain := fs.Bool("explain", false, "write a trace of how each host's verdict was reached (lookup, match candidates, every pattern tried, dangling check, confirmation) to stderr")
+	explainFile := fs.String("explain-file", "", "write the -explain trace to this file instead of stderr (implies -explain)")
+	traceDNS := fs.Bool("trace-dns", false, "log every DNS message the native and DNS-over-HTTPS backends send and receive, as hex and decoded (implies debug logging)")
+	verbose := fs.Bool("v", false, "log verbose progress details and add details such as the answering server to text results")
+	logFormat := fs.String("log-format", logFormatText, "format of log messages on stderr: text or json")
+	cpuProfile := fs.String("cpuprofile", "", "write a CPU profile to this file")
+	memProfile := fs.String("memprofile", "", "write a heap profile to this file when the scan finishes")
+	pprofAddr := fs.String("pprof-addr", "", "serve live pprof over HTTP on this address (e.g. localhost:6060)")
+	configFile := fs.String("config", "", "read flag defaults from this file of \"name = value\" lines (default $"+configEnv+")")
+	fs.Usage = func() { printCommandUsage(fs, scanCommand) }
+	parseFlags(fs, args)
+	if err := applyConfig(fs, *configFile); err != nil {
+		configError("Failed to load configuration: %v", err)
+	}
+	if err := setupLogging(*logFormat, *verbose || *traceDNS); err != nil {
+		configError("Invalid -log-format: %v", err)
+	}
+
+	// -validate-patterns is the flag form of the validate command and only needs the
+	// patterns: [<patterns-file>] or -fingerprints
+	if *validate {
+		if fs.NArg() > 1 || (fs.NArg() == 1 && len(fingerprints) > 0) {
+			usageError(fs, "-validate-patterns takes at most one patterns file, and none with -fingerprints")
+		}
+		source := fingerprints.String()
+		if fs.NArg() == 1 {
+			source = fs.Arg(0)
+		}
+		validatePatternSource(source, *fingerprintsCache, *withDefaults)
+		return
 	}
 
-	subdomainsFile := os.Args[1]
-	patternsFile := os.Args[2]
-	resultFile := os.Args[3]
+	if *benchmark > 0 && (*syslogOutput || *countOnly || *dryRun || *watchInterval > 0 || *compareTwo != "" || *checkpointFile != "" || *parallelFiles != "" || *inputJSON != "" || *summaryJSON) {
+		configError("-benchmark writes no records and cannot be combined with -syslog, -count-only, -dry-run, -watch, -compare-two, -checkpoint, -parallel-files, -input-json or -summary-json")
+	}
 
-	// Read subdomains and patterns from the respective files
-	subdomains, err := readLinesFromFile(subdomainsFile)
+	// The patterns file argument is optional: without it (and without -fingerprints) the
+	// built-in default fingerprints are used. Without any file arguments digcname works as
+	// a filter, reading subdomains from stdin and writing results to stdout. With -target
+	// names stdin is not read, and a single argument names the result file. With -syslog
+	// there is no result file argument.
+	// With -parallel-files every argument is a subdomains file
+	if *parallelFiles != "" {
+		if fs.NArg() == 0 {
+			usageError(fs, "-parallel-files expects one or more <subdomains-file> arguments")
+		}
+		if *syslogOutput || len(targets) > 0 || *watchInterval > 0 || *compareTwo != "" || *checkpointFile != "" || rotate.enabled() || *sqliteFile != "" || *graphFile != "" || *redact || *redactCNAME || *inputJSON != "" {
+			configError("-parallel-files cannot be combined with -syslog, -target, -watch, -compare-two, -checkpoint, -rotate, -sqlite, -graph, -redact or -input-json")
+		}
+	} else if *inputJSON != "" {
+		if fs.NArg() > 2 || (fs.NArg() == 2 && len(fingerprints) > 0) {
+			usageError(fs, "with -input-json, expected no file arguments, <result-file>, or <patterns-file> <result-file>")
+		}
+		// The stored records stand in for the subdomains and for every DNS answer
+		if *syslogOutput || *countOnly || len(targets) > 0 || *watchInterval > 0 || *compareTwo != "" || *checkpointFile != "" || *replayDir != "" || *ipPatternsFile != "" {
+			configError("-input-json cannot be combined with -syslog, -count-only, -target, -watch, -compare-two, -checkpoint, -replay or -ip-patterns")
+		}
+	} else if *countOnly {
+		if fs.NArg() > 2 || (fs.NArg() == 2 && len(fingerprints) > 0) {
+			usageError(fs, "with -count-only, expected no file arguments, <subdomains-file>, or <subdomains-file> <patterns-file>")
+		}
+		if *syslogOutput || *watchInterval > 0 || *compareTwo != "" || *checkpointFile != "" || *onlyChanged || *sqliteFile != "" || *graphFile != "" || *esURL != "" {
+			configError("-count-only writes no records and cannot be combined with -syslog, -watch, -compare-two, -checkpoint, -only-changed, -sqlite, -graph or -es-url")
+		}
+	} else if *syslogOutput {
+		if fs.NArg() > 2 || (fs.NArg() == 2 && len(fingerprints) > 0) {
+			usageError(fs, "with -syslog, expected no file arguments, <subdomains-file>, or <subdomains-file> <patterns-file>")
+		}
+	} else if *benchmark > 0 {
+		if fs.NArg() > 1 {
+			usageError(fs, "with -benchmark, expected no file arguments or <subdomains-file>")
+		}
+	} else if (fs.NArg() == 1 && len(targets) == 0) || fs.NArg() > 3 || (fs.NArg() == 3 && len(fingerprints) > 0) {
+		usageError(fs, "expected no file arguments, <subdomains-file> <result-file>, or <subdomains-file> <patterns-file> <result-file>")
+	}
+
+	err := startProfiling(*cpuProfile, *memProfile, *pprofAddr)
 	if err != nil {
-		log.Fatalf("Failed to read subdomains from file: %v", err)
+		fatal("Failed to start profiling", err)
+	}
+	defer stopProfiles()
+
+	subdomainsFile, resultFile := stdioName, stdioName
+	if len(targets) > 0 {
+		subdomainsFile = "" // only the -target names, unless a subdomains file is given too
+	}
+	patternsFile := fingerprints.String()
+	switch {
+	case *parallelFiles != "":
+		subdomainsFile, resultFile = "", ""
+	case *inputJSON != "":
+		subdomainsFile = ""
+		if fs.NArg() > 0 {
+			resultFile = fs.Arg(fs.NArg() - 1)
+		}
+		if fs.NArg() > 1 {
+			patternsFile = fs.Arg(0)
+		}
+	case *benchmark > 0:
+		resultFile = ""
+		if fs.NArg() > 0 {
+			subdomainsFile = fs.Arg(0)
+		}
+	case *syslogOutput || *countOnly:
+		resultFile = ""
+		if fs.NArg() > 0 {
+			subdomainsFile = fs.Arg(0)
+		}
+		if fs.NArg() > 1 {
+			patternsFile = fs.Arg(1)
+		}
+	case fs.NArg() == 1:
+		resultFile = fs.Arg(0)
+	case fs.NArg() == 2:
+		subdomainsFile, resultFile = fs.Arg(0), fs.Arg(1)
+	case fs.NArg() == 3:
+		subdomainsFile, patternsFile, resultFile = fs.Arg(0), fs.Arg(1), fs.Arg(2)
+	}
+
+	if *gzipOutput && resultFile != stdioName && resultFile != "" && !strings.HasSuffix(resultFile, ".gz") {
+		resultFile += ".gz"
 	}
 
-	patterns, err := readLinesFromFile(patternsFile)
+	// Results piped to stdout default to JSON Lines so downstream tools can parse them
+	formatSet := false
+	fs.Visit(func(f *flag.Flag) { formatSet = formatSet || f.Name == "format" })
+	if resultFile == stdioName && !formatSet {
+		*format = formatJSONL
+	}
+	// -summary-json owns stdout, so nothing else may write there
+	if *summaryJSON && (resultFile == stdioName || *countOnly || *dryRun || *watchInterval > 0) {
+		configError("-summary-json needs a result file other than stdout and does not support -count-only, -dry-run or -watch")
+	}
+	if *parallelFiles != "" && !formatSet {
+		*format = formatJSON
+	}
+	if *syslogOutput && (formatSet || *appendOutput || *gzipOutput || *outputEncrypt || rotate.enabled()) {
+		configError("-syslog writes JSON messages and cannot be combined with -format, -append, -gzip-output, -output-encrypt or -rotate")
+	}
+	if *syslogAddr != "" && !*syslogOutput {
+		configError("-syslog-addr needs -syslog")
+	}
+
+	var resume *checkpoint
+	if *checkpointFile != "" {
+		if *watchInterval > 0 || rotate.enabled() || *gzipOutput || *outputEncrypt || ((*format == formatJSON || *format == formatHTML) && !*syslogOutput) {
+			configError("-checkpoint cannot be combined with -watch, -rotate, -gzip-output, -output-encrypt, -format json or -format html")
+		}
+		if resume, err = openCheckpoint(*checkpointFile); err != nil {
+			fatal("Failed to open checkpoint", err)
+		}
+		defer resume.Close()
+		if resume.completed() > 0 {
+			slog.Info("Resuming from checkpoint", "completed", resume.completed(), "checkpoint", *checkpointFile)
+			*appendOutput = true // merge with the results of the interrupted run
+		}
+	}
+
+	var exclude exclusions
+	if *excludeFile != "" {
+		exclusionLines, err := readLinesFromFile(*excludeFile)
+		if err != nil {
+			fatal("Failed to read exclusions from file", err)
+		}
+		exclude = parseExclusions(exclusionLines)
+	}
+	var hostShard *shard
+	if *shardFlag != "" {
+		s, err := parseShard(*shardFlag)
+		if err != nil {
+			usageError(fs, "invalid -shard: %v", err)
+		}
+		hostShard = &s
+	}
+
+	// The servers of -resolvers-file join the -server list, weights and all
+	if *resolversFile != "" {
+		lines, err := readLinesFromFile(*resolversFile)
+		if err != nil {
+			fatal("Failed to read resolvers from file", err)
+		}
+		*server = appendServerLines(*server, lines)
+	}
+
+	var system *resolvConf
+	if *resolverFromSystem {
+		if *server != "" || *replayDir != "" {
+			configError("-resolver-from-system cannot be combined with -server or -replay")
+		}
+		conf, err := parseResolvConf(systemResolvConf)
+		if err != nil {
+			fatal("Failed to read system resolver configuration", err)
+		}
+		if len(conf.Nameservers) == 0 {
+			configError("-resolver-from-system: no nameserver found in %s", systemResolvConf)
+		}
+		system = &conf
+		slog.Info("Using system resolver configuration", "file", systemResolvConf, "servers", strings.Join(conf.Nameservers, ","), "search", strings.Join(conf.Search, ","), "ndots", conf.Ndots)
+	}
+
+	if *strictTLD && publicSuffixes.empty() {
+		slog.Warn("The embedded public suffix list is empty, so -strict-tld only checks host names")
+	}
+
+	// Canonicalization makes aliases of a host identical, so it also drops duplicates
+	canonicalOpts := canonicalOptions{
+		StripScheme:      *stripScheme,
+		Lowercase:        *lowercase,
+		StripTrailingDot: *stripTrailingDot,
+		StripWWW:         *stripWWW,
+	}
+	var canonical *hostCanonicalizer
+	if canonicalOpts.enabled() {
+		canonical = newHostCanonicalizer(canonicalOpts)
+	}
+
+	// prepare applies the input pipeline to subdomains read up front: brace expansion,
+	// canonicalization with canonical, search domains, -strict-tld, exclusions and the
+	// checkpoint. Stdin is streamed through the same steps further down.
+	prepare := func(subdomains []string, canonical *hostCanonicalizer) []string {
+		if *expand {
+			if subdomains, err = expandLines(subdomains); err != nil {
+				fatal("Failed to expand subdomains", err)
+			}
+		}
+
+		if canonical != nil {
+			subdomains = canonical.filter(subdomains)
+			slog.Info("Dropped duplicate subdomains after canonicalization", "dropped", canonical.dropped)
+		}
+		if system != nil {
+			subdomains = system.expandAll(subdomains)
+		}
+		if *strictTLD {
+			var invalid int
+			subdomains, invalid = filterUnknownTLDs(subdomains)
+			slog.Info("Skipped invalid subdomains", "skipped", invalid)
+		}
+
+		// Drop excluded subdomains before any of them are queried
+		if *excludeFile != "" {
+			var excluded int
+			subdomains, excluded = filterExcluded(subdomains, exclude, *verbose)
+			slog.Info("Excluded subdomains", "excluded", excluded)
+		}
+		if hostShard != nil {
+			var others int
+			subdomains, others = filterShard(subdomains, *hostShard)
+			slog.Info("Left subdomains to other shards", "shard", *shardFlag, "skipped", others)
+		}
+		if resume != nil && resume.completed() > 0 {
+			var skipped int
+			subdomains, skipped = resume.filter(subdomains)
+			slog.Info("Skipped subdomains completed before", "skipped", skipped)
+		}
+		return subdomains
+	}
+
+	// Read subdomains from the file up front, after any -target names; stdin is streamed
+	// as the scan runs. With -parallel-files each file goes through the input pipeline on
+	// its own, so a host listed by several files is kept in each of them.
+	subdomains := append([]string(nil), targets...)
+	var parallelHosts [][]string
+	var inputRecords map[string]Record
+	tags := make(hostTags)
+	switch {
+	case *inputJSON != "":
+		// The hosts went through the input pipeline in the scan that stored them
+		if subdomains, inputRecords, err = loadInputRecords(*inputJSON); err != nil {
+			fatal("Failed to read -input-json records", err)
+		}
+		if hostShard != nil {
+			subdomains, _ = filterShard(subdomains, *hostShard)
+		}
+		// Their tags carry over, even to hosts whose stored lookup failed
+		for host, record := range inputRecords {
+			if len(record.Tags) > 0 {
+				tags[normalizeHost(host)] = record.Tags
+			}
+		}
+		slog.Info("Loaded stored records", "records", len(subdomains), "source", *inputJSON)
+	case *parallelFiles != "":
+		for _, input := range fs.Args() {
+			lines, fileTags, err := readSubdomainsFile(input)
+			if err != nil {
+				fatal("Failed to read subdomains from file", err)
+			}
+			for host, entry := range fileTags {
+				tags[host] = entry
+			}
+			var fileCanonical *hostCanonicalizer
+			if canonical != nil {
+				fileCanonical = newHostCanonicalizer(canonicalOpts)
+			}
+			parallelHosts = append(parallelHosts, prepare(lines, fileCanonical))
+		}
+		subdomains = unionHosts(parallelHosts)
+	case subdomainsFile != stdioName || *dryRun || *benchmark > 0:
+		switch {
+		case subdomainsFile == stdioName:
+			// A dry run and a benchmark read stdin up front too, since they scan nothing
+			lines, err := readLines(os.Stdin, "stdin")
+			if err != nil {
+				fatal("Failed to read subdomains from stdin", err)
+			}
+			subdomains = append(subdomains, lines...)
+		case subdomainsFile != "":
+			lines, fileTags, err := readSubdomainsFile(subdomainsFile)
+			if err != nil {
+				fatal("Failed to read subdomains from file", err)
+			}
+			tags = fileTags
+			subdomains = append(subdomains, lines...)
+		}
+		subdomains = prepare(subdomains, canonical)
+	}
+	if *dryRun {
+		if err := writeDryRun(os.Stdout, subdomains, dryRunSample); err != nil {
+			fatal("Failed to write dry run", err)
+		}
+		return
+	}
+
+	patterns, source, err := selectPatterns(patternsFile, *fingerprintsCache, *withDefaults)
 	if err != nil {
-		log.Fatalf("Failed to read patterns from file: %v", err)
+		fatal("Failed to load patterns", err)
+	}
+	slog.Info("Loaded patterns", "patterns", len(patterns), "source", source)
+
+	// One seed drives every random choice of the run
+	rng := newRunRand(*seed)
+	if *verbose {
+		slog.Info("Random seed; pass it to -seed to repeat this run", "seed", rng.Seed())
 	}
 
-	// Open the result file for writing
-	file, err := os.Create(resultFile)
+	var confirmStage *confirmer
+	if *confirm && !*countOnly {
+		if *confirmSample <= 0 || *confirmSample > 1 {
+			usageError(fs, "-confirm-sample must be greater than 0 and at most 1")
+		}
+		if !strings.HasPrefix(*probePath, "/") {
+			usageError(fs, "-probe-path must start with /")
+		}
+		confirmStage = newConfirmer(*confirmTimeout, *confirmMaxRedirects)
+		confirmStage.probePath = *probePath
+		if *insecureConfirm {
+			confirmStage.skipTLSVerify()
+		}
+		sampleSeed := *confirmSeed
+		if sampleSeed == 0 {
+			sampleSeed = rng.derive("confirm-sample")
+		}
+		confirmStage.sample(*confirmSample, sampleSeed)
+	}
+
+	if *timeoutJitter < 0 {
+		configError("Invalid -timeout-jitter: %v is negative", *timeoutJitter)
+	}
+	retryBackoff := newBackoff(*timeoutJitter, maxRetryBackoff, rng.derive("retry-backoff"))
+
+	servers, weights, err := splitServers(*server)
 	if err != nil {
-		log.Fatalf("Failed to create result file: %v", err)
+		configError("Invalid -server or -resolvers-file: %v", err)
+	}
+	if system != nil {
+		servers = system.Nameservers
+	}
+	if *dotServer != "" {
+		if *server != "" || system != nil {
+			configError("-dot cannot be combined with -server or -resolver-from-system; give tls:// servers to -server instead")
+		}
+		servers = []string{dotScheme + *dotServer}
+	}
+	dotPins, err := parseDoTPins(*dotPin)
+	if err != nil {
+		configError("Invalid -dot-pin: %v", err)
+	}
+	if dotPins != nil && *dotServer == "" && !strings.Contains(*server+","+*compareTwo, dotScheme) {
+		configError("-dot-pin needs a DNS-over-TLS server from -dot or a tls:// -server")
+	}
+	firstServer := ""
+	if len(servers) > 0 {
+		firstServer = servers[0]
+	}
+	var trace *explainer
+	switch {
+	case *explainFile != "":
+		traceFile, err := os.Create(*explainFile)
+		if err != nil {
+			fatal("Failed to create explain file", err)
+		}
+		defer traceFile.Close()
+		trace = newExplainer(traceFile)
+	case *explain:
+		trace = newExplainer(os.Stderr)
 	}
-	defer file.Close()
 
-	// Check CNAME records for the subdomains with the given patterns and write results to the file
-	_, err = checkCNAMERecords(subdomains, patterns, file)
+	var overrides *hostOverrides
+	if *hostsFile != "" {
+		if overrides, err = loadHostOverrides(*hostsFile); err != nil {
+			fatal("Failed to load hosts file", err)
+		}
+	}
+
+	var ipPatterns []ipPattern
+	if *ipPatternsFile != "" {
+		if ipPatterns, err = loadIPPatterns(*ipPatternsFile); err != nil {
+			fatal("Failed to load IP patterns", err)
+		}
+		slog.Info("Loaded IP patterns", "patterns", len(ipPatterns), "source", *ipPatternsFile)
+	}
+
+	var asnLookup *asnDB
+	if *asnDBFile != "" {
+		if asnLookup, err = openASNDB(*asnDBFile); err != nil {
+			fatal("Failed to load ASN database", err)
+		}
+	}
+
+	opts := Options{
+		MinSeverity:    SeverityNone,
+		MinConfidence:  *minConfidence,
+		All:            *all,
+		SkipResolvable: *skipResolvable,
+		RequireCNAME:   *requireCNAME,
+		MinCNAMELength: *minCNAMELength,
+		MaxCNAMELength: *maxCNAMELength,
+		TTLWarn:        ttlWarn,
+		Backend:        backendAuto,
+		Server:         firstServer,
+		DoHConcurrency: *dohConcurrency,
+		DoTPins:        dotPins,
+		DigPath:        *digPath,
+		DigArgs:        strings.Fields(*digArgs),
+		LowercaseCNAME: !*preserveCNAMECase,
+		NoRecursion:    *noRecursion,
+		TraceDNS:       *traceDNS,
+		Verbose:        *verbose,
+		ShowAnswerHash: *showAnswerHash,
+		Collapse:       *collapse,
+		AllMatches:     *allMatches,
+		MatchLiteral:   *matchLiteral,
+		CrossCheck:     *crossCheck,
+		Confirmer:      confirmStage,
+		ASNDB:          asnLookup,
+		IPPatterns:     ipPatterns,
+		Explain:        trace,
+		FollowChain:    *followChainFlag,
+		InputRecords:   inputRecords,
+		HostTags:       tags,
+
+		Timeout:      *timeout,
+		Jitter:       *jitterMax,
+		JitterSeed:   *jitterSeed,
+		Rand:         rng,
+		RetryBackoff: retryBackoff,
+		Checkpoint:   resume,
+
+		MaxErrors:            *maxErrors,
+		MaxConsecutiveErrors: *maxConsecutiveErrors,
+		FindingsLimit:        *findingsLimit,
+		AbortAtFindingsLimit: *findingsLimitAbort,
+		Concurrency:          *concurrency,
+	}
+	if system != nil {
+		explicit := false
+		fs.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "timeout" })
+		if !explicit {
+			opts.Timeout = system.Timeout * time.Duration(system.Attempts)
+		}
+	}
+	if *rate < 0 {
+		configError("Invalid -rate: %g is negative", *rate)
+	}
+	if *findingsLimit < 0 {
+		configError("Invalid -findings-limit: %d is negative", *findingsLimit)
+	}
+	if *findingsLimitAbort && *findingsLimit == 0 {
+		configError("-findings-limit-abort needs -findings-limit")
+	}
+	if *flushEvery < 0 || *flushInterval < 0 {
+		configError("Invalid -flush-every or -flush-interval: negative value")
+	}
+	if *benchmark < 0 {
+		configError("Invalid -benchmark: %v is negative", *benchmark)
+	}
+	if *rate > 0 || *autoRate {
+		opts.RateLimiter = newRateLimiter(*rate, *autoRate)
+	}
+	if *minConfidence < 0 || *minConfidence > 100 {
+		configError("Invalid -min-confidence: %d is not between 0 and 100", *minConfidence)
+	}
+	if *minSeverityName != "" {
+		opts.MinSeverity, err = parseSeverity(*minSeverityName)
+		if err != nil {
+			configError("Invalid -min-severity: %v", err)
+		}
+	}
+
+	if *appendOutput && *format == formatJSON {
+		configError("-append is not supported with -format json; use jsonl instead")
+	}
+	if *appendOutput && *format == formatHTML {
+		configError("-append is not supported with -format html, which writes a whole report")
+	}
+	if *watchInterval > 0 && (subdomainsFile == stdioName || *sqliteFile != "" || *graphFile != "") {
+		configError("-watch needs a subdomains file and does not support -sqlite or -graph")
+	}
+	if *compareTwo != "" && (subdomainsFile == stdioName || *watchInterval > 0 || *onlyChanged || *checkpointFile != "" || *sqliteFile != "" || *graphFile != "" || *replayDir != "") {
+		configError("-compare-two needs a subdomains file and does not support -watch, -only-changed, -checkpoint, -sqlite, -graph or -replay")
+	}
+	var alertSeverity Severity
+	if *compareBaselineSeverity != "" {
+		if !*onlyChanged {
+			configError("-compare-baseline-severity needs -only-changed")
+		}
+		if alertSeverity, err = parseSeverity(*compareBaselineSeverity); err != nil {
+			configError("Invalid -compare-baseline-severity: %v", err)
+		}
+	}
+	if *requireCNAME && (*onlyChanged || *compareTwo != "") {
+		configError("-require-cname is not supported with -only-changed or -compare-two, which need every host")
+	}
+	if rotate.enabled() && (*watchInterval <= 0 || resultFile == stdioName) {
+		configError("-rotate needs -watch and a result file")
+	}
+	if *appendOutput && *outputEncrypt {
+		configError("-append is not supported with -output-encrypt")
+	}
+	if *collapse && (!collapseFormats[*format] || *fieldList != "" || *appendOutput || *checkpointFile != "") {
+		configError("-collapse needs -format text, json or jsonl and does not support -fields, -append or -checkpoint")
+	}
+	fields, err := parseFields(*fieldList)
 	if err != nil {
-		log.Fatalf("Failed to check CNAME records: %v", err)
+		configError("Invalid -fields: %v", err)
+	}
+
+	switch {
+	case *useDig && *useNative:
+		configError("Only one of -use-dig and -use-native may be given")
+	case *replayDir != "" && (*useDig || *useNative):
+		configError("-replay cannot be combined with -use-dig or -use-native")
+	case *replayDir != "":
+		opts.Backend = backendReplay
+		opts.ReplayDir = *replayDir
+	case *useDig:
+		opts.Backend = backendDig
+	case *useNative:
+		opts.Backend = backendNative
+	}
+
+	resolver, backend, pool, err := selectServerResolver(opts, servers, weights, *resolverCooldown, rng)
+	if err != nil {
+		fatal("Failed to set up resolver", err)
+	}
+	slog.Info("Using resolver backend", "backend", backend)
+	if *traceDNS && backend != backendNative {
+		slog.Warn("DNS messages are only traced with the native backend", "backend", backend)
+	}
+	if *verbose && pool != nil && weights != nil {
+		pool.logShares()
+	}
+	if *benchmark > 0 {
+		// The resolver is measured bare: neither the cache nor -hosts-file overrides
+		// answer, or the repeated lookups would measure only them
+		if len(subdomains) == 0 {
+			configError("-benchmark needs hosts to look up")
+		}
+		sample := sampleHosts(subdomains, benchmarkSample)
+		slog.Info("Benchmarking resolver", "hosts", len(sample), "duration", *benchmark, "concurrency", opts.Concurrency)
+		// An interrupt ends the run early, still reporting what was measured
+		benchCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		result := runBenchmark(benchCtx, resolver, sample, *benchmark, opts)
+		stop()
+		if err := result.write(os.Stdout); err != nil {
+			fatal("Failed to write benchmark", err)
+		}
+		if pool != nil {
+			if err := pool.writeHealth(os.Stdout); err != nil {
+				fatal("Failed to write benchmark", err)
+			}
+		}
+		return
+	}
+	if *header {
+		if *format != formatText {
+			configError("-header is only supported with -format text")
+		}
+		hosts := len(subdomains)
+		if subdomainsFile == stdioName {
+			hosts = -1
+		}
+		opts.Header = scanHeader(time.Now(), backend, servers, len(patterns), hosts)
+	}
+	// All DNS access of the scan goes through one cache, so hosts, chain hops and
+	// cross-check zone discovery sharing names query them once
+	cache := newCachingResolver(resolver)
+	cache.overrides = overrides
+	resolver = cache
+	if opts.CrossCheck {
+		resolver, err = newCrossCheckResolver(cache, opts.Server, cache, opts)
+		if err != nil {
+			fatal("Failed to set up -cross-check", err)
+		}
+	}
+	if *checkDelegation {
+		zones, err := newZoneFinder(opts.Server, cache, opts)
+		if err != nil {
+			fatal("Failed to set up -check-delegation", err)
+		}
+		opts.Delegation = &delegationChecker{zones: zones, patterns: patterns}
+	}
+
+	// -compare-two scans a second time through its own resolvers and cache
+	var candidate Resolver
+	var candidateCache *cachingResolver
+	var candidatePool *resolverPool
+	if *compareTwo != "" {
+		candidateServers, candidateWeights, err := splitServers(*compareTwo)
+		if err != nil {
+			configError("Invalid -compare-two: %v", err)
+		}
+		if len(candidateServers) == 0 {
+			configError("-compare-two needs at least one server")
+		}
+		candidate, backend, candidatePool, err = selectServerResolver(opts, candidateServers, candidateWeights, *resolverCooldown, rng)
+		if err != nil {
+			fatal("Failed to set up -compare-two resolver", err)
+		}
+		slog.Info("Comparing with resolver backend", "backend", backend, "servers", strings.Join(candidateServers, ","))
+		if *verbose && candidatePool != nil && candidateWeights != nil {
+			candidatePool.logShares()
+		}
+		candidateCache = newCachingResolver(candidate)
+		candidateCache.overrides = overrides
+		candidate = candidateCache
+		if opts.CrossCheck {
+			candidate, err = newCrossCheckResolver(candidateCache, candidateServers[0], candidateCache, opts)
+			if err != nil {
+				fatal("Failed to set up -cross-check", err)
+			}
+		}
+	}
+
+	var key encryptionKey
+	if *outputEncrypt {
+		if key, err = loadEncryptionKey(*encryptPassphrase, *encryptKeyFile); err != nil {
+			fatal("Failed to set up encryption", err)
+		}
+	}
+	// openOutput stacks the output format, compression and encryption on a result file.
+	// Records are compressed before they are encrypted, since ciphertext does not compress.
+	// Every result file's writer joins outputs, which the checkpoint flushes before it
+	// records hosts as done.
+	outputs := &flushGroup{}
+	if resume != nil {
+		resume.output = outputs
+	}
+	openOutput := func(w io.Writer, continuing bool) (recordWriter, error) {
+		var streams []io.Closer
+		var flushers []flusher
+		if *outputEncrypt {
+			encrypted, err := newEncryptWriter(w, key)
+			if err != nil {
+				return nil, fmt.Errorf("error setting up encryption: %v", err)
+			}
+			w = encrypted
+			streams = append(streams, encrypted)
+			flushers = append(flushers, encrypted)
+		}
+		if *gzipOutput {
+			compressed := gzip.NewWriter(w)
+			w = compressed
+			streams = append(streams, compressed)
+			flushers = append(flushers, compressed)
+		}
+		buffered := bufferedStream{bufio.NewWriter(w)}
+		streams = append(streams, buffered)
+		flushers = append(flushers, buffered)
+		output, err := newRecordWriter(*format, buffered, fields, opts, continuing)
+		if err != nil {
+			return nil, err
+		}
+		// The stream nearest the records is closed and flushed first: the buffer, then
+		// gzip, then encryption
+		for i := len(streams) - 1; i >= 0; i-- {
+			output = &closingWriter{next: output, stream: streams[i]}
+		}
+		for i, j := 0, len(flushers)-1; i < j; i, j = i+1, j-1 {
+			flushers[i], flushers[j] = flushers[j], flushers[i]
+		}
+		flushing := newFlushingWriter(output, flushers, *flushEvery, *flushInterval)
+		outputs.add(flushing)
+		return flushing, nil
+	}
+
+	// checkAppend refuses to append csv rows to a result file whose header names other
+	// columns, which would misalign every appended row.
+	checkAppend := func(name string, continuing bool) {
+		if !continuing || *format != formatCSV {
+			return
+		}
+		if err := checkCSVHeader(name, *gzipOutput, csvHeader(fields, opts.HostTags.keys())); err != nil {
+			configError("Cannot -append: %v; write to a new result file instead", err)
+		}
+	}
+
+	var output recordWriter
+	var file *os.File
+	var router *fileRouter
+	var counts *outcomeCounter
+	switch {
+	case *countOnly:
+		counts = &outcomeCounter{}
+		output = counts
+		// Every record is counted, and none has to be kept
+		opts.All = true
+		opts.DiscardResults = true
+	case *syslogOutput:
+		if output, err = newSyslogWriter(*syslogAddr, *syslogFacility, *syslogTag, fields); err != nil {
+			fatal("Failed to connect to syslog", err)
+		}
+	case *parallelFiles != "":
+		names := make([]string, fs.NArg())
+		inputs := make(map[string]string)
+		for i, input := range fs.Args() {
+			names[i] = parallelOutputName(*parallelFiles, input, *format, *gzipOutput)
+			if other, ok := inputs[names[i]]; ok {
+				configError("-parallel-files: %s and %s would both write %s", other, input, names[i])
+			}
+			inputs[names[i]] = input
+		}
+		if err := os.MkdirAll(*parallelFiles, 0o777); err != nil {
+			fatal("Failed to create result directory", err)
+		}
+		router = newFileRouter()
+		for i, name := range names {
+			result, continuing, err := openResultFile(name, *appendOutput)
+			if err != nil {
+				fatal("Failed to create result file", err)
+			}
+			checkAppend(name, continuing)
+			resultOutput, err := openOutput(result, continuing)
+			if err != nil {
+				fatal("Failed to set up output", err)
+			}
+			router.add(name, &closingWriter{next: resultOutput, stream: result}, parallelHosts[i])
+		}
+		output = router
+	default:
+		// Open the result file for writing, or for appending to earlier results
+		var continuing bool
+		file, continuing, err = openResultFile(resultFile, *appendOutput)
+		if err != nil {
+			fatal("Failed to create result file", err)
+		}
+		defer file.Close()
+		checkAppend(resultFile, continuing)
+
+		if rotate.enabled() {
+			output, err = newRotatingWriter(resultFile, file, continuing, rotate, openOutput)
+		} else {
+			output, err = openOutput(file, continuing)
+		}
+		if err != nil {
+			fatal("Failed to set up output", err)
+		}
+	}
+	var providers *providerCounter
+	if *listProviders {
+		providers = newProviderCounter(output)
+		output = providers
+	}
+	if *redact || *redactCNAME {
+		if *redactMap == "" {
+			configError("-redact needs -redact-map to record the mapping for un-redacting")
+		}
+		redactor, err := newRedactor(*redactMap)
+		if err != nil {
+			fatal("Failed to load redaction map", err)
+		}
+		output = &redactingWriter{next: output, redactor: redactor, cnames: *redactCNAME}
+	}
+	if *esURL != "" {
+		es, err := newESWriter(*esURL, *esIndex, *esUser, *esPassword, *esBatchSize)
+		if err != nil {
+			fatal("Failed to set up Elasticsearch output", err)
+		}
+		output = multiWriter{output, es}
+	}
+	if *onFinding != "" {
+		output = newFindingHook(output, *onFinding, *onFindingConcurrency)
+	}
+	var tracker *changeTracker
+	if *onlyChanged {
+		if tracker, err = newChangeTracker(*stateFile); err != nil {
+			fatal("Failed to load watch state", err)
+		}
+		output = &changeFilter{next: output, tracker: tracker, alertSeverity: alertSeverity}
+		// Every host has to reach the tracker, or a host turning clean would go unnoticed
+		opts.All = true
+	}
+
+	// Check CNAME records for the subdomains with the given patterns and write results to the file
+	scanStarted := time.Now()
+
+	// An aborted scan still returns the records found so far, which are written out before exiting
+	scanCtx := context.Background()
+	if *watchInterval <= 0 {
+		// Output is buffered until the next flush, and a compressed or encrypted file cut
+		// off by an interrupt cannot be read back, so an interrupt stops the scan and the
+		// output is closed as usual
+		var stop context.CancelFunc
+		scanCtx, stop = signal.NotifyContext(scanCtx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+	}
+	if *maxRuntime > 0 {
+		var cancel context.CancelFunc
+		scanCtx, cancel = context.WithTimeout(scanCtx, *maxRuntime)
+		defer cancel()
+	}
+	if *watchInterval > 0 {
+		watchScan(scanCtx, subdomains, patterns, resolver, cache, opts, output, tracker, *watchInterval)
+		logSettledRate(opts.RateLimiter)
+		if err := output.Close(); err != nil {
+			fatal("Failed to write results", err)
+		}
+		if providers != nil {
+			if err := providers.writeSummary(os.Stderr); err != nil {
+				fatal("Failed to write provider summary", err)
+			}
+		}
+		if pool != nil {
+			if err := pool.writeHealth(os.Stderr); err != nil {
+				fatal("Failed to write resolver health", err)
+			}
+		}
+		return
+	}
+
+	if candidate != nil {
+		diffs, summary, scanErr := compareScan(scanCtx, subdomains, patterns, resolver, candidate, opts, output)
+		if scanErr != nil {
+			slog.Warn("Comparison aborted early", "error", scanErr)
+		}
+		slog.Info("Compared resolver sets", "differing", diffs, "subdomains", len(subdomains))
+		logSettledRate(opts.RateLimiter)
+		if *verbose || opts.FollowChain || opts.CrossCheck {
+			logCacheStats(cache)
+			logCacheStats(candidateCache)
+		}
+		if err := output.Close(); err != nil {
+			fatal("Failed to write results", err)
+		}
+		for _, p := range []*resolverPool{pool, candidatePool} {
+			if p != nil {
+				if err := p.writeHealth(os.Stderr); err != nil {
+					fatal("Failed to write resolver health", err)
+				}
+			}
+		}
+		if code := summary.exitCode(); code != exitClean {
+			file.Close()
+			stopProfiles()
+			os.Exit(int(code))
+		}
+		return
+	}
+
+	var input <-chan string
+	var readErr func() error
+	streamedExcluded, streamedSkipped, streamedInvalid, streamedOthers := 0, 0, 0, 0
+	if subdomainsFile == stdioName {
+		input, readErr = streamLines(scanCtx, os.Stdin, "stdin", func(line string) []string {
+			names := []string{line}
+			if *expand {
+				var err error
+				if names, err = expandBraces(line); err != nil {
+					slog.Warn("Skipping line", "error", err)
+					return nil
+				}
+			}
+			if canonical != nil {
+				names = canonical.filter(names)
+			}
+			if system != nil {
+				names = system.expandAll(names)
+			}
+			kept := names[:0]
+			for _, subdomain := range names {
+				if *strictTLD {
+					if err := checkScanHost(subdomain); err != nil {
+						streamedInvalid++
+						slog.Warn("Skipping invalid host", "host", subdomain, "reason", err)
+						continue
+					}
+				}
+				if hostShard != nil && !hostShard.owns(subdomain) {
+					streamedOthers++
+					continue
+				}
+				if resume != nil && resume.skip(subdomain) {
+					streamedSkipped++
+					continue
+				}
+				if !exclude.excludes(subdomain) {
+					kept = append(kept, subdomain)
+					continue
+				}
+				streamedExcluded++
+				if *verbose {
+					slog.Info("Excluding host", "host", subdomain)
+				}
+			}
+			return kept
+		})
+		// Records are only kept in memory when the SQLite or graph output needs them all
+		opts.DiscardResults = *sqliteFile == "" && *graphFile == ""
+		if len(subdomains) > 0 {
+			input = concatLines(scanCtx, sendLines(scanCtx, subdomains), input)
+		}
+	} else {
+		input = sendLines(scanCtx, subdomains)
+	}
+
+	total := len(subdomains)
+	if subdomainsFile == stdioName {
+		total = 0 // unknown until stdin ends
+	}
+	results, summary, scanErr := checkCNAMERecords(scanCtx, input, total, patterns, resolver, opts, output)
+	if scanErr == nil && readErr != nil {
+		if scanErr = readErr(); scanErr != nil {
+			summary.Stopped = true
+		}
+		if *excludeFile != "" {
+			slog.Info("Excluded subdomains", "excluded", streamedExcluded)
+		}
+		if canonical != nil {
+			slog.Info("Dropped duplicate subdomains after canonicalization", "dropped", canonical.dropped)
+		}
+		if *strictTLD {
+			slog.Info("Skipped invalid subdomains", "skipped", streamedInvalid)
+		}
+		if hostShard != nil {
+			slog.Info("Left subdomains to other shards", "shard", *shardFlag, "skipped", streamedOthers)
+		}
+		if resume != nil && resume.completed() > 0 {
+			slog.Info("Skipped subdomains completed before", "skipped", streamedSkipped)
+		}
+	}
+	if *verbose || opts.FollowChain || opts.CrossCheck {
+		logCacheStats(cache)
+	}
+	logSettledRate(opts.RateLimiter)
+	if confirmStage != nil {
+		checked, confirmed, sampledOut := confirmStage.stats()
+		if checked > 0 {
+			slog.Info("Confirmed vulnerable records", "confirmed", confirmed, "checked", checked, "percent", math.Round(1000*float64(confirmed)/float64(checked))/10)
+		}
+		if sampledOut > 0 {
+			slog.Info("Left vulnerable records unconfirmed by -confirm-sample", "unconfirmed", sampledOut)
+		}
+	}
+	if scanErr != nil {
+		slog.Warn("Scan aborted early", "error", scanErr, "duration", time.Since(scanStarted))
+	}
+	if tracker != nil {
+		if err := tracker.save(); err != nil {
+			fatal("Failed to save watch state", err)
+		}
+	}
+	if err := output.Close(); err != nil {
+		fatal("Failed to write results", err)
+	}
+	if router != nil {
+		router.logFiles()
+	}
+	if counts != nil {
+		if err := counts.writeCounts(os.Stdout); err != nil {
+			fatal("Failed to write counts", err)
+		}
+	}
+	if resume != nil {
+		if err := resume.Close(); err != nil {
+			fatal("Failed to write checkpoint", err)
+		}
+	}
+	if providers != nil {
+		if err := providers.writeSummary(os.Stderr); err != nil {
+			fatal("Failed to write provider summary", err)
+		}
+	}
+	if pool != nil {
+		if err := pool.writeHealth(os.Stderr); err != nil {
+			fatal("Failed to write resolver health", err)
+		}
+	}
+
+	// Persist results to SQLite if requested
+	if *sqliteFile != "" {
+		if err := writeSQLite(*sqliteFile, results, scanStarted); err != nil {
+			fatal("Failed to write SQLite results", err)
+		}
+	}
+
+	// Write the CNAME graph if requested
+	if *graphFile != "" {
+		if err := writeGraphFile(results, *graphFile); err != nil {
+			fatal("Failed to write CNAME graph", err)
+		}
+	}
+
+	if *summaryJSON {
+		if err := writeSummaryJSON(os.Stdout, summary, time.Since(scanStarted)); err != nil {
+			fatal("Failed to write summary", err)
+		}
+	}
+
+	// Findings, failed lookups and aborted scans each have their own exit code
+	if code := summary.exitCode(); code != exitClean {
+		file.Close()
+		stopProfiles()
+		os.Exit(int(code))
 	}
 }
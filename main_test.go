@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLogs sends slog output to a buffer for the rest of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &logs
+}
+
+func TestReadLinesSkipsLongLines(t *testing.T) {
+	logs := captureLogs(t)
+	long := strings.Repeat("a", maxLineLength+1024)
+	input := "first.example.com\n" + long + "\nsecond.example.com\n  third.example.com  \n"
+
+	lines, err := readLines(strings.NewReader(input), "hosts.txt")
+	if err != nil {
+		t.Fatalf("readLines: %v", err)
+	}
+	want := []string{"first.example.com", "second.example.com", "third.example.com"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+	if got := logs.String(); !strings.Contains(got, "Skipping line longer than the limit") || !strings.Contains(got, "line=2") {
+		t.Errorf("no warning for line 2 logged, got %q", got)
+	}
+}
+
+func TestReadLinesLongerThanDefaultBuffer(t *testing.T) {
+	// Lines past bufio's default 64 KiB token limit but within maxLineLength are kept
+	long := strings.Repeat("b", 1024*1024)
+	lines, err := readLines(strings.NewReader(long+"\nnext.example.com"), "hosts.txt")
+	if err != nil {
+		t.Fatalf("readLines: %v", err)
+	}
+	if len(lines) != 2 || lines[0] != long || lines[1] != "next.example.com" {
+		t.Errorf("got %d lines, want the 1 MiB line and next.example.com", len(lines))
+	}
+}
+
+func TestReadLinesLongLastLine(t *testing.T) {
+	logs := captureLogs(t)
+	input := "first.example.com\n" + strings.Repeat("c", maxLineLength*2)
+
+	lines, err := readLines(strings.NewReader(input), "hosts.txt")
+	if err != nil {
+		t.Fatalf("readLines: %v", err)
+	}
+	if want := []string{"first.example.com"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+	if !strings.Contains(logs.String(), "Skipping line longer than the limit") {
+		t.Errorf("no warning logged, got %q", logs.String())
+	}
+}
+
+// benchmarkPatterns returns n plain patterns of severity medium, the last of them
+// herokuapp.com and the others providerN.example.net.
+func benchmarkPatterns(n int) []Pattern {
+	patterns := make([]Pattern, n)
+	for i := range patterns {
+		patterns[i] = Pattern{Value: fmt.Sprintf("provider%d.example.net", i), Severity: SeverityMedium}
+	}
+	patterns[n-1].Value = "herokuapp.com"
+	return patterns
+}
+
+func BenchmarkMatchCandidates(b *testing.B) {
+	patterns := benchmarkPatterns(1000)
+	// A target matching the first pattern stops the fast path at once, and one matching
+	// only the last makes it try every pattern, as -all-matches always does
+	targets := []struct{ name, target string }{
+		{"early", "bucket.provider0.example.net"},
+		{"late", "app.herokuapp.com"},
+	}
+	for _, tt := range targets {
+		target := tt.target
+		b.Run("first-match/"+tt.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				matchesAnyPattern(matchCandidates(target, false), patterns)
+			}
+		})
+		b.Run("all-matches/"+tt.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				matchAllPatterns(matchCandidates(target, false), patterns)
+			}
+		})
+	}
+}
+
+// fakeResolver answers lookups from maps. Names in slow never answer: their lookups
+// block until the context ends and fail with its error.
+type fakeResolver struct {
+	cnames map[string]Answer
+	addrs  map[string]Answer
+	slow   map[string]bool
+}
+
+func (r *fakeResolver) lookup(ctx context.Context, name string, answers map[string]Answer) (Answer, error) {
+	if r.slow[name] {
+		<-ctx.Done()
+		return Answer{}, ctx.Err()
+	}
+	return answers[name], nil
+}
+
+func (r *fakeResolver) LookupCNAME(ctx context.Context, name string) (Answer, error) {
+	return r.lookup(ctx, name, r.cnames)
+}
+
+func (r *fakeResolver) LookupPTR(ctx context.Context, ip string) (Answer, error) {
+	return r.lookup(ctx, ip, nil)
+}
+
+func (r *fakeResolver) LookupAddrs(ctx context.Context, name string) (Answer, error) {
+	return r.lookup(ctx, name, r.addrs)
+}
+
+// scanHosts runs checkCNAMERecords over hosts with every record kept.
+func scanHosts(ctx context.Context, hosts []string, patterns []Pattern, resolver Resolver, opts Options) (map[string]Record, Summary, error) {
+	opts.All = true
+	return checkCNAMERecords(ctx, sendLines(ctx, hosts), len(hosts), patterns, resolver, opts, discardRecords{})
+}
+
+func TestSlowHostTripsOnlyItsOwnTimeout(t *testing.T) {
+	resolver := &fakeResolver{
+		cnames: map[string]Answer{"a.example.com": {CNAMEs: []string{"app.herokuapp.com"}}},
+		slow:   map[string]bool{"slow.example.com": true},
+	}
+	hosts := []string{"slow.example.com", "a.example.com", "b.example.com"}
+	patterns := []Pattern{{Value: "herokuapp.com", Severity: SeverityHigh}}
+	opts := Options{Concurrency: 2, Timeout: 50 * time.Millisecond, MinCNAMELength: 4}
+
+	results, summary, err := scanHosts(context.Background(), hosts, patterns, resolver, opts)
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	if summary.Scanned != 3 || summary.Failed != 1 || summary.Stopped {
+		t.Errorf("summary = %+v, want 3 scanned, 1 failed, not stopped", summary)
+	}
+	if slow := results["slow.example.com"]; !strings.Contains(slow.Error, context.DeadlineExceeded.Error()) {
+		t.Errorf("slow host error = %q, want its own deadline exceeded", slow.Error)
+	}
+	if a := results["a.example.com"]; a.Error != "" || !a.IsVulnerable {
+		t.Errorf("a.example.com = %+v, want vulnerable without error", a)
+	}
+	if b := results["b.example.com"]; b.Error != "" {
+		t.Errorf("b.example.com error = %q, want none", b.Error)
+	}
+}
+
+func TestScanDeadlineCancelsOutstandingLookups(t *testing.T) {
+	resolver := &fakeResolver{slow: map[string]bool{"a.example.com": true, "b.example.com": true}}
+	// The per-lookup timeout is far longer than the scan's, which has to win
+	opts := Options{Concurrency: 2, Timeout: time.Minute, MinCNAMELength: 4}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	_, summary, err := scanHosts(ctx, []string{"a.example.com", "b.example.com"}, nil, resolver, opts)
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("scan took %v after its deadline", elapsed)
+	}
+	if err == nil || !strings.Contains(err.Error(), "maximum runtime exceeded") {
+		t.Errorf("err = %v, want maximum runtime exceeded", err)
+	}
+	// Lookups cut off by the scan's end were never really checked
+	if !summary.Stopped || summary.Scanned != 0 || summary.Failed != 0 {
+		t.Errorf("summary = %+v, want stopped with nothing scanned or failed", summary)
+	}
+}
+
+func TestMatchCandidates(t *testing.T) {
+	tests := []struct {
+		target  string
+		literal bool
+		want    []string
+	}{
+		{"App.HerokuApp.com", false, []string{"app.herokuapp.com"}},
+		{"*.cdn.example.net", false, []string{"cdn.example.net"}},
+		{"*.cdn.example.net", true, []string{"cdn.example.net", "*.cdn.example.net"}},
+		// Without a leading wildcard there is no literal form to add
+		{"cdn.example.net", true, []string{"cdn.example.net"}},
+		{"No CNAME record", true, nil},
+	}
+	for _, tt := range tests {
+		if got := matchCandidates(tt.target, tt.literal); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("matchCandidates(%q, %v) = %q, want %q", tt.target, tt.literal, got, tt.want)
+		}
+	}
+}
+
+func TestMatchLiteralWildcard(t *testing.T) {
+	wildcard := Pattern{Value: "*.cdn.example.net", Severity: SeverityHigh}
+	plain := Pattern{Value: "cdn.example.net", Severity: SeverityLow}
+	target := "*.cdn.example.net"
+
+	// A pattern written with the wildcard only matches the literal form
+	if _, ok := matchesAnyPattern(matchCandidates(target, false), []Pattern{wildcard}); ok {
+		t.Error("wildcard pattern matched the stripped target")
+	}
+	if got, ok := matchesAnyPattern(matchCandidates(target, true), []Pattern{wildcard}); !ok || got.Value != wildcard.Value {
+		t.Errorf("with literal matching got %q, %v; want the wildcard pattern", got.Value, ok)
+	}
+	// Pattern order decides over candidate order: the first, most severe pattern wins
+	// even though the stripped form is tried first for each pattern
+	if got, _ := matchesAnyPattern(matchCandidates(target, true), []Pattern{wildcard, plain}); got.Value != wildcard.Value {
+		t.Errorf("matched %q, want the earlier wildcard pattern", got.Value)
+	}
+	if got, _ := matchesAnyPattern(matchCandidates(target, true), []Pattern{plain, wildcard}); got.Value != plain.Value {
+		t.Errorf("matched %q, want the earlier plain pattern", got.Value)
+	}
+}
+
+func TestExtractWildcardDomain(t *testing.T) {
+	tests := []struct {
+		cname string
+		want  string
+	}{
+		{"example.com", "example.com"},
+		{"*.example.com", "example.com"},
+		{"*.*.example.com", "example.com"},
+		{"*.*.*.cdn.example.com", "cdn.example.com"},
+		{"*.cdn.example.com", "cdn.example.com"},
+		{"  *.example.com  ", "example.com"},
+		{"*", ""},
+		{"*.", ""},
+		{"*.*.", ""},
+		{"", ""},
+		{"No CNAME record", ""},
+		// Only leading labels are wildcards to strip
+		{"a.*.example.com", "a.*.example.com"},
+		{"*example.com", "*example.com"},
+		{"*.a.example.com\n*.*.b.example.net", "a.example.com\nb.example.net"},
+	}
+	for _, tt := range tests {
+		if got := extractWildcardDomain(tt.cname); got != tt.want {
+			t.Errorf("extractWildcardDomain(%q) = %q, want %q", tt.cname, got, tt.want)
+		}
+	}
+}
+
+func TestFollowChain(t *testing.T) {
+	resolver := &fakeResolver{cnames: map[string]Answer{
+		"shop.example.net.":  {CNAMEs: []string{"app.herokuapp.com."}, Records: []ResourceRecord{{Name: "shop.example.net.", Type: "CNAME", Value: "app.herokuapp.com."}}},
+		"app.herokuapp.com.": {Records: []ResourceRecord{{Name: "app.herokuapp.com.", Type: "A", Value: "192.0.2.7"}}},
+		// The DNAME answer for a hop under old.example.com, without a synthesized CNAME
+		"www.old.example.com.": {Records: []ResourceRecord{{Name: "old.example.com.", Type: "DNAME", Value: "new.example.net."}}},
+		"loop-a.example.com.":  {CNAMEs: []string{"loop-b.example.com."}},
+		"loop-b.example.com.":  {CNAMEs: []string{"loop-a.example.com."}},
+		"slow.example.com.":    {CNAMEs: []string{"never.example.com."}},
+	}, slow: map[string]bool{"never.example.com.": true}}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	tests := []struct {
+		first     string
+		known     []ResourceRecord
+		want      []string
+		wantDNAME bool
+	}{
+		// Hops in the first answer are taken from it, the rest looked up
+		{"www.example.com.", []ResourceRecord{{Name: "www.example.com.", Type: "CNAME", Value: "shop.example.net."}}, []string{"www.example.com.", "shop.example.net.", "app.herokuapp.com."}, false},
+		// The addresses at the end of a mixed answer are not hops
+		{"www.example.com", mixedChainRecords, []string{"www.example.com", "shop.example.net.", "edge.cdn.example.org."}, false},
+		{"www.old.example.com.", nil, []string{"www.old.example.com.", "www.new.example.net."}, true},
+		// A loop stops before it repeats a name
+		{"loop-a.example.com.", nil, []string{"loop-a.example.com.", "loop-b.example.com."}, false},
+		{"a.example.com.", loopRecords, []string{"a.example.com.", "b.example.com."}, false},
+		// A failed lookup ends the chain at the last name known
+		{"slow.example.com.", nil, []string{"slow.example.com.", "never.example.com."}, false},
+	}
+	for _, tt := range tests {
+		got, dname := followChain(ctx, tt.first, tt.known, resolver)
+		if !reflect.DeepEqual(got, tt.want) || dname != tt.wantDNAME {
+			t.Errorf("followChain(%q) = %q, %v; want %q, %v", tt.first, got, dname, tt.want, tt.wantDNAME)
+		}
+	}
+}
+
+func TestFollowChainLengthLimit(t *testing.T) {
+	var records []ResourceRecord
+	for i := 0; i < 2*maxChainLength; i++ {
+		records = append(records, ResourceRecord{Name: fmt.Sprintf("h%d.example.com.", i), Type: "CNAME", Value: fmt.Sprintf("h%d.example.com.", i+1)})
+	}
+	chain, _ := followChain(context.Background(), "h0.example.com.", records, &fakeResolver{})
+	if len(chain) != maxChainLength {
+		t.Errorf("chain has %d hops, want %d", len(chain), maxChainLength)
+	}
+}
+
+func TestCNAMECaseKeptSeparateFromMatching(t *testing.T) {
+	resolver := &fakeResolver{cnames: map[string]Answer{
+		"www.example.com":    {CNAMEs: []string{"Shop.Example.NET."}},
+		"Shop.Example.NET.":  {CNAMEs: []string{"App.HerokuApp.COM."}},
+		"App.HerokuApp.COM.": {},
+	}}
+	patterns := []Pattern{{Value: "HEROKUAPP.com", Severity: SeverityHigh}}
+	tests := []struct {
+		lowercase bool
+		cname     string
+		chain     []string
+	}{
+		{false, "Shop.Example.NET.", []string{"Shop.Example.NET.", "App.HerokuApp.COM."}},
+		{true, "shop.example.net.", []string{"shop.example.net.", "app.herokuapp.com."}},
+	}
+	for _, tt := range tests {
+		opts := Options{MinCNAMELength: 4, FollowChain: true, LowercaseCNAME: tt.lowercase}
+		record, err := lookupRecord(context.Background(), "www.example.com", patterns, resolver, opts)
+		if err != nil {
+			t.Fatalf("lookup failed: %v", err)
+		}
+		if record.CNAME != tt.cname || !reflect.DeepEqual(record.Chain, tt.chain) {
+			t.Errorf("LowercaseCNAME %v: CNAME %q, chain %q; want %q, %q", tt.lowercase, record.CNAME, record.Chain, tt.cname, tt.chain)
+		}
+		// The match on the second hop ignores case whatever is written out
+		if !record.IsVulnerable {
+			t.Errorf("LowercaseCNAME %v: not vulnerable, want a match on the chain", tt.lowercase)
+		}
+	}
+}
+
+func TestTextLineKeepsBaselineFormat(t *testing.T) {
+	record := Record{
+		Subdomain:      "shop.example.com",
+		CNAME:          "gone.herokuapp.com",
+		IsVulnerable:   true,
+		MatchedPattern: "herokuapp.com",
+		Severity:       SeverityHigh,
+		Confidence:     90,
+		Remediation:    "Remove the CNAME record",
+		RCode:          "NXDOMAIN",
+		Tags:           map[string]string{"team": "payments"},
+	}
+	var buf bytes.Buffer
+	if err := writeRecord(&buf, record, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Subdomain: shop.example.com, CNAME: gone.herokuapp.com, Vulnerable: Yes\n"; buf.String() != want {
+		t.Errorf("text line = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	record = Record{Subdomain: "www.example.com", CNAME: "No CNAME record", RCode: "SERVFAIL"}
+	if err := writeRecord(&buf, record, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Subdomain: www.example.com, CNAME: No CNAME record, Vulnerable: No\n"; buf.String() != want {
+		t.Errorf("text line = %q, want %q", buf.String(), want)
+	}
+}
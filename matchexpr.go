@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// matchFuncs are the functions of a match expression. Each takes the argument written
+// after its name and returns the test applied to one line of a lowercased CNAME target.
+var matchFuncs = map[string]func(arg string) (func(line string) bool, error){
+	"contains": func(arg string) (func(string) bool, error) {
+		arg = strings.ToLower(arg)
+		return func(line string) bool { return strings.Contains(line, arg) }, nil
+	},
+	"prefix": func(arg string) (func(string) bool, error) {
+		arg = strings.ToLower(arg)
+		return func(line string) bool { return strings.HasPrefix(line, arg) }, nil
+	},
+	"suffix": func(arg string) (func(string) bool, error) {
+		return func(line string) bool { return isSuffixMatch(line, arg) }, nil
+	},
+	"equals": func(arg string) (func(string) bool, error) {
+		arg = normalizeHost(arg)
+		return func(line string) bool { return normalizeHost(line) == arg }, nil
+	},
+	"regex": func(arg string) (func(string) bool, error) {
+		re, err := regexp.Compile("(?i)" + arg)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	},
+}
+
+// matchExpr is a compiled match expression, a pattern written as a boolean combination of
+// match functions instead of a plain substring, such as
+//
+//	contains:s3 && !contains:amazonaws-internal
+//	suffix:azurewebsites.net || (prefix:cdn- && regex:`\.example\.(net|org)$`)
+//
+// A function holds when any line of the target passes its test, so !contains:x means no
+// line contains x. && binds tighter than ||. An argument that holds spaces or any of
+// ()&|! is written as a Go string literal, double-quoted or, for regular expressions,
+// raw in backquotes.
+type matchExpr interface {
+	// eval reports whether the expression holds for lines, the lines of one match candidate.
+	eval(lines []string) bool
+}
+
+// notExpr negates an expression.
+type notExpr struct{ x matchExpr }
+
+func (e notExpr) eval(lines []string) bool { return !e.x.eval(lines) }
+
+// andExpr holds when both of its expressions hold.
+type andExpr struct{ x, y matchExpr }
+
+func (e andExpr) eval(lines []string) bool { return e.x.eval(lines) && e.y.eval(lines) }
+
+// orExpr holds when either of its expressions holds.
+type orExpr struct{ x, y matchExpr }
+
+func (e orExpr) eval(lines []string) bool { return e.x.eval(lines) || e.y.eval(lines) }
+
+// funcExpr is a match function applied to its argument.
+type funcExpr struct{ test func(line string) bool }
+
+func (e funcExpr) eval(lines []string) bool {
+	for _, line := range lines {
+		if e.test(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// isExpression reports whether a pattern value is written as a match expression rather
+// than a plain substring: it starts with "!", "(" or the name of a match function and a
+// colon. Host names and IP addresses never do.
+func isExpression(value string) bool {
+	if strings.HasPrefix(value, "!") || strings.HasPrefix(value, "(") {
+		return true
+	}
+	name, _, ok := strings.Cut(value, ":")
+	_, known := matchFuncs[name]
+	return ok && known
+}
+
+// compileExpr parses and compiles a match expression.
+func compileExpr(value string) (matchExpr, error) {
+	p := &exprParser{input: value}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at offset %d", p.input[p.pos:], p.pos)
+	}
+	return expr, nil
+}
+
+// exprParser is a recursive descent parser over a match expression.
+type exprParser struct {
+	input string
+	pos   int
+}
+
+// skipSpace advances past whitespace.
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// consume advances past token and reports whether it came next.
+func (p *exprParser) consume(token string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.input[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+// parseOr parses a || chain.
+func (p *exprParser) parseOr() (matchExpr, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("||") {
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = orExpr{x, y}
+	}
+	return x, nil
+}
+
+// parseAnd parses a && chain.
+func (p *exprParser) parseAnd() (matchExpr, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("&&") {
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = andExpr{x, y}
+	}
+	return x, nil
+}
+
+// parseUnary parses a negation, a parenthesized expression or a match function.
+func (p *exprParser) parseUnary() (matchExpr, error) {
+	switch {
+	case p.consume("!"):
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{x}, nil
+	case p.consume("("):
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, fmt.Errorf("missing ) at offset %d", p.pos)
+		}
+		return x, nil
+	}
+	return p.parseFunc()
+}
+
+// parseFunc parses name:argument.
+func (p *exprParser) parseFunc() (matchExpr, error) {
+	p.skipSpace()
+	rest := p.input[p.pos:]
+	name, _, ok := strings.Cut(rest, ":")
+	if !ok || name == "" || strings.ContainsFunc(name, func(r rune) bool { return !unicode.IsLetter(r) }) {
+		if rest == "" {
+			return nil, fmt.Errorf("expression ends where a match function was expected")
+		}
+		return nil, fmt.Errorf("expected a match function such as contains:<text> at %q", rest)
+	}
+	compile, known := matchFuncs[name]
+	if !known {
+		return nil, fmt.Errorf("unknown match function %q", name)
+	}
+	p.pos += len(name) + 1
+
+	var arg string
+	if strings.HasPrefix(p.input[p.pos:], `"`) || strings.HasPrefix(p.input[p.pos:], "`") {
+		quoted, err := strconv.QuotedPrefix(p.input[p.pos:])
+		if err != nil {
+			return nil, fmt.Errorf("bad quoted argument of %s at offset %d: %v", name, p.pos, err)
+		}
+		arg, _ = strconv.Unquote(quoted)
+		p.pos += len(quoted)
+	} else {
+		end := strings.IndexFunc(p.input[p.pos:], func(r rune) bool { return unicode.IsSpace(r) || strings.ContainsRune("()&|!", r) })
+		if end < 0 {
+			end = len(p.input) - p.pos
+		}
+		arg = p.input[p.pos : p.pos+end]
+		p.pos += end
+	}
+	if arg == "" {
+		return nil, fmt.Errorf("%s without an argument", name)
+	}
+
+	test, err := compile(arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid argument of %s: %v", name, err)
+	}
+	return funcExpr{test}, nil
+}
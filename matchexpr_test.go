@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileExprEval(t *testing.T) {
+	tests := []struct {
+		expr  string
+		lines []string
+		want  bool
+	}{
+		// && binds tighter than ||, and ! tighter than both
+		{"contains:a || contains:b && contains:c", []string{"a"}, true},
+		{"contains:a || contains:b && contains:c", []string{"b"}, false},
+		{"contains:a || contains:b && contains:c", []string{"b", "c"}, true},
+		{"contains:b && contains:c || contains:a", []string{"a"}, true},
+		{"!contains:a && contains:b", []string{"b"}, true},
+		{"!contains:a && contains:b", []string{"a", "b"}, false},
+		{"!(contains:a && contains:b)", []string{"a"}, true},
+		{"!!contains:a", []string{"a"}, true},
+		// Parentheses override precedence and nest
+		{"(contains:a || contains:b) && contains:c", []string{"a"}, false},
+		{"(contains:a || contains:b) && contains:c", []string{"b", "c"}, true},
+		{"((contains:a || (contains:b)) && ((contains:c)))", []string{"a", "c"}, true},
+		{"( contains:a )&&(contains:b)", []string{"ab"}, true},
+		// A function holds when any line passes its test
+		{"prefix:cdn- && suffix:example.net", []string{"cdn-1.example.org", "edge.example.net"}, true},
+		{"equals:Example.COM.", []string{"example.com"}, true},
+		// Quoted arguments hold spaces and operators
+		{`contains:"a b"`, []string{"xa by"}, true},
+		{`contains:"a b"`, []string{"ab"}, false},
+		{`contains:"x&&y" || contains:z`, []string{"x&&y"}, true},
+		{`contains:"\x41(" && contains:b`, []string{"a(b"}, true},
+		{"regex:`\\.example\\.(net|org)$`", []string{"cdn.example.org"}, true},
+		{"regex:`\\.example\\.(net|org)$`", []string{"cdn.example.com"}, false},
+		{"regex:`^A\\d`", []string{"a1.example.com"}, true},
+	}
+	for _, tt := range tests {
+		expr, err := compileExpr(tt.expr)
+		if err != nil {
+			t.Errorf("compileExpr(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := expr.eval(tt.lines); got != tt.want {
+			t.Errorf("compileExpr(%q).eval(%q) = %v, want %v", tt.expr, tt.lines, got, tt.want)
+		}
+	}
+}
+
+func TestCompileExprErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"", "expression ends"},
+		{"contains:a &&", "expression ends"},
+		{"contains:a || !", "expression ends"},
+		{"foo:x", `unknown match function "foo"`},
+		{"contains:a && Contains:b", `unknown match function "Contains"`},
+		{"github.io", "expected a match function"},
+		{"contains:a && 3:b", "expected a match function"},
+		{"contains:", "contains without an argument"},
+		{`contains:""`, "contains without an argument"},
+		{"contains:) ", "contains without an argument"},
+		{"prefix: a", "prefix without an argument"},
+		{"contains:a )", `unexpected ")" at offset 11`},
+		{"contains:a contains:b", `unexpected "contains:b" at offset 11`},
+		{"(contains:a", "missing ) at offset 11"},
+		{"((contains:a) || contains:b", "missing )"},
+		{`contains:"abc`, "bad quoted argument of contains"},
+		{"regex:`abc", "bad quoted argument of regex"},
+		{"regex:`(`", "invalid argument of regex"},
+	}
+	for _, tt := range tests {
+		_, err := compileExpr(tt.expr)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("compileExpr(%q) error = %v, want %q", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestIsExpression(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"contains:s3", true},
+		{"!github.io", true},
+		{"(contains:a)", true},
+		{"github.io", false},
+		{"2001:db8::1", false},
+		{"example.com:8080", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isExpression(tt.value); got != tt.want {
+			t.Errorf("isExpression(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
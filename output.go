@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Output formats accepted by -format.
+const (
+	formatText    = "text"
+	formatJSON    = "json"
+	formatJSONL   = "jsonl"
+	formatCSV     = "csv"
+	formatCompact = "compact"
+	formatHTML    = "html"
+)
+
+// recordWriter writes scan results in one output format. Close finishes the output
+// (closing a JSON array or flushing CSV) but does not close the underlying writer.
+type recordWriter interface {
+	Write(record Record) error
+	Close() error
+}
+
+// recordField is a Record field that can be selected with -fields, named by its JSON tag.
+type recordField struct {
+	name  string
+	index int
+}
+
+// recordFields lists every selectable Record field in struct order.
+var recordFields = func() []recordField {
+	var fields []recordField
+	t := reflect.TypeOf(Record{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields = append(fields, recordField{name: name, index: i})
+		}
+	}
+	return fields
+}()
+
+// parseFields parses a comma-separated -fields list and validates each name against the
+// Record fields. An empty list selects every field.
+func parseFields(list string) ([]recordField, error) {
+	if strings.TrimSpace(list) == "" {
+		return recordFields, nil
+	}
+
+	byName := make(map[string]recordField, len(recordFields))
+	for _, field := range recordFields {
+		byName[field.name] = field
+	}
+
+	var fields []recordField
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		field, ok := byName[name]
+		if !ok {
+			names := make([]string, len(recordFields))
+			for i, f := range recordFields {
+				names[i] = f.name
+			}
+			return nil, fmt.Errorf("unknown field %q (available: %s)", name, strings.Join(names, ", "))
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// newRecordWriter returns a recordWriter for format, or a collapseWriter with
+// opts.Collapse. Fields select and order the columns
+// of json, jsonl and csv output; text output always uses the full line format. In csv
+// output the tags field becomes a column per tag name in opts.HostTags.
+// Continuing is set when output is appended to existing results, so the csv header is not
+// repeated; callers check it with checkCSVHeader first. Appending is not supported for a JSON array or an HTML report, which callers
+// must reject.
+func newRecordWriter(format string, output io.Writer, fields []recordField, opts Options, continuing bool) (recordWriter, error) {
+	if opts.Collapse {
+		return newCollapseWriter(format, output, opts.Header), nil
+	}
+	switch format {
+	case formatText, "":
+		return &textWriter{output: output, opts: opts}, nil
+	case formatJSON:
+		return &jsonWriter{output: output, fields: fields, array: true}, nil
+	case formatJSONL:
+		return &jsonWriter{output: output, fields: fields}, nil
+	case formatCSV:
+		return &csvWriter{output: csv.NewWriter(output), fields: fields, tagKeys: opts.HostTags.keys(), headerWritten: continuing}, nil
+	case formatCompact:
+		return &compactWriter{output: output}, nil
+	case formatHTML:
+		return &htmlWriter{output: output}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+// textWriter writes the human-readable "Subdomain: ..., CNAME: ..." lines, after the
+// opts.Header comment if there is one.
+type textWriter struct {
+	output        io.Writer
+	opts          Options
+	headerWritten bool
+}
+
+func (w *textWriter) Write(record Record) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	return writeRecord(w.output, record, w.opts)
+}
+
+func (w *textWriter) Close() error {
+	return w.writeHeader() // a scan without results still says what was scanned
+}
+
+// writeHeader writes opts.Header once.
+func (w *textWriter) writeHeader() error {
+	if w.headerWritten || w.opts.Header == "" {
+		return nil
+	}
+	w.headerWritten = true
+	_, err := io.WriteString(w.output, w.opts.Header)
+	return err
+}
+
+// headerPrefix starts the lines of the -header comment, which greps for result lines
+// pass over.
+const headerPrefix = "# "
+
+// scanHeader returns the -header comment of text output: the tool version, when the scan
+// started, the resolver backend and servers, and how many patterns and hosts it used.
+// Hosts is negative when the count is not known up front, as for stdin.
+func scanHeader(started time.Time, backend string, servers []string, patterns, hosts int) string {
+	resolver := backend
+	if len(servers) > 0 {
+		resolver += " " + strings.Join(servers, ",")
+	} else {
+		resolver += " (system resolver)"
+	}
+	hostCount := "streamed"
+	if hosts >= 0 {
+		hostCount = strconv.Itoa(hosts)
+	}
+	var b strings.Builder
+	for _, line := range [][2]string{
+		{"digcname", toolVersion()},
+		{"generated", started.UTC().Format(time.RFC3339)},
+		{"resolver", resolver},
+		{"patterns", strconv.Itoa(patterns)},
+		{"hosts", hostCount},
+	} {
+		fmt.Fprintf(&b, "%s%s: %s\n", headerPrefix, line[0], line[1])
+	}
+	return b.String()
+}
+
+// toolVersion returns the module version digcname was built from, or "(devel)" for a
+// build from a source tree.
+func toolVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// writeRecord writes a single record to the output as a text line.
+func writeRecord(output io.Writer, record Record, opts Options) error {
+	subdomain := record.Subdomain
+	if record.Error != "" {
+		_, err := fmt.Fprintf(output, "Subdomain: %s, Error: %s\n", subdomain, record.Error)
+		return err
+	}
+
+	answer := "CNAME: " + record.CNAME
+	hasAnswer := record.CNAME != "No CNAME record"
+	if record.PTR != "" {
+		answer = "PTR: " + record.PTR
+		hasAnswer = record.PTR != "No PTR record"
+	}
+
+	// Without recursion, a non-authoritative answer can only have come from the server's cache
+	details := ""
+	if opts.NoRecursion && hasAnswer {
+		if record.Authoritative {
+			details = ", Source: authoritative"
+		} else {
+			details = ", Source: cache"
+		}
+	}
+
+	if record.MultipleCNAMEs {
+		details += ", MultipleCNAMEs: Yes"
+	}
+
+	if record.Truncated {
+		details += ", Truncated: Yes"
+	}
+
+	if record.DNAME {
+		details += ", DNAME: Yes"
+	}
+
+	if len(record.Chain) > 1 {
+		details += ", Chain: " + strings.Join(record.Chain, " -> ")
+	}
+
+	if record.Confirmed && record.HTTPStatus == 0 {
+		details += fmt.Sprintf(", Confirmed: Yes (%q)", record.ConfirmMatch) // not an HTTP check
+	} else if record.Confirmed {
+		details += fmt.Sprintf(", Confirmed: Yes (HTTP %d, %q)", record.HTTPStatus, record.ConfirmMatch)
+	} else if record.HTTPStatus != 0 {
+		details += fmt.Sprintf(", Confirmed: No (HTTP %d)", record.HTTPStatus)
+	} else if record.SampledOut {
+		details += ", Confirmed: unconfirmed (sampled out)"
+	} else if record.ConfirmError != "" {
+		details += ", Confirmed: No (" + record.ConfirmError + ")"
+	}
+
+	if record.Resolution != "" && (opts.Verbose || opts.SkipResolvable) {
+		details += ", Resolution: " + record.Resolution
+	}
+
+	if opts.CrossCheck {
+		authority := record.AuthorityAnswer
+		if authority == "" {
+			authority = "none"
+		}
+		details += ", Authority: " + authority
+		if record.Discrepancy {
+			details += ", Discrepancy: Yes"
+		}
+	}
+
+	if opts.Verbose && record.RCode != "" {
+		details += ", RCODE: " + record.RCode
+	}
+
+	if opts.Verbose && record.AnsweredBy != "" {
+		details += ", AnsweredBy: " + record.AnsweredBy
+	}
+
+	if record.ConfirmInsecure {
+		details += ", TLS: unverified"
+	}
+
+	if opts.Verbose && record.ConfirmProtocol != "" {
+		details += ", Protocol: " + record.ConfirmProtocol
+	}
+
+	if opts.ShowAnswerHash {
+		details += ", AnswerHash: " + record.AnswerHash
+	}
+
+	if record.Change != "" {
+		details += ", Change: " + record.Change
+		if record.Change == changeCNAME {
+			baseline := record.BaselineCNAME
+			if baseline == "" {
+				baseline = "none"
+			}
+			details += ", Baseline CNAME: " + baseline
+		}
+		if record.Change == changeSeverity {
+			details += ", Baseline severity: " + record.BaselineSeverity.String()
+		}
+	}
+
+	if record.DelegationPattern != "" {
+		details += fmt.Sprintf(", Delegation: %s (%s)", record.DelegationZone, strings.Join(record.Nameservers, " "))
+		if record.LameDelegation {
+			details += ", Lame: Yes"
+		}
+	}
+
+	if record.MatchedIP != "" {
+		details += fmt.Sprintf(", Matched IP: %s (%s)", record.MatchedIP, record.MatchedRange)
+	}
+
+	if record.TargetASN != 0 || record.TargetOrg != "" {
+		details += fmt.Sprintf(", Target ASN: AS%d (%s)", record.TargetASN, record.TargetOrg)
+	}
+
+	if record.TTLWarning != "" {
+		details += fmt.Sprintf(", TTL: %ds (%s)", record.TTL, record.TTLWarning)
+	}
+
+	if opts.Verbose && len(record.Tags) > 0 {
+		details += ", Tags: " + formatTags(record.Tags)
+	}
+
+	if opts.Verbose && record.Remediation != "" {
+		details += ", Remediation: " + record.Remediation
+	}
+
+	// Output the result to the writer
+	var err error
+	if record.IsVulnerable {
+		_, err = fmt.Fprintf(output, "Subdomain: %s, %s, Vulnerable: Yes%s\n", subdomain, answer, details)
+	} else {
+		_, err = fmt.Fprintf(output, "Subdomain: %s, %s, Vulnerable: No%s\n", subdomain, answer, details)
+	}
+	return err
+}
+
+// compactWriter writes one "subdomain<TAB>cname<TAB>matched_pattern" line per vulnerable
+// record and nothing else, for use with cut, sort and grep. Several CNAME or PTR targets
+// are joined with commas.
+type compactWriter struct {
+	output io.Writer
+}
+
+func (w *compactWriter) Write(record Record) error {
+	if !record.IsVulnerable {
+		return nil
+	}
+	target := record.CNAME
+	if record.PTR != "" {
+		target = record.PTR
+	}
+	_, err := fmt.Fprintf(w.output, "%s\t%s\t%s\n", record.Subdomain, strings.ReplaceAll(target, "\n", ","), record.MatchedPattern)
+	return err
+}
+
+func (w *compactWriter) Close() error {
+	return nil
+}
+
+// jsonWriter writes records as JSON objects containing the selected fields, either one per
+// line (JSON Lines) or as the elements of a single JSON array.
+type jsonWriter struct {
+	output  io.Writer
+	fields  []recordField
+	array   bool
+	written int
+}
+
+func (w *jsonWriter) Write(record Record) error {
+	var b bytes.Buffer
+	if w.array {
+		if w.written == 0 {
+			b.WriteString("[\n")
+		} else {
+			b.WriteString(",\n")
+		}
+	}
+
+	b.WriteByte('{')
+	value := reflect.ValueOf(record)
+	for i, field := range w.fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(field.name)
+		data, err := json.Marshal(value.Field(field.index).Interface())
+		if err != nil {
+			return fmt.Errorf("error encoding field %s: %v", field.name, err)
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(data)
+	}
+	b.WriteByte('}')
+	if !w.array {
+		b.WriteByte('\n')
+	}
+
+	w.written++
+	_, err := w.output.Write(b.Bytes())
+	return err
+}
+
+func (w *jsonWriter) Close() error {
+	if !w.array {
+		return nil
+	}
+	closing := "\n]\n"
+	if w.written == 0 {
+		closing = "[]\n"
+	}
+	_, err := io.WriteString(w.output, closing)
+	return err
+}
+
+// csvWriter writes a header row of field names followed by one row per record. The tags
+// field is written as a tags.<name> column for each of tagKeys, so each tag can be
+// filtered and sorted on by itself, or as a single empty tags column when no host has
+// tags. The tag names are known before the scan, since only input files carry tags.
+type csvWriter struct {
+	output        *csv.Writer
+	fields        []recordField
+	tagKeys       []string
+	headerWritten bool
+}
+
+// tagsField is the name of the Record.Tags field.
+const tagsField = "tags"
+
+func (w *csvWriter) Write(record Record) error {
+	if !w.headerWritten {
+		if err := w.output.Write(csvHeader(w.fields, w.tagKeys)); err != nil {
+			return err
+		}
+		w.headerWritten = true
+	}
+
+	row := make([]string, 0, len(w.fields))
+	value := reflect.ValueOf(record)
+	for _, field := range w.fields {
+		if field.name == tagsField && len(w.tagKeys) > 0 {
+			for _, key := range w.tagKeys {
+				row = append(row, record.Tags[key])
+			}
+			continue
+		}
+		row = append(row, csvValue(value.Field(field.index).Interface()))
+	}
+	if err := w.output.Write(row); err != nil {
+		return err
+	}
+
+	// Flush every row through to the output, whose own buffering decides when it is written
+	w.output.Flush()
+	return w.output.Error()
+}
+
+func (w *csvWriter) Close() error {
+	w.output.Flush()
+	return w.output.Error()
+}
+
+// csvHeader returns the header row a csvWriter writes for fields and tagKeys.
+func csvHeader(fields []recordField, tagKeys []string) []string {
+	header := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if field.name == tagsField && len(tagKeys) > 0 {
+			for _, key := range tagKeys {
+				header = append(header, tagsField+"."+key)
+			}
+			continue
+		}
+		header = append(header, field.name)
+	}
+	return header
+}
+
+// checkCSVHeader reports an error unless the csv results in the named file, compressed
+// if gzipped, start with header. Rows appended with -append are not preceded by a header
+// of their own, so they must line up with the columns of the earlier results, which
+// differ when those were written with other -fields or for hosts with other tags.
+func checkCSVHeader(name string, gzipped bool, header []string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("error opening file %s: %v", name, err)
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if gzipped {
+		compressed, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", name, err)
+		}
+		defer compressed.Close()
+		r = compressed
+	}
+	existing, err := csv.NewReader(r).Read()
+	if err != nil {
+		return fmt.Errorf("error reading the header of %s: %v", name, err)
+	}
+	if !slices.Equal(existing, header) {
+		return fmt.Errorf("%s has the columns %s, but this scan writes %s", name, strings.Join(existing, ","), strings.Join(header, ","))
+	}
+	return nil
+}
+
+// csvValue renders a Record field value as a CSV cell. Lists are space-separated, and
+// maps are space-separated key=value pairs in key order.
+func csvValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case []string:
+		return strings.Join(v, " ")
+	case map[string]string:
+		return formatTags(v)
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// formatTags renders tags as space-separated key=value pairs in key order.
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// closingWriter closes a stream, such as an encryptWriter or a gzip.Writer, after the
+// record writer in front of it, so the stream's final block includes whatever the record
+// writer writes on Close.
+type closingWriter struct {
+	next   recordWriter
+	stream io.Closer
+}
+
+func (w *closingWriter) Write(record Record) error {
+	return w.next.Write(record)
+}
+
+func (w *closingWriter) Close() error {
+	err := w.next.Close()
+	if closeErr := w.stream.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// taggedRecord is a finding for a host read from a JSON subdomains file with tags.
+var taggedRecord = Record{
+	Subdomain:      "shop.example.com",
+	CNAME:          "gone.herokuapp.com",
+	IsVulnerable:   true,
+	MatchedPattern: "herokuapp.com",
+	Severity:       SeverityHigh,
+	Tags:           map[string]string{"team": "payments", "env": "prod"},
+}
+
+// writeRecords writes records in format and returns the output.
+func writeRecords(t *testing.T, format string, fields []recordField, opts Options, records ...Record) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := newRecordWriter(format, &buf, fields, opts, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if err := w.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestTagsInTextOutput(t *testing.T) {
+	out := writeRecords(t, formatText, recordFields, Options{Verbose: true}, taggedRecord)
+	if !strings.Contains(out, "Tags: env=prod team=payments") {
+		t.Errorf("verbose text output %q lacks the tags in key order", out)
+	}
+	// The default line keeps its format
+	if out := writeRecords(t, formatText, recordFields, Options{}, taggedRecord); strings.Contains(out, "Tags:") {
+		t.Errorf("text output %q shows tags without -v", out)
+	}
+}
+
+func TestTagsInJSONOutput(t *testing.T) {
+	for _, format := range []string{formatJSON, formatJSONL} {
+		out := writeRecords(t, format, recordFields, Options{}, taggedRecord)
+		if format == formatJSON {
+			out = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(out), "["), "]")
+		}
+		var decoded struct {
+			Tags map[string]string `json:"tags"`
+		}
+		if err := json.Unmarshal([]byte(out), &decoded); err != nil {
+			t.Fatalf("%s: %v in %q", format, err, out)
+		}
+		if !reflect.DeepEqual(decoded.Tags, taggedRecord.Tags) {
+			t.Errorf("%s: tags = %v, want %v", format, decoded.Tags, taggedRecord.Tags)
+		}
+	}
+}
+
+func TestTagsInCSVOutput(t *testing.T) {
+	fields, err := parseFields("subdomain,tags,severity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{HostTags: hostTags{
+		"shop.example.com": taggedRecord.Tags,
+		"blog.example.com": {"owner": "marketing"},
+	}}
+	untagged := Record{Subdomain: "api.example.com"}
+	rows, err := csv.NewReader(strings.NewReader(writeRecords(t, formatCSV, fields, opts, taggedRecord, untagged))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"subdomain", "tags.env", "tags.owner", "tags.team", "severity"},
+		{"shop.example.com", "prod", "", "payments", "high"},
+		{"api.example.com", "", "", "", "none"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+
+	// Without tagged input the tags field stays a single column
+	if header := csvHeader(fields, hostTags{}.keys()); !reflect.DeepEqual(header, []string{"subdomain", "tags", "severity"}) {
+		t.Errorf("header = %q, want a single tags column", header)
+	}
+}
+
+func TestTagsInHTMLOutput(t *testing.T) {
+	out := writeRecords(t, formatHTML, recordFields, Options{}, taggedRecord)
+	if !strings.Contains(out, "<td>env=prod team=payments</td>") {
+		t.Errorf("html report lacks the tags cell:\n%s", out)
+	}
+}
+
+func TestTagsInHookEnv(t *testing.T) {
+	env := hookEnv(taggedRecord)
+	if want := envPrefix + "FINDING_TAGS=env=prod team=payments"; !strings.Contains(strings.Join(env, "\n"), want) {
+		t.Errorf("hook environment %q lacks %q", env, want)
+	}
+}
+
+func TestCheckCSVHeader(t *testing.T) {
+	fields, err := parseFields("subdomain,tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "results.csv")
+	if err := os.WriteFile(plain, []byte("subdomain,tags.team\nshop.example.com,payments\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte("subdomain,tags.team\n"))
+	gz.Close()
+	zipped := filepath.Join(dir, "results.csv.gz")
+	if err := os.WriteFile(zipped, compressed.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	same := csvHeader(fields, []string{"team"})
+	if err := checkCSVHeader(plain, false, same); err != nil {
+		t.Errorf("matching header refused: %v", err)
+	}
+	if err := checkCSVHeader(zipped, true, same); err != nil {
+		t.Errorf("matching gzip header refused: %v", err)
+	}
+	for _, keys := range [][]string{{"env", "team"}, nil} {
+		if err := checkCSVHeader(plain, false, csvHeader(fields, keys)); err == nil {
+			t.Errorf("header with tag columns %q accepted for a file with tags.team alone", keys)
+		}
+	}
+}
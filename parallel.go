@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+)
+
+// parallelExtensions are the result file extensions of -parallel-files by format.
+var parallelExtensions = map[string]string{
+	formatText:    ".txt",
+	formatJSON:    ".json",
+	formatJSONL:   ".jsonl",
+	formatCSV:     ".csv",
+	formatCompact: ".tsv",
+	formatHTML:    ".html",
+}
+
+// parallelOutputName returns the result file of input in dir for -parallel-files: the
+// input's base name with the extension of format, so clients/acme.txt becomes
+// dir/acme.json.
+func parallelOutputName(dir, input, format string, gzipped bool) string {
+	base := filepath.Base(input)
+	name := strings.TrimSuffix(base, filepath.Ext(base)) + parallelExtensions[format]
+	if gzipped {
+		name += ".gz"
+	}
+	return filepath.Join(dir, name)
+}
+
+// unionHosts returns every host of lists once, in the order they are first listed, so
+// a host shared by several input files is only looked up once.
+func unionHosts(lists [][]string) []string {
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, list := range lists {
+		for _, host := range list {
+			if !seen[host] {
+				seen[host] = true
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts
+}
+
+// routedFile is one result file of a fileRouter and what was written to it.
+type routedFile struct {
+	name     string
+	output   recordWriter
+	records  int
+	findings int
+}
+
+// fileRouter is the recordWriter of -parallel-files. All the input files are scanned
+// together, so they share the resolver, its cache and the workers, and the router writes
+// each record to the result file of every input file that listed its host.
+type fileRouter struct {
+	files  []*routedFile
+	byHost map[string][]*routedFile
+}
+
+// newFileRouter returns a router without any files.
+func newFileRouter() *fileRouter {
+	return &fileRouter{byHost: make(map[string][]*routedFile)}
+}
+
+// add routes the records of hosts to output, the result file name.
+func (r *fileRouter) add(name string, output recordWriter, hosts []string) {
+	file := &routedFile{name: name, output: output}
+	r.files = append(r.files, file)
+	for _, host := range hosts {
+		routes := r.byHost[host]
+		if len(routes) > 0 && routes[len(routes)-1] == file {
+			continue // listed twice in the same file
+		}
+		r.byHost[host] = append(routes, file)
+	}
+}
+
+func (r *fileRouter) Write(record Record) error {
+	routes := r.byHost[record.Subdomain]
+	if len(routes) == 0 {
+		return fmt.Errorf("no input file lists %s", record.Subdomain)
+	}
+	for _, file := range routes {
+		if err := file.output.Write(record); err != nil {
+			return fmt.Errorf("%s: %v", file.name, err)
+		}
+		file.records++
+		if record.IsVulnerable {
+			file.findings++
+		}
+	}
+	return nil
+}
+
+// Close closes every result file, returning the first error.
+func (r *fileRouter) Close() error {
+	var first error
+	for _, file := range r.files {
+		if err := file.output.Close(); err != nil && first == nil {
+			first = fmt.Errorf("%s: %v", file.name, err)
+		}
+	}
+	return first
+}
+
+// logFiles logs what was written to each result file.
+func (r *fileRouter) logFiles() {
+	for _, file := range r.files {
+		slog.Info("Wrote results", "file", file.name, "records", file.records, "findings", file.findings)
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Severity ranks how serious a pattern match is. The zero value means no match.
+type Severity int
+
+const (
+	SeverityNone Severity = iota
+	SeverityInfo
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+// defaultSeverity is assigned to patterns that do not carry a severity prefix.
+const defaultSeverity = SeverityMedium
+
+// severityPrefix introduces an optional severity in a pattern line, e.g. "severity:high azurewebsites.net".
+const severityPrefix = "severity:"
+
+var severityNames = map[Severity]string{
+	SeverityNone:     "none",
+	SeverityInfo:     "info",
+	SeverityLow:      "low",
+	SeverityMedium:   "medium",
+	SeverityHigh:     "high",
+	SeverityCritical: "critical",
+}
+
+// String returns the lowercase name of the severity.
+func (s Severity) String() string {
+	if name, ok := severityNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// MarshalText encodes the severity as its name, so JSON output shows "high" rather than a number.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a severity name written by MarshalText.
+func (s *Severity) UnmarshalText(text []byte) error {
+	if string(text) == severityNames[SeverityNone] {
+		*s = SeverityNone
+		return nil
+	}
+	severity, err := parseSeverity(string(text))
+	if err != nil {
+		return err
+	}
+	*s = severity
+	return nil
+}
+
+// parseSeverity converts a severity name such as "high" into a Severity.
+func parseSeverity(name string) (Severity, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for severity, severityName := range severityNames {
+		if severity != SeverityNone && severityName == name {
+			return severity, nil
+		}
+	}
+	return SeverityNone, fmt.Errorf("unknown severity %q", name)
+}
+
+// Pattern is a fingerprint matched against CNAME targets, with the severity of a match.
+type Pattern struct {
+	Value    string
+	Severity Severity
+	// Service names the provider the pattern belongs to, when the fingerprint source says so.
+	Service string
+	// Fingerprint is the response body text that confirms a takeover over HTTP, and
+	// HTTPStatus the status code that goes with it (zero when any status will do). The
+	// fingerprint "NXDOMAIN" means the takeover is confirmed by the target not resolving.
+	Fingerprint string
+	HTTPStatus  int
+	// Check names the confirmation check in the checks registry; empty picks one from
+	// the fingerprint.
+	Check string
+	// Path is the URL path requested to confirm a takeover, for providers whose error
+	// page is not at the root; empty means -probe-path.
+	Path string
+	// Remediation tells analysts how to fix a host matching the pattern.
+	Remediation string
+
+	// expr is the compiled form of a Value written as a match expression (see matchExpr);
+	// nil for a plain substring.
+	expr matchExpr
+}
+
+// compile compiles the pattern's Value when it is a match expression.
+func (p *Pattern) compile() error {
+	if !isExpression(p.Value) {
+		return nil
+	}
+	expr, err := compileExpr(p.Value)
+	if err != nil {
+		return fmt.Errorf("invalid match expression %q: %v", p.Value, err)
+	}
+	p.expr = expr
+	return nil
+}
+
+// parsePatterns converts pattern file lines into Patterns. A line may start with a
+// "severity:<level>" prefix; lines without one get defaultSeverity. A pattern written as
+// a match expression is compiled here, so a malformed one fails the load.
+func parsePatterns(lines []string) ([]Pattern, error) {
+	patterns := make([]Pattern, 0, len(lines))
+	for _, line := range lines {
+		pattern := Pattern{Value: line, Severity: defaultSeverity}
+
+		if strings.HasPrefix(line, severityPrefix) {
+			level, value := strings.TrimSpace(strings.TrimPrefix(line, severityPrefix)), ""
+			if i := strings.IndexFunc(level, unicode.IsSpace); i >= 0 {
+				level, value = level[:i], strings.TrimSpace(level[i:])
+			}
+			if value == "" || (strings.ContainsFunc(value, unicode.IsSpace) && !isExpression(value)) {
+				return nil, fmt.Errorf("invalid pattern line %q: expected \"%s<level> <pattern>\"", line, severityPrefix)
+			}
+			severity, err := parseSeverity(level)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern line %q: %v", line, err)
+			}
+			pattern = Pattern{Value: value, Severity: severity}
+		}
+		if err := pattern.compile(); err != nil {
+			return nil, fmt.Errorf("invalid pattern line %q: %v", line, err)
+		}
+
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// defaultPatternData holds the built-in fingerprints used when no patterns are supplied.
+//
+//go:embed patterns.txt
+var defaultPatternData []byte
+
+// defaultPatternSource names the built-in fingerprints in log messages.
+const defaultPatternSource = "built-in defaults"
+
+// selectPatterns returns the patterns to scan with and a description of where they came
+// from. Without a source the built-in defaults are used; with one, the source replaces
+// them unless withDefaults is set, in which case both are merged and the source's entries
+// take precedence. source may list several files and URLs separated by commas, and
+// local files may be globs (see splitPatternSources); their patterns are merged the same
+// way, earlier sources taking precedence.
+func selectPatterns(source, cacheFile string, withDefaults bool) ([]Pattern, string, error) {
+	defaults, err := parsePatternData(defaultPatternData, defaultPatternSource)
+	if err != nil {
+		return nil, "", err
+	}
+	sources, err := splitPatternSources(source)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(sources) == 0 {
+		sortBySeverity(defaults)
+		return defaults, defaultPatternSource, nil
+	}
+	if cacheFile != "" && countRemote(sources) > 1 {
+		return nil, "", errors.New("a fingerprints cache holds one URL source, but several were given")
+	}
+
+	var patterns []Pattern
+	seen := make(map[string]bool)
+	duplicates := 0
+	merge := func(from []Pattern) {
+		for _, pattern := range from {
+			if seen[pattern.Value] {
+				duplicates++
+				continue
+			}
+			seen[pattern.Value] = true
+			patterns = append(patterns, pattern)
+		}
+	}
+	for _, name := range sources {
+		loaded, err := loadPatternSource(name, cacheFile)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(sources) > 1 {
+			slog.Info("Loaded pattern source", "source", name, "patterns", len(loaded))
+		}
+		merge(loaded)
+	}
+	if len(sources) > 1 && duplicates > 0 {
+		slog.Info("Dropped duplicate patterns", "duplicates", duplicates)
+	}
+	description := strings.Join(sources, ", ")
+	if withDefaults {
+		merge(defaults)
+		description += " and " + defaultPatternSource
+	}
+	sortBySeverity(patterns)
+	return patterns, description, nil
+}
+
+// splitPatternSources splits a comma-separated list of pattern files and URLs. A local
+// name holding glob characters is expanded to the files it matches, in lexical order,
+// and must match at least one.
+func splitPatternSources(list string) ([]string, error) {
+	var sources []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if isRemoteSource(name) || !strings.ContainsAny(name, "*?[") {
+			sources = append(sources, name)
+			continue
+		}
+		matches, err := filepath.Glob(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern file glob %q: %v", name, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no pattern files match %s", name)
+		}
+		sources = append(sources, matches...)
+	}
+	return sources, nil
+}
+
+// isRemoteSource reports whether a pattern source is an http(s):// URL.
+func isRemoteSource(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// countRemote counts the http(s):// URLs among sources.
+func countRemote(sources []string) int {
+	n := 0
+	for _, source := range sources {
+		if isRemoteSource(source) {
+			n++
+		}
+	}
+	return n
+}
+
+// fingerprintEntry is one entry of a JSON fingerprint database in the can-i-take-over-xyz
+// layout: a service, the CNAME suffixes that identify it, and whether it is takeover-prone.
+type fingerprintEntry struct {
+	Service    string   `json:"service"`
+	CNAME      []string `json:"cname"`
+	Vulnerable bool     `json:"vulnerable"`
+	Severity   string   `json:"severity"`
+	// Fingerprint and HTTPStatus describe the response that confirms a takeover.
+	Fingerprint string `json:"fingerprint"`
+	HTTPStatus  *int   `json:"http_status"`
+	// Check names the confirmation check to run instead of the default one.
+	Check string `json:"check"`
+	// Path is the URL path to request when confirming over HTTP.
+	Path string `json:"path"`
+	// Remediation is the fix attached to matching records.
+	Remediation string `json:"remediation"`
+	// Enabled turns the entry off when false, so it can be kept in the database unused;
+	// entries without it are enabled.
+	Enabled *bool `json:"enabled"`
+}
+
+// fingerprintFetchTimeout bounds fetching a remote fingerprint source.
+const fingerprintFetchTimeout = 30 * time.Second
+
+// sortBySeverity orders patterns from most to least severe, keeping file order among equals.
+func sortBySeverity(patterns []Pattern) {
+	sort.SliceStable(patterns, func(i, j int) bool {
+		return patterns[i].Severity > patterns[j].Severity
+	})
+}
+
+// loadPatternSource reads and parses the patterns of a local file or an http(s):// URL.
+func loadPatternSource(source, cacheFile string) ([]Pattern, error) {
+	if !isRemoteSource(source) {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return nil, fmt.Errorf("error opening file %s: %v", source, err)
+		}
+		return parsePatternData(data, source)
+	}
+
+	data, err := fetchFingerprints(source)
+	if err != nil {
+		if cacheFile == "" {
+			return nil, err
+		}
+		cached, cacheErr := os.ReadFile(cacheFile)
+		if cacheErr != nil {
+			return nil, fmt.Errorf("%v (no usable cache: %v)", err, cacheErr)
+		}
+		slog.Warn("Fetching fingerprints failed, using cached copy", "cache", cacheFile, "error", err)
+		return parsePatternData(cached, cacheFile)
+	}
+
+	patterns, err := parsePatternData(data, source)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only cache sources that parsed, so a bad fetch never replaces a good cache
+	if cacheFile != "" {
+		if err := writeFileAtomic(cacheFile, data); err != nil {
+			slog.Warn("Failed to cache fingerprints", "cache", cacheFile, "error", err)
+		}
+	}
+	return patterns, nil
+}
+
+// fetchFingerprints downloads a remote fingerprint source.
+func fetchFingerprints(url string) ([]byte, error) {
+	client := &http.Client{Timeout: fingerprintFetchTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching fingerprints from %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching fingerprints from %s: %s", url, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading fingerprints from %s: %v", url, err)
+	}
+	return data, nil
+}
+
+// disabledPrefix starts a line of a patterns file that is skipped, so a pattern can be
+// turned off without deleting it. Neither a CNAME pattern nor a match expression can
+// start with "#", while a leading "!" negates an expression.
+const disabledPrefix = "#!"
+
+// parsePatternData parses a fingerprint source that is either a JSON fingerprint database
+// (a JSON array) or a plain patterns file with one pattern per line. Lines starting with
+// disabledPrefix are skipped, and how many were is logged.
+func parsePatternData(data []byte, name string) ([]Pattern, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		return parseFingerprintJSON(trimmed, name)
+	}
+
+	lines, err := readLines(bytes.NewReader(data), name)
+	if err != nil {
+		return nil, err
+	}
+	enabled := lines[:0]
+	for _, line := range lines {
+		if !strings.HasPrefix(line, disabledPrefix) {
+			enabled = append(enabled, line)
+		}
+	}
+	if disabled := len(lines) - len(enabled); disabled > 0 {
+		slog.Info("Skipped disabled patterns", "source", name, "disabled", disabled)
+	}
+	return parsePatterns(enabled)
+}
+
+// parseFingerprintJSON converts a JSON fingerprint database into Patterns, one per CNAME
+// suffix. Entries without an explicit severity are high when marked vulnerable; entries
+// that are neither vulnerable nor given a severity are skipped, as are entries with
+// "enabled": false, whose number is logged.
+func parseFingerprintJSON(data []byte, name string) ([]Pattern, error) {
+	var entries []fingerprintEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("error parsing fingerprint database %s: %v", name, err)
+	}
+
+	var patterns []Pattern
+	disabled := 0
+	for _, entry := range entries {
+		if entry.Enabled != nil && !*entry.Enabled {
+			disabled++
+			continue
+		}
+		if !entry.Vulnerable && entry.Severity == "" {
+			continue
+		}
+
+		severity := SeverityHigh
+		if entry.Severity != "" {
+			var err error
+			severity, err = parseSeverity(entry.Severity)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing fingerprint database %s: service %q: %v", name, entry.Service, err)
+			}
+		}
+
+		check := strings.TrimSpace(entry.Check)
+		if _, ok := checks[check]; check != "" && !ok {
+			return nil, fmt.Errorf("error parsing fingerprint database %s: service %q: unknown check %q", name, entry.Service, check)
+		}
+
+		path := strings.TrimSpace(entry.Path)
+		if path != "" && !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("error parsing fingerprint database %s: service %q: path %q does not start with /", name, entry.Service, path)
+		}
+
+		status := 0
+		if entry.HTTPStatus != nil {
+			status = *entry.HTTPStatus
+		}
+		for _, cname := range entry.CNAME {
+			if cname = strings.TrimSpace(cname); cname != "" {
+				pattern := Pattern{
+					Value:       cname,
+					Severity:    severity,
+					Service:     entry.Service,
+					Fingerprint: strings.TrimSpace(entry.Fingerprint),
+					HTTPStatus:  status,
+					Check:       check,
+					Path:        path,
+					Remediation: strings.TrimSpace(entry.Remediation),
+				}
+				if err := pattern.compile(); err != nil {
+					return nil, fmt.Errorf("error parsing fingerprint database %s: service %q: %v", name, entry.Service, err)
+				}
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+	if disabled > 0 {
+		slog.Info("Skipped disabled fingerprints", "source", name, "disabled", disabled)
+	}
+	return patterns, nil
+}
+
+// writeFileAtomic writes data to filename via a temporary file in the same directory.
+func writeFileAtomic(filename string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
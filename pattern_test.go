@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestParsePatternDataSkipsDisabledLines(t *testing.T) {
+	data := "herokuapp.com\n#!github.io\n!contains:internal\n#!severity:low !contains:s3\n"
+	patterns, err := parsePatternData([]byte(data), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for _, pattern := range patterns {
+		values = append(values, pattern.Value)
+	}
+	if len(values) != 2 || values[0] != "herokuapp.com" || values[1] != "!contains:internal" {
+		t.Fatalf("patterns = %q, want herokuapp.com and !contains:internal", values)
+	}
+	// A leading ! still negates an expression instead of disabling the line
+	negated := patterns[1]
+	if negated.expr == nil || matchesPattern([]string{"internal.example.net"}, negated) || !matchesPattern([]string{"app.example.net"}, negated) {
+		t.Errorf("!contains:internal was not compiled as a negated expression")
+	}
+}
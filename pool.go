@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultResolverCooldown is how long an unhealthy resolver is benched.
+const defaultResolverCooldown = 30 * time.Second
+
+const (
+	// healthWeight is the weight of the latest lookup in a member's moving averages.
+	healthWeight = 0.2
+	// benchErrorRate is the moving error rate at which a member is benched.
+	benchErrorRate = 0.5
+	// minHealthSamples is how many lookups a member answers before it can be benched.
+	minHealthSamples = 5
+	// maxPoolAttempts bounds how many members a failed lookup is tried on.
+	maxPoolAttempts = 3
+)
+
+// resolverPool spreads lookups over several resolvers, one per -server, and keeps track
+// of each one's recent error rate and latency. Every lookup goes to the healthier of two
+// members taken in round-robin order, so a slow or failing resolver gets fewer queries;
+// one whose error rate reaches benchErrorRate is benched for the cooldown and then
+// reintroduced. A failed lookup is retried on other members before it fails, after a
+// randomized backoff.
+//
+// When the servers are given weights, lookups are instead drawn at random in proportion
+// to the weights of the available members, and health only matters through benching.
+type resolverPool struct {
+	members  []*poolMember
+	cooldown time.Duration
+	next     atomic.Uint64
+	// rand draws weighted members; nil when the pool is not weighted.
+	rand *runRand
+	// backoff spaces out the retries of a failed lookup; nil retries at once.
+	backoff *backoff
+}
+
+// poolMember is one resolver of a pool and its health.
+type poolMember struct {
+	server   string
+	resolver Resolver
+	weight   int
+
+	mu           sync.Mutex
+	errorRate    float64       // moving average, 0 to 1
+	latency      time.Duration // moving average of successful lookups
+	samples      int
+	benchedUntil time.Time
+
+	queries  int64
+	failures int64
+	benched  int
+}
+
+// newResolverPool returns a pool of resolvers, keyed by the server each one queries.
+// weights holds the weight of each server, or is nil for an unweighted pool; rand draws
+// the members of a weighted one.
+func newResolverPool(servers []string, weights []int, resolvers []Resolver, cooldown time.Duration, rand *runRand) *resolverPool {
+	pool := &resolverPool{cooldown: cooldown}
+	if weights != nil {
+		pool.rand = rand
+	}
+	for i, resolver := range resolvers {
+		member := &poolMember{server: servers[i], resolver: resolver, weight: 1}
+		if weights != nil {
+			member.weight = weights[i]
+		}
+		pool.members = append(pool.members, member)
+	}
+	return pool
+}
+
+// logShares logs the share of lookups each member's weight gives it.
+func (p *resolverPool) logShares() {
+	total := 0
+	for _, m := range p.members {
+		total += m.weight
+	}
+	for _, m := range p.members {
+		slog.Info("Resolver weight", "server", m.server, "weight", m.weight, "share", math.Round(1000*float64(m.weight)/float64(total))/10)
+	}
+}
+
+// selectServerResolver returns the resolver for a -server list: the backend selectResolver
+// picks for the first server, or, when there are several servers, a pool of one resolver
+// per server, which is also returned. The name of the first server's backend is returned
+// too. opts.Server is replaced by the first server.
+func selectServerResolver(opts Options, servers []string, weights []int, cooldown time.Duration, rand *runRand) (Resolver, string, *resolverPool, error) {
+	opts.Server = ""
+	if len(servers) > 0 {
+		opts.Server = servers[0]
+	}
+	resolver, backend, err := selectResolver(opts)
+	if err != nil || len(servers) < 2 || opts.Backend == backendReplay {
+		return resolver, backend, nil, err
+	}
+
+	// Several servers share the lookups through a pool that benches unhealthy ones
+	resolvers := []Resolver{resolver}
+	for _, extra := range servers[1:] {
+		memberOpts := opts
+		memberOpts.Server = extra
+		member, memberBackend, err := selectResolver(memberOpts)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("error setting up %s: %v", extra, err)
+		}
+		if opts.Verbose {
+			slog.Info("Using resolver backend", "backend", memberBackend, "server", extra)
+		}
+		resolvers = append(resolvers, member)
+	}
+	pool := newResolverPool(servers, weights, resolvers, cooldown, rand)
+	pool.backoff = opts.RetryBackoff
+	return pool, backend, pool, nil
+}
+
+// LookupCNAME resolves name's CNAME on the healthiest available member.
+func (p *resolverPool) LookupCNAME(ctx context.Context, name string) (Answer, error) {
+	return p.lookup(ctx, func(r Resolver) (Answer, error) { return r.LookupCNAME(ctx, name) })
+}
+
+// LookupPTR resolves ip's PTR on the healthiest available member.
+func (p *resolverPool) LookupPTR(ctx context.Context, ip string) (Answer, error) {
+	return p.lookup(ctx, func(r Resolver) (Answer, error) { return r.LookupPTR(ctx, ip) })
+}
+
+// LookupAddrs resolves name's addresses on the healthiest available member.
+func (p *resolverPool) LookupAddrs(ctx context.Context, name string) (Answer, error) {
+	return p.lookup(ctx, func(r Resolver) (Answer, error) { return r.LookupAddrs(ctx, name) })
+}
+
+// lookup runs query on a member, and on further members while it fails, up to
+// maxPoolAttempts. The last error is returned when every attempt fails.
+func (p *resolverPool) lookup(ctx context.Context, query func(Resolver) (Answer, error)) (Answer, error) {
+	tried := make(map[*poolMember]bool)
+	var lastErr error
+	for attempt := 0; attempt < maxPoolAttempts && len(tried) < len(p.members); attempt++ {
+		if attempt > 0 {
+			if p.backoff.wait(ctx, attempt-1); ctx.Err() != nil {
+				return Answer{}, lastErr
+			}
+		}
+		member := p.pick(tried)
+		tried[member] = true
+
+		started := time.Now()
+		answer, err := query(member.resolver)
+		if errors.Is(ctx.Err(), context.Canceled) || (ctx.Err() != nil && attempt > 0) {
+			// The scan was stopped, or a retry inherited too little of the lookup's time;
+			// neither says anything about the resolver
+			return answer, err
+		}
+		member.observe(err, time.Since(started), p.cooldown)
+		if err == nil || ctx.Err() != nil {
+			return answer, err // a lookup out of time has no time left to retry
+		}
+		lastErr = err
+	}
+	return Answer{}, lastErr
+}
+
+// pick returns the healthier of the next two untried members in round-robin order.
+// Benched members are skipped unless every untried member is benched, in which case the
+// one whose bench ends first is used.
+func (p *resolverPool) pick(tried map[*poolMember]bool) *poolMember {
+	if p.rand != nil {
+		return p.pickWeighted(tried)
+	}
+	now := time.Now()
+	start := int(p.next.Add(1) % uint64(len(p.members)))
+	var candidates []*poolMember
+	var fallback *poolMember
+	for i := range p.members {
+		member := p.members[(start+i)%len(p.members)]
+		if tried[member] {
+			continue
+		}
+		if until := member.benchUntil(); until.After(now) {
+			if fallback == nil || until.Before(fallback.benchUntil()) {
+				fallback = member
+			}
+			continue
+		}
+		if candidates = append(candidates, member); len(candidates) == 2 {
+			break
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return fallback
+	case 1:
+		return candidates[0]
+	}
+	if candidates[1].score() < candidates[0].score() {
+		return candidates[1]
+	}
+	return candidates[0]
+}
+
+// pickWeighted draws an untried member at random in proportion to its weight. Benched
+// members are skipped unless every untried member is benched, in which case the one whose
+// bench ends first is used.
+func (p *resolverPool) pickWeighted(tried map[*poolMember]bool) *poolMember {
+	now := time.Now()
+	var available []*poolMember
+	var fallback *poolMember
+	total := 0
+	for _, member := range p.members {
+		if tried[member] {
+			continue
+		}
+		if until := member.benchUntil(); until.After(now) {
+			if fallback == nil || until.Before(fallback.benchUntil()) {
+				fallback = member
+			}
+			continue
+		}
+		available = append(available, member)
+		total += member.weight
+	}
+	if len(available) == 0 {
+		return fallback
+	}
+	draw := int(p.rand.Int64N(int64(total)))
+	for _, member := range available {
+		if draw -= member.weight; draw < 0 {
+			return member
+		}
+	}
+	return available[len(available)-1]
+}
+
+// benchUntil returns when the member's bench ends; it is not benched after that time.
+func (m *poolMember) benchUntil() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.benchedUntil
+}
+
+// score rates a member for routing, lower being better: its latency, inflated by its
+// error rate.
+func (m *poolMember) score() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return float64(m.latency+time.Millisecond) * (1 + 10*m.errorRate)
+}
+
+// observe folds the outcome of a lookup into the member's health, benching it for
+// cooldown when its error rate is too high. A reintroduced member starts from half its
+// error rate, so one more failure benches it again quickly.
+func (m *poolMember) observe(err error, elapsed time.Duration, cooldown time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries++
+	m.samples++
+	failed := 0.0
+	if err != nil {
+		m.failures++
+		failed = 1
+	} else if m.latency == 0 {
+		m.latency = elapsed
+	} else {
+		m.latency = time.Duration((1-healthWeight)*float64(m.latency) + healthWeight*float64(elapsed))
+	}
+	m.errorRate = (1-healthWeight)*m.errorRate + healthWeight*failed
+
+	if m.samples >= minHealthSamples && m.errorRate >= benchErrorRate && !m.benchedUntil.After(time.Now()) {
+		m.benchedUntil = time.Now().Add(cooldown)
+		m.benched++
+		m.errorRate /= 2
+		m.samples = 0
+	}
+}
+
+// writeHealth prints each member's queries, failures, latency and benchings to w. A
+// weighted pool also gets each member's weight and its actual share of the queries.
+func (p *resolverPool) writeHealth(w io.Writer) error {
+	members := append([]*poolMember(nil), p.members...)
+	sort.SliceStable(members, func(i, j int) bool { return members[i].server < members[j].server })
+	var total int64
+	for _, m := range members {
+		m.mu.Lock()
+		total += m.queries
+		m.mu.Unlock()
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if p.rand != nil {
+		fmt.Fprintln(tw, "RESOLVER\tWEIGHT\tQUERIES\tSHARE\tFAILED\tLATENCY\tBENCHED")
+	} else {
+		fmt.Fprintln(tw, "RESOLVER\tQUERIES\tFAILED\tLATENCY\tBENCHED")
+	}
+	for _, m := range members {
+		m.mu.Lock()
+		latency := m.latency.Round(100 * time.Microsecond)
+		if p.rand != nil {
+			share := 0.0
+			if total > 0 {
+				share = 100 * float64(m.queries) / float64(total)
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f%%\t%d\t%v\t%d\n", m.server, m.weight, m.queries, share, m.failures, latency, m.benched)
+		} else {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%v\t%d\n", m.server, m.queries, m.failures, latency, m.benched)
+		}
+		m.mu.Unlock()
+	}
+	return tw.Flush()
+}
+
+// appendServerLines appends the servers of -resolvers-file lines to a -server value. Each
+// line is a server as -server takes it, with its weight after "=" or whitespace; blank
+// lines and lines starting with "#" are skipped, as are servers already listed, which
+// keep their first weight.
+func appendServerLines(value string, lines []string) string {
+	var servers []string
+	listed := make(map[string]bool)
+	add := func(entry string) {
+		name, _, _ := cutWeight(entry)
+		if name = strings.TrimSpace(name); name != "" && !listed[name] {
+			listed[name] = true
+			servers = append(servers, entry)
+		}
+	}
+	for _, entry := range strings.Split(value, ",") {
+		add(entry)
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if fields := strings.Fields(line); len(fields) == 2 {
+			line = fields[0] + "=" + fields[1]
+		}
+		add(line)
+	}
+	return strings.Join(servers, ",")
+}
+
+// splitServers splits a comma-separated -server value into its servers and their
+// weights. A server is weighted with an "=weight" suffix, a positive integer; the weights
+// are nil when no server has one, and servers without one weigh 1 otherwise. The suffix
+// cannot be ":weight", since -server already takes host:port, and only a numeric suffix
+// counts, so the "=" in a DoH URL's query string stays part of the URL. A URL whose query
+// ends in a number needs an explicit weight, such as "?n=5=1".
+func splitServers(value string) ([]string, []int, error) {
+	var servers []string
+	var weights []int
+	weighted := false
+	for _, server := range strings.Split(value, ",") {
+		if server = strings.TrimSpace(server); server == "" {
+			continue
+		}
+		weight := 1
+		if name, suffix, ok := cutWeight(server); ok {
+			n, err := strconv.Atoi(suffix)
+			if err != nil || n <= 0 {
+				return nil, nil, fmt.Errorf("weight of %s is not a positive integer: %q", name, suffix)
+			}
+			server, weight, weighted = strings.TrimSpace(name), n, true
+		}
+		servers = append(servers, server)
+		weights = append(weights, weight)
+	}
+	if !weighted {
+		weights = nil
+	}
+	return servers, weights, nil
+}
+
+// cutWeight splits entry at its last "=" when what follows is an integer, returning the
+// server and the weight; ok is false when entry has no such suffix.
+func cutWeight(entry string) (server, weight string, ok bool) {
+	i := strings.LastIndex(entry, "=")
+	if i < 0 {
+		return entry, "", false
+	}
+	weight = strings.TrimSpace(entry[i+1:])
+	if !isDigits(strings.TrimPrefix(weight, "-")) {
+		return entry, "", false
+	}
+	return entry[:i], weight, true
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitServers(t *testing.T) {
+	const doh = "https://dns.example/dns-query?ct=application/dns-message"
+	tests := []struct {
+		value   string
+		servers []string
+		weights []int
+	}{
+		{"192.0.2.53, 192.0.2.54:5353", []string{"192.0.2.53", "192.0.2.54:5353"}, nil},
+		{"192.0.2.53=3,192.0.2.54", []string{"192.0.2.53", "192.0.2.54"}, []int{3, 1}},
+		{doh, []string{doh}, nil},
+		{doh + "=2,tls://dns.example", []string{doh, "tls://dns.example"}, []int{2, 1}},
+		{"https://dns.example/dns-query?n=5=1", []string{"https://dns.example/dns-query?n=5"}, []int{1}},
+		{"[2001:db8::53]:53=4", []string{"[2001:db8::53]:53"}, []int{4}},
+	}
+	for _, tt := range tests {
+		servers, weights, err := splitServers(tt.value)
+		if err != nil || !reflect.DeepEqual(servers, tt.servers) || !reflect.DeepEqual(weights, tt.weights) {
+			t.Errorf("splitServers(%q) = %q, %v, %v; want %q, %v", tt.value, servers, weights, err, tt.servers, tt.weights)
+		}
+	}
+	for _, value := range []string{"192.0.2.53=0", "192.0.2.53=-2"} {
+		if _, _, err := splitServers(value); err == nil {
+			t.Errorf("splitServers(%q) accepted a weight that is not positive", value)
+		}
+	}
+}
+
+func TestAppendServerLines(t *testing.T) {
+	const doh = "https://dns.example/dns-query?ct=application/dns-message"
+	got := appendServerLines(doh, []string{"# resolvers", doh + " 5", "192.0.2.53 2", "192.0.2.54", "192.0.2.53=9"})
+	if want := doh + ",192.0.2.53=2,192.0.2.54"; got != want {
+		t.Errorf("appendServerLines = %q, want %q", got, want)
+	}
+}
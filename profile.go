@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+)
+
+// stopProfiles stops CPU profiling and writes the heap profile, once profiling has been
+// started; it is safe to call more than once. os.Exit skips deferred calls, so fatal and
+// the exits with a scan's status call it too. An interrupted scan stops through its
+// context and exits the same way, after its output is closed.
+var stopProfiles = func() {}
+
+// startProfiling starts CPU profiling and the live pprof HTTP server as requested, and
+// sets stopProfiles to end them.
+func startProfiling(cpuProfile, memProfile, pprofAddr string) error {
+	if pprofAddr != "" {
+		go func() {
+			slog.Info("Serving pprof", "url", "http://"+pprofAddr+"/debug/pprof/")
+			if err := http.ListenAndServe(pprofAddr, nil); err != nil {
+				slog.Error("pprof server stopped", "error", err)
+			}
+		}()
+	}
+
+	var cpuFile *os.File
+	if cpuProfile != "" {
+		file, err := os.Create(cpuProfile)
+		if err != nil {
+			return fmt.Errorf("error creating CPU profile %s: %v", cpuProfile, err)
+		}
+		if err := pprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			return fmt.Errorf("error starting CPU profile: %v", err)
+		}
+		cpuFile = file
+	}
+
+	var once sync.Once
+	stopProfiles = func() {
+		once.Do(func() {
+			if cpuFile != nil {
+				pprof.StopCPUProfile()
+				cpuFile.Close()
+			}
+			if memProfile != "" {
+				if err := writeHeapProfile(memProfile); err != nil {
+					slog.Error("Failed to write memory profile", "error", err)
+				}
+			}
+		})
+	}
+	return nil
+}
+
+// writeHeapProfile writes a heap profile to the named file after forcing a garbage collection
+// so the profile reflects live memory.
+func writeHeapProfile(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("error creating memory profile %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(file); err != nil {
+		return fmt.Errorf("error writing memory profile %s: %v", filename, err)
+	}
+	return nil
+}
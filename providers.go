@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+)
+
+// providerCounter counts the vulnerable records written per matched pattern, for the
+// -list-providers summary.
+type providerCounter struct {
+	next recordWriter
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// newProviderCounter returns a providerCounter in front of next.
+func newProviderCounter(next recordWriter) *providerCounter {
+	return &providerCounter{next: next, counts: make(map[string]int)}
+}
+
+func (w *providerCounter) Write(record Record) error {
+	if record.IsVulnerable {
+		patterns := record.MatchedPatterns
+		if len(patterns) == 0 {
+			patterns = []string{record.MatchedPattern}
+		}
+		w.mu.Lock()
+		for _, pattern := range patterns {
+			w.counts[pattern]++
+		}
+		w.mu.Unlock()
+	}
+	return w.next.Write(record)
+}
+
+func (w *providerCounter) Close() error {
+	return w.next.Close()
+}
+
+// writeSummary writes the patterns that matched and their host counts, most hosts first.
+func (w *providerCounter) writeSummary(output io.Writer) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	patterns := make([]string, 0, len(w.counts))
+	for pattern := range w.counts {
+		patterns = append(patterns, pattern)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if w.counts[patterns[i]] != w.counts[patterns[j]] {
+			return w.counts[patterns[i]] > w.counts[patterns[j]]
+		}
+		return patterns[i] < patterns[j]
+	})
+
+	if len(patterns) == 0 {
+		_, err := fmt.Fprintln(output, "No patterns matched")
+		return err
+	}
+	tw := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOSTS\tPATTERN")
+	for _, pattern := range patterns {
+		fmt.Fprintf(tw, "%d\t%s\n", w.counts[pattern], pattern)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+)
+
+// publicSuffixData is the embedded Public Suffix List used to find registrable domains.
+//
+//go:embed public_suffix_list.dat
+var publicSuffixData string
+
+// suffixRules holds the parsed Public Suffix List: plain rules, wildcard rules keyed by
+// the suffix after "*.", and exception rules keyed without the leading "!".
+type suffixRules struct {
+	exact      map[string]bool
+	wildcards  map[string]bool
+	exceptions map[string]bool
+}
+
+var publicSuffixes = parseSuffixRules(publicSuffixData)
+
+// parseSuffixRules parses Public Suffix List data, ignoring comments and blank lines.
+func parseSuffixRules(data string) suffixRules {
+	rules := suffixRules{
+		exact:      make(map[string]bool),
+		wildcards:  make(map[string]bool),
+		exceptions: make(map[string]bool),
+	}
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "//") {
+			continue
+		}
+		rule := strings.ToLower(fields[0])
+		switch {
+		case strings.HasPrefix(rule, "!"):
+			rules.exceptions[rule[1:]] = true
+		case strings.HasPrefix(rule, "*."):
+			rules.wildcards[rule[2:]] = true
+		default:
+			rules.exact[rule] = true
+		}
+	}
+	return rules
+}
+
+// empty reports whether the list has no rules, as when the embedded data is missing, in
+// which case every name has the implicit "*" rule and no TLD is known.
+func (r suffixRules) empty() bool {
+	return len(r.exact) == 0 && len(r.wildcards) == 0 && len(r.exceptions) == 0
+}
+
+// publicSuffix returns the public suffix of a normalized domain using the list's
+// longest-match algorithm, with the implicit "*" rule when nothing matches. The list
+// writes IDN rules in Unicode, so xn-- labels are matched in their decoded form; the
+// suffix returned keeps the labels of domain.
+func (r suffixRules) publicSuffix(domain string) string {
+	labels := strings.Split(domain, ".")
+	keys := unicodeLabels(labels)
+	for i := range labels {
+		candidate := strings.Join(keys[i:], ".")
+		if r.exceptions[candidate] {
+			// An exception rule makes its own name registrable, so the suffix is its parent
+			return strings.Join(labels[i+1:], ".")
+		}
+		if r.exact[candidate] {
+			return strings.Join(labels[i:], ".")
+		}
+		if i+1 < len(labels) && r.wildcards[strings.Join(keys[i+1:], ".")] {
+			return strings.Join(labels[i:], ".")
+		}
+	}
+	return labels[len(labels)-1]
+}
+
+// unicodeLabels returns labels with each xn-- label decoded to Unicode, leaving labels
+// that are not valid A-labels as they are.
+func unicodeLabels(labels []string) []string {
+	decoded := make([]string, len(labels))
+	for i, label := range labels {
+		decoded[i] = label
+		if strings.HasPrefix(label, "xn--") {
+			if name, err := decodePunycode(label[len("xn--"):]); err == nil {
+				decoded[i] = name
+			}
+		}
+	}
+	return decoded
+}
+
+// registrableDomain returns the registrable domain (public suffix plus one label) of a
+// host name, e.g. "example.co.uk" for "www.example.co.uk". It fails for names that are
+// themselves public suffixes.
+func registrableDomain(host string) (string, error) {
+	domain := normalizeHost(host)
+	if domain == "" || strings.Contains(domain, "..") {
+		return "", fmt.Errorf("invalid domain %q", host)
+	}
+
+	suffix := publicSuffixes.publicSuffix(domain)
+	if domain == suffix {
+		return "", fmt.Errorf("%s is a public suffix", domain)
+	}
+
+	rest := strings.TrimSuffix(domain, "."+suffix)
+	if i := strings.LastIndex(rest, "."); i >= 0 {
+		rest = rest[i+1:]
+	}
+	return rest + "." + suffix, nil
+}
+
+// registrableOrEmpty returns the registrable domain of host. When the Public Suffix List
+// cannot give one, as for a name that is itself a public suffix like s3.amazonaws.com,
+// it falls back to the last two labels, so the host is still grouped with its zone; it
+// is "" only for names with fewer than two labels.
+func registrableOrEmpty(host string) string {
+	domain, err := registrableDomain(host)
+	if err == nil {
+		return domain
+	}
+	domain = lastTwoLabels(host)
+	slog.Debug("Guessing registrable domain from the last two labels", "host", host, "domain", domain, "reason", err)
+	return domain
+}
+
+// lastTwoLabels returns the last two non-empty labels of host, or "" if it has fewer.
+func lastTwoLabels(host string) string {
+	var labels []string
+	for _, label := range strings.Split(normalizeHost(host), ".") {
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+	if len(labels) < 2 {
+		return ""
+	}
+	return strings.Join(labels[len(labels)-2:], ".")
+}
+
+// knownTLD reports whether the last label of host is a top-level domain on the Public
+// Suffix List, whose ICANN section lists every delegated TLD. The list spells IDN TLDs as
+// U-labels, so an xn-- (A-label) TLD is decoded before the lookup.
+func (r suffixRules) knownTLD(host string) bool {
+	domain := normalizeHost(host)
+	tld := domain[strings.LastIndex(domain, ".")+1:]
+	if strings.HasPrefix(tld, "xn--") {
+		decoded, err := decodePunycode(tld[len("xn--"):])
+		if err != nil {
+			return false
+		}
+		tld = decoded
+	}
+	return tld != "" && (r.exact[tld] || r.wildcards[tld])
+}
+
+// checkScanHost reports why host cannot be scanned under -strict-tld: it is not a valid
+// host name, or its TLD is not known. IP addresses, which -ptr scans take as input, pass,
+// and TLDs are not checked when the Public Suffix List is empty.
+func checkScanHost(host string) error {
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	if err := checkHostname(host); err != nil {
+		return err
+	}
+	if !publicSuffixes.empty() && !publicSuffixes.knownTLD(host) {
+		return errors.New("unknown TLD")
+	}
+	return nil
+}
+
+// filterUnknownTLDs drops the subdomains that are not valid host names or whose TLD is not
+// on the embedded Public Suffix List, logging each as invalid, and returns the rest with
+// the number dropped.
+func filterUnknownTLDs(subdomains []string) ([]string, int) {
+	kept := make([]string, 0, len(subdomains))
+	invalid := 0
+	for _, subdomain := range subdomains {
+		if err := checkScanHost(subdomain); err != nil {
+			invalid++
+			slog.Warn("Skipping invalid host", "host", subdomain, "reason", err)
+			continue
+		}
+		kept = append(kept, subdomain)
+	}
+	return kept, invalid
+}
+
+// decodePunycode decodes the part of an A-label after "xn--" (RFC 3492).
+func decodePunycode(encoded string) (string, error) {
+	const (
+		base        = 36
+		tMin        = 1
+		tMax        = 26
+		skew        = 38
+		damp        = 700
+		initialBias = 72
+		initialN    = 128
+	)
+	adapt := func(delta, points int, first bool) int {
+		if first {
+			delta /= damp
+		} else {
+			delta /= 2
+		}
+		delta += delta / points
+		k := 0
+		for delta > ((base-tMin)*tMax)/2 {
+			delta /= base - tMin
+			k += base
+		}
+		return k + (base-tMin+1)*delta/(delta+skew)
+	}
+
+	var output []rune
+	if i := strings.LastIndexByte(encoded, '-'); i >= 0 {
+		for _, c := range encoded[:i] {
+			if c >= 0x80 {
+				return "", fmt.Errorf("invalid punycode %q", encoded)
+			}
+			output = append(output, c)
+		}
+		encoded = encoded[i+1:]
+	}
+
+	n, bias, i := initialN, initialBias, 0
+	for pos := 0; pos < len(encoded); {
+		oldI, w := i, 1
+		for k := base; ; k += base {
+			if pos >= len(encoded) {
+				return "", fmt.Errorf("invalid punycode %q", encoded)
+			}
+			c := encoded[pos]
+			pos++
+			var digit int
+			switch {
+			case c >= 'a' && c <= 'z':
+				digit = int(c - 'a')
+			case c >= 'A' && c <= 'Z':
+				digit = int(c - 'A')
+			case c >= '0' && c <= '9':
+				digit = int(c-'0') + 26
+			default:
+				return "", fmt.Errorf("invalid punycode %q", encoded)
+			}
+			if digit > (1<<30-i)/w {
+				return "", fmt.Errorf("punycode %q overflows", encoded)
+			}
+			i += digit * w
+			t := k - bias
+			if t < tMin {
+				t = tMin
+			} else if t > tMax {
+				t = tMax
+			}
+			if digit < t {
+				break
+			}
+			w *= base - t
+		}
+		bias = adapt(i-oldI, len(output)+1, oldI == 0)
+		n += i / (len(output) + 1)
+		if n > 0x10FFFF {
+			return "", fmt.Errorf("punycode %q overflows", encoded)
+		}
+		i %= len(output) + 1
+		output = append(output[:i], append([]rune{rune(n)}, output[i:]...)...)
+		i++
+	}
+	return string(output), nil
+}
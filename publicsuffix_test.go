@@ -0,0 +1,94 @@
+package main
+
+import "testing"
+
+func TestRegistrableDomain(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"www.example.com", "example.com"},
+		{"WWW.Example.COM.", "example.com"},
+		{"a.b.example.co.uk", "example.co.uk"},
+		{"shop.acme.agency", "acme.agency"},
+		{"b.microsoft", "b.microsoft"},
+		{"x.y.foo.kawasaki.jp", "y.foo.kawasaki.jp"}, // wildcard rule
+		{"www.city.kawasaki.jp", "city.kawasaki.jp"}, // exception rule
+		{"bucket.s3.amazonaws.com", "bucket.s3.amazonaws.com"},
+		{"app.herokuapp.com", "app.herokuapp.com"},
+		{"user.github.io", "user.github.io"},
+		{"a.shop.xn--55qx5d.cn", "shop.xn--55qx5d.cn"}, // 公司.cn, matched through its A-label
+		{"a.b.xn--p1ai", "b.xn--p1ai"},                 // .рф
+		{"host.unlisted-tld", "host.unlisted-tld"},     // implicit "*" rule
+	}
+	for _, tt := range tests {
+		got, err := registrableDomain(tt.host)
+		if err != nil || got != tt.want {
+			t.Errorf("registrableDomain(%q) = %q, %v; want %q", tt.host, got, err, tt.want)
+		}
+	}
+}
+
+func TestRegistrableDomainPublicSuffix(t *testing.T) {
+	for _, host := range []string{"co.uk", "s3.amazonaws.com", "com", "xn--p1ai", "", "a..com"} {
+		if got, err := registrableDomain(host); err == nil {
+			t.Errorf("registrableDomain(%q) = %q, want an error", host, got)
+		}
+	}
+}
+
+func TestCheckScanHost(t *testing.T) {
+	for _, host := range []string{"www.acme.agency", "b.microsoft", "a.xn--p1ai", "shop.xn--55qx5d.cn", "www.example.co.uk", "WWW.EXAMPLE.COM.", "192.0.2.1", "2001:db8::1"} {
+		if err := checkScanHost(host); err != nil {
+			t.Errorf("checkScanHost(%q) = %v, want nil", host, err)
+		}
+	}
+	for _, host := range []string{"host.unlisted-tld", "a.xn--zzzzzzz", "a.xn--", "bad_host!.com", ""} {
+		if err := checkScanHost(host); err == nil {
+			t.Errorf("checkScanHost(%q) = nil, want an error", host)
+		}
+	}
+}
+
+func TestRegistrableOrEmpty(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"www.example.co.uk", "example.co.uk"},
+		// Public suffixes themselves fall back to their last two labels
+		{"co.uk", "co.uk"},
+		{"s3.amazonaws.com", "amazonaws.com"},
+		{"com", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := registrableOrEmpty(tt.host); got != tt.want {
+			t.Errorf("registrableOrEmpty(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestRegistrableOrEmptyWithoutList(t *testing.T) {
+	saved := publicSuffixes
+	publicSuffixes = parseSuffixRules("")
+	defer func() { publicSuffixes = saved }()
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		// Only the implicit "*" rule is left, so every name groups by its last two labels
+		{"a.b.example.co.uk", "co.uk"},
+		{"bucket.s3.amazonaws.com", "amazonaws.com"},
+		{"WWW.Example.COM.", "example.com"},
+		{"example.com", "example.com"},
+		{"a..example.com", "example.com"},
+		{"localhost", ""},
+	}
+	for _, tt := range tests {
+		if got := registrableOrEmpty(tt.host); got != tt.want {
+			t.Errorf("registrableOrEmpty(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand/v2"
+	"sync"
+)
+
+// runRand is the one source of randomness of a run for everything that only needs to
+// look random: lookup jitter and confirmation sampling. It is seeded from
+// -seed, so with a fixed seed and the replay backend a run repeats exactly (with
+// -concurrency 1 the output order does too). Randomness that guards against attackers,
+// such as DNS query IDs, encryption salts and redaction tokens, never comes from it.
+// It is safe for concurrent use.
+type runRand struct {
+	seed uint64
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// newRunRand returns a runRand seeded with seed, or with a random seed when it is zero.
+func newRunRand(seed uint64) *runRand {
+	for seed == 0 {
+		seed = rand.Uint64()
+	}
+	return &runRand{seed: seed, rng: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// Seed returns the seed, so a run with a random seed can be repeated.
+func (r *runRand) Seed() uint64 {
+	return r.seed
+}
+
+// Int64N returns a random value in [0, n).
+func (r *runRand) Int64N(n int64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rng.Int64N(n)
+}
+
+// derive returns a seed for the feature called name. Derived seeds depend only on the
+// run's seed and the name, so turning one feature on does not change another's choices.
+func (r *runRand) derive(name string) uint64 {
+	h := fnv.New64a()
+	var seed [8]byte
+	binary.BigEndian.PutUint64(seed[:], r.seed)
+	h.Write(seed[:])
+	h.Write([]byte(name))
+	if sum := h.Sum64(); sum != 0 {
+		return sum
+	}
+	return 1 // zero means "pick a random seed" to the features
+}
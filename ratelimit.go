@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// autoRateStart is the lookups per second -auto-rate starts from when no -rate is given.
+	autoRateStart = 10
+	// autoRateCeiling bounds -auto-rate when no -rate is given.
+	autoRateCeiling = 10000
+	// autoRateFloor is the least rate -auto-rate backs off to.
+	autoRateFloor = 1
+	// autoRateStep is the additive increase per healthy window.
+	autoRateStep = 10
+	// autoRateMinWindow is the least number of lookups a window judges the rate on.
+	autoRateMinWindow = 20
+	// autoRateHealthy is the error rate at or below which the rate grows, and
+	// autoRateBackoff the one above which it is halved.
+	autoRateHealthy = 0.01
+	autoRateBackoff = 0.05
+)
+
+// rateLimiter spaces lookups evenly at a rate per second. With auto set it adjusts the
+// rate AIMD-style from the outcome of the lookups: over each window of about a second
+// of lookups, an error rate above autoRateBackoff halves the rate, and one at or below
+// autoRateHealthy adds autoRateStep, but only when the limiter held lookups back during
+// the window, so the rate does not climb past what the scan's concurrency reaches.
+type rateLimiter struct {
+	auto     bool
+	min, max float64
+
+	mu   sync.Mutex
+	rate float64
+	next time.Time
+
+	samples   int
+	failures  int
+	throttled bool
+}
+
+// newRateLimiter returns a limiter allowing rate lookups per second. With auto unset it
+// keeps that rate; with auto set it starts from rate, or from autoRateStart when rate is
+// zero, and never exceeds rate, or autoRateCeiling when rate is zero.
+func newRateLimiter(rate float64, auto bool) *rateLimiter {
+	l := &rateLimiter{auto: auto, rate: rate, min: autoRateFloor, max: rate}
+	if auto && rate <= 0 {
+		l.rate, l.max = autoRateStart, autoRateCeiling
+	}
+	return l
+}
+
+// wait blocks until the next lookup may start or ctx is done; it returns immediately on
+// a nil limiter.
+func (l *rateLimiter) wait(ctx context.Context) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(time.Second) / l.rate))
+	if delay > 0 {
+		l.throttled = true
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// observe feeds the outcome of a lookup into an auto limiter.
+func (l *rateLimiter) observe(failed bool) {
+	if l == nil || !l.auto {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.samples++
+	if failed {
+		l.failures++
+	}
+	if l.samples < max(autoRateMinWindow, int(l.rate)) {
+		return
+	}
+
+	errorRate := float64(l.failures) / float64(l.samples)
+	previous := l.rate
+	switch {
+	case errorRate > autoRateBackoff:
+		l.rate = max(l.min, l.rate/2)
+	case errorRate <= autoRateHealthy && l.throttled:
+		l.rate = min(l.max, l.rate+autoRateStep)
+	}
+	if l.rate != previous {
+		slog.Debug("Adjusted lookup rate", "rate", math.Round(l.rate), "error_rate", errorRate)
+	}
+	l.samples, l.failures, l.throttled = 0, 0, false
+}
+
+// current returns the lookups per second the limiter allows.
+func (l *rateLimiter) current() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// logSettledRate reports the rate an auto limiter ended the scan at.
+func logSettledRate(l *rateLimiter) {
+	if l != nil && l.auto {
+		slog.Info("Settled on lookup rate", "rate", math.Round(l.current()))
+	}
+}
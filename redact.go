@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// redactedPrefix starts every redaction token.
+const redactedPrefix = "redacted-"
+
+// redactor replaces host names with random tokens and remembers the mapping, so results
+// can be shared externally and un-redacted internally. Tokens are stable: a name already
+// in the mapping file keeps its token across runs.
+type redactor struct {
+	mapFile string
+	tokens  map[string]string // normalized name -> token
+	changed bool
+}
+
+// newRedactor loads the mapping file if it exists. A missing file starts a new mapping.
+func newRedactor(mapFile string) (*redactor, error) {
+	r := &redactor{mapFile: mapFile, tokens: make(map[string]string)}
+
+	data, err := os.ReadFile(mapFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening file %s: %v", mapFile, err)
+	}
+
+	rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error reading redaction map %s: %v", mapFile, err)
+	}
+	for i, row := range rows {
+		if i == 0 && len(row) == 2 && row[0] == "token" {
+			continue // header
+		}
+		if len(row) != 2 || !strings.HasPrefix(row[0], redactedPrefix) {
+			return nil, fmt.Errorf("invalid redaction map %s: line %d is not a \"token,name\" row", mapFile, i+1)
+		}
+		r.tokens[row[1]] = row[0]
+	}
+	return r, nil
+}
+
+// token returns the redaction token for a host name, assigning a new random one on first
+// use. Empty values and the "No CNAME record" sentinel are kept as they are.
+func (r *redactor) token(name string) (string, error) {
+	key := normalizeHost(name)
+	if key == "" || name == "No CNAME record" || name == "No PTR record" {
+		return name, nil
+	}
+	if token, ok := r.tokens[key]; ok {
+		return token, nil
+	}
+
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	token := redactedPrefix + hex.EncodeToString(b[:])
+	r.tokens[key] = token
+	r.changed = true
+	return token, nil
+}
+
+// lines redacts each line of a newline-separated list of names.
+func (r *redactor) lines(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	names := strings.Split(value, "\n")
+	for i, name := range names {
+		token, err := r.token(name)
+		if err != nil {
+			return "", err
+		}
+		names[i] = token
+	}
+	return strings.Join(names, "\n"), nil
+}
+
+// save writes the mapping file if new tokens were assigned.
+func (r *redactor) save() error {
+	if !r.changed {
+		return nil
+	}
+
+	names := make([]string, 0, len(r.tokens))
+	for name := range r.tokens {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write([]string{"token", "name"})
+	for _, name := range names {
+		w.Write([]string{r.tokens[name], name})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(r.mapFile, b.Bytes()); err != nil {
+		return fmt.Errorf("error writing redaction map %s: %v", r.mapFile, err)
+	}
+	r.changed = false
+	return nil
+}
+
+// redactingWriter redacts the subdomain fields of records, and with cnames also their
+// CNAME targets, before passing them on. Matched patterns, severity and the verdict are
+// left intact. Closing it saves the mapping file.
+type redactingWriter struct {
+	next     recordWriter
+	redactor *redactor
+	cnames   bool
+}
+
+func (w *redactingWriter) Write(record Record) error {
+	var err error
+	redact := func(value string) string {
+		if err != nil {
+			return value
+		}
+		var redacted string
+		redacted, err = w.redactor.lines(value)
+		return redacted
+	}
+
+	subdomain := record.Subdomain
+	record.Subdomain = redact(record.Subdomain)
+	record.SubdomainRegistrable = redact(record.SubdomainRegistrable)
+	record.PTR = redact(record.PTR)
+	if w.cnames {
+		record.CNAME = redact(record.CNAME)
+		record.CNAMERegistrable = redact(record.CNAMERegistrable)
+		record.AuthorityAnswer = redact(record.AuthorityAnswer)
+		if len(record.Chain) > 0 {
+			chain := make([]string, len(record.Chain))
+			for i, hop := range record.Chain {
+				chain[i] = redact(hop)
+			}
+			record.Chain = chain
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error redacting record: %v", err)
+	}
+
+	// Error messages usually repeat the host name
+	record.Error = strings.ReplaceAll(record.Error, subdomain, record.Subdomain)
+	return w.next.Write(record)
+}
+
+func (w *redactingWriter) Close() error {
+	err := w.next.Close()
+	if saveErr := w.redactor.save(); err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+// unredact replaces every redaction token found in r with its original name using the
+// mapping file, writing the result to w. It works on any output format.
+func unredact(r io.Reader, w io.Writer, mapFile string) error {
+	redactor, err := newRedactor(mapFile)
+	if err != nil {
+		return err
+	}
+	pairs := make([]string, 0, 2*len(redactor.tokens))
+	for name, token := range redactor.tokens {
+		pairs = append(pairs, token, name)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, strings.NewReplacer(pairs...).Replace(string(data)))
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// recordCollector is a recordWriter keeping what is written to it.
+type recordCollector struct {
+	records []Record
+	closed  bool
+}
+
+func (c *recordCollector) Write(record Record) error {
+	c.records = append(c.records, record)
+	return nil
+}
+
+func (c *recordCollector) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestRedactAndUnredact(t *testing.T) {
+	mapFile := filepath.Join(t.TempDir(), "redact.csv")
+	redactor, err := newRedactor(mapFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := &recordCollector{}
+	w := &redactingWriter{next: next, redactor: redactor}
+	records := []Record{
+		{Subdomain: "Shop.Example.com", CNAME: "gone.herokuapp.com", IsVulnerable: true, MatchedPattern: "herokuapp.com", Error: "lookup Shop.Example.com: timeout"},
+		{Subdomain: "shop.example.com.", CNAME: "No CNAME record"},
+		{Subdomain: "api.example.com", CNAME: "api.example.net"},
+	}
+	for _, record := range records {
+		if err := w.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !next.closed {
+		t.Error("Close did not close the next writer")
+	}
+
+	shop, api := next.records[0], next.records[2]
+	if !strings.HasPrefix(shop.Subdomain, redactedPrefix) || shop.Subdomain == api.Subdomain {
+		t.Errorf("subdomains redacted to %q and %q, want distinct tokens", shop.Subdomain, api.Subdomain)
+	}
+	if next.records[1].Subdomain != shop.Subdomain {
+		t.Errorf("spellings of one name redacted to %q and %q, want one token", shop.Subdomain, next.records[1].Subdomain)
+	}
+	if shop.CNAME != "gone.herokuapp.com" || shop.MatchedPattern != "herokuapp.com" || !shop.IsVulnerable {
+		t.Errorf("redacted record %+v lost its CNAME, pattern or verdict", shop)
+	}
+	if want := "lookup " + shop.Subdomain + ": timeout"; shop.Error != want {
+		t.Errorf("Error = %q, want %q", shop.Error, want)
+	}
+
+	// The saved mapping gives the same tokens in a later run and reverses them
+	again, err := newRedactor(mapFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := again.token("API.example.com"); token != api.Subdomain {
+		t.Errorf("token after reloading = %q, want %q", token, api.Subdomain)
+	}
+	if again.changed {
+		t.Error("reloaded redactor assigned a new token for a known name")
+	}
+
+	var out strings.Builder
+	in := "Subdomain: " + shop.Subdomain + ", gone.herokuapp.com\n" + api.Subdomain + "," + api.Subdomain + "\n"
+	if err := unredact(strings.NewReader(in), &out, mapFile); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Subdomain: shop.example.com, gone.herokuapp.com\napi.example.com,api.example.com\n"; out.String() != want {
+		t.Errorf("unredact = %q, want %q", out.String(), want)
+	}
+}
+
+func TestRedactCNAMEs(t *testing.T) {
+	redactor, err := newRedactor(filepath.Join(t.TempDir(), "redact.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := &recordCollector{}
+	w := &redactingWriter{next: next, redactor: redactor, cnames: true}
+	records := []Record{
+		{Subdomain: "shop.example.com", CNAME: "edge.example.net", Chain: []string{"shop.example.com", "edge.example.net"}},
+		{Subdomain: "www.example.com", CNAME: "No CNAME record"},
+	}
+	for _, record := range records {
+		if err := w.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	record := next.records[0]
+	if !strings.HasPrefix(record.CNAME, redactedPrefix) {
+		t.Errorf("CNAME = %q, want a token", record.CNAME)
+	}
+	if len(record.Chain) != 2 || record.Chain[0] != record.Subdomain || record.Chain[1] != record.CNAME {
+		t.Errorf("Chain = %q, want the tokens of %q and %q", record.Chain, record.Subdomain, record.CNAME)
+	}
+	if records[0].Chain[0] != "shop.example.com" {
+		t.Error("redacting changed the caller's chain")
+	}
+	if got := next.records[1].CNAME; got != "No CNAME record" {
+		t.Errorf("CNAME = %q, want the sentinel kept", got)
+	}
+}
+
+func TestNewRedactorRejectsBadMap(t *testing.T) {
+	mapFile := filepath.Join(t.TempDir(), "redact.csv")
+	if err := os.WriteFile(mapFile, []byte("token,name\nshop.example.com,redacted-00\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newRedactor(mapFile); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("newRedactor error = %v, want line 2 rejected", err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// replayResolver answers lookups from pre-captured dig output instead of the network. The
+// capture for a host lives in <dir>/<host>.txt and may be either dig +short output or full
+// dig output with an answer section. Replaying the same directory always gives the same
+// answers, which makes bug reports reproducible and CI runs deterministic.
+type replayResolver struct {
+	dir string
+}
+
+// LookupCNAME returns the CNAME records captured for name. Full dig output only yields
+// the CNAMEs owned by name itself, not later hops of the chain.
+func (r *replayResolver) LookupCNAME(ctx context.Context, name string) (Answer, error) {
+	if err := ctx.Err(); err != nil {
+		return Answer{}, err
+	}
+	records, short, authoritative, err := r.replay(name)
+	if err != nil {
+		return Answer{}, err
+	}
+	if records == nil {
+		return Answer{CNAMEs: short, Authoritative: authoritative}, nil
+	}
+	return Answer{CNAMEs: cnameTargets(name, records), Authoritative: authoritative, Records: records}, nil
+}
+
+// LookupPTR returns the PTR records captured for an IP address.
+func (r *replayResolver) LookupPTR(ctx context.Context, ip string) (Answer, error) {
+	if err := ctx.Err(); err != nil {
+		return Answer{}, err
+	}
+	records, short, authoritative, err := r.replay(ip)
+	if err != nil {
+		return Answer{}, err
+	}
+	if records == nil {
+		return Answer{PTRs: short, Authoritative: authoritative}, nil
+	}
+	var names []string
+	for _, rr := range records {
+		if rr.Type == "PTR" {
+			names = append(names, rr.Value)
+		}
+	}
+	return Answer{PTRs: names, Authoritative: authoritative, Records: records}, nil
+}
+
+// LookupAddrs returns the A (or else AAAA) addresses found in the capture for name. A
+// capture of a plain "dig <host>" query holds the whole chain down to the addresses; a
+// capture without address records replays as a name that does not resolve.
+func (r *replayResolver) LookupAddrs(ctx context.Context, name string) (Answer, error) {
+	if err := ctx.Err(); err != nil {
+		return Answer{}, err
+	}
+	records, short, authoritative, err := r.replay(name)
+	if err != nil {
+		return Answer{}, err
+	}
+	if records == nil {
+		if addrs := onlyAddresses(short); len(addrs) > 0 {
+			return Answer{Addrs: addrs, Authoritative: authoritative}, nil
+		}
+		return Answer{}, nil
+	}
+	for _, rrType := range []string{"A", "AAAA"} {
+		if addrs := terminalValues(name, records, rrType); len(addrs) > 0 {
+			return Answer{Addrs: addrs, Authoritative: authoritative, Records: records}, nil
+		}
+	}
+	return Answer{}, nil
+}
+
+// onlyAddresses keeps the IP addresses in values, dropping the names that +short output
+// lists for CNAME hops.
+func onlyAddresses(values []string) []string {
+	var addrs []string
+	for _, value := range values {
+		if net.ParseIP(value) != nil {
+			addrs = append(addrs, value)
+		}
+	}
+	return addrs
+}
+
+// replay reads the capture for name. Full dig output is returned as typed records and
+// +short output as its values; full output whose status is an error such as SERVFAIL
+// replays as that error, as the dig backend would have reported it.
+func (r *replayResolver) replay(name string) ([]ResourceRecord, []string, bool, error) {
+	host := normalizeHost(name)
+	if host == "" || strings.ContainsAny(host, `/\`) || strings.Contains(host, "..") {
+		return nil, nil, false, fmt.Errorf("cannot replay %q: not a valid capture name", name)
+	}
+
+	filename := filepath.Join(r.dir, host+".txt")
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("error reading replay capture for %s: %v", name, err)
+	}
+
+	if err := digStatusError(string(data), "", name); err != nil {
+		return nil, nil, false, err
+	}
+	records, short, authoritative := parseDigCapture(string(data))
+	return records, short, authoritative, nil
+}
+
+// parseDigCapture parses captured dig output. Full output (with comment lines or answer
+// records) is parsed like +noall +answer output into records; anything else is treated as
+// +short output with one value per line. Full output without any records gives an empty,
+// non-nil record list.
+func parseDigCapture(output string) ([]ResourceRecord, []string, bool) {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, ";") || len(strings.Fields(line)) >= 5 {
+			records, authoritative := parseDigRecords(output)
+			if records == nil {
+				records = []ResourceRecord{}
+			}
+			return records, nil, authoritative
+		}
+	}
+	return nil, strings.Fields(output), false
+}
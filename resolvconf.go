@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// systemResolvConf is the resolver configuration read by -resolver-from-system.
+const systemResolvConf = "/etc/resolv.conf"
+
+// Defaults for the resolv.conf options, as in resolv.conf(5).
+const (
+	defaultNdots         = 1
+	defaultResolvTimeout = 5 * time.Second
+	defaultAttempts      = 2
+	// maxNdots is the highest ndots the C library accepts.
+	maxNdots = 15
+)
+
+// resolvConf is the part of a resolv.conf file that affects lookups.
+type resolvConf struct {
+	// Nameservers are the servers in file order, without ports.
+	Nameservers []string
+	// Search lists the domains tried for names with fewer than Ndots dots. A domain line
+	// counts as a one-entry search list.
+	Search []string
+	Ndots  int
+	// Timeout is how long to wait for one server and Attempts how often every server is
+	// tried, from the timeout: and attempts: options.
+	Timeout  time.Duration
+	Attempts int
+}
+
+// parseResolvConf reads a resolv.conf file. The last search or domain line wins, as in
+// the C library; unknown keywords and options, rotate among them, are ignored, since
+// several nameservers always share the lookups through a resolverPool.
+func parseResolvConf(path string) (resolvConf, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return resolvConf{}, fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	conf := resolvConf{Ndots: defaultNdots, Timeout: defaultResolvTimeout, Attempts: defaultAttempts}
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			conf.Nameservers = append(conf.Nameservers, fields[1])
+		case "domain":
+			conf.Search = []string{strings.TrimSuffix(fields[1], ".")}
+		case "search":
+			conf.Search = conf.Search[:0]
+			for _, domain := range fields[1:] {
+				if domain = strings.TrimSuffix(domain, "."); domain != "" {
+					conf.Search = append(conf.Search, domain)
+				}
+			}
+		case "options":
+			for _, option := range fields[1:] {
+				name, value, _ := strings.Cut(option, ":")
+				n, err := strconv.Atoi(value)
+				switch {
+				case name == "ndots" && err == nil && n >= 0:
+					conf.Ndots = min(n, maxNdots)
+				case name == "timeout" && err == nil && n > 0:
+					conf.Timeout = time.Duration(n) * time.Second
+				case name == "attempts" && err == nil && n > 0:
+					conf.Attempts = n
+				}
+			}
+		}
+	}
+	return conf, nil
+}
+
+// expand returns the names to scan for host: host itself when it has at least Ndots
+// dots, is fully qualified or is an IP address, and otherwise host under each search
+// domain in order, followed by host itself unless it is a single label, which would be
+// looked up as a top-level domain.
+func (c resolvConf) expand(host string) []string {
+	if len(c.Search) == 0 || strings.HasSuffix(host, ".") || net.ParseIP(host) != nil || strings.Count(host, ".") >= c.Ndots {
+		return []string{host}
+	}
+	names := make([]string, 0, len(c.Search)+1)
+	for _, domain := range c.Search {
+		names = append(names, host+"."+domain)
+	}
+	if strings.Contains(host, ".") {
+		names = append(names, host)
+	}
+	return names
+}
+
+// expandAll applies expand to every host.
+func (c resolvConf) expandAll(hosts []string) []string {
+	var names []string
+	for _, host := range hosts {
+		names = append(names, c.expand(host)...)
+	}
+	return names
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeResolvConf writes data to a resolv.conf file in a temporary directory.
+func writeResolvConf(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseResolvConf(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want resolvConf
+	}{
+		{
+			"defaults",
+			"nameserver 192.0.2.53\n",
+			resolvConf{Nameservers: []string{"192.0.2.53"}, Ndots: 1, Timeout: 5 * time.Second, Attempts: 2},
+		},
+		{
+			"full",
+			"# generated by NetworkManager\n" +
+				"nameserver 192.0.2.53\n" +
+				"nameserver 2001:db8::53 ; secondary\n" +
+				"nameserver\n" +
+				"search corp.example.com. example.com\n" +
+				"options ndots:2 timeout:3 attempts:4 rotate edns0\n",
+			resolvConf{Nameservers: []string{"192.0.2.53", "2001:db8::53"}, Search: []string{"corp.example.com", "example.com"}, Ndots: 2, Timeout: 3 * time.Second, Attempts: 4},
+		},
+		{
+			"last search line wins",
+			"domain example.org\nsearch a.example b.example\ndomain example.net.\n",
+			resolvConf{Search: []string{"example.net"}, Ndots: 1, Timeout: 5 * time.Second, Attempts: 2},
+		},
+		{
+			"bad options keep the defaults",
+			"options ndots:-1 timeout:0 attempts:x\noptions ndots:40\n",
+			resolvConf{Ndots: maxNdots, Timeout: 5 * time.Second, Attempts: 2},
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseResolvConf(writeResolvConf(t, tt.data))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(got.Search) == 0 {
+			got.Search = nil
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseResolvConf = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseResolvConfMissingFile(t *testing.T) {
+	if _, err := parseResolvConf(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("parseResolvConf read a file that does not exist")
+	}
+}
+
+func TestResolvConfExpand(t *testing.T) {
+	conf := resolvConf{Search: []string{"corp.example.com", "example.com"}, Ndots: 2}
+	tests := []struct {
+		host string
+		want []string
+	}{
+		{"intranet", []string{"intranet.corp.example.com", "intranet.example.com"}},
+		{"www.shop", []string{"www.shop.corp.example.com", "www.shop.example.com", "www.shop"}},
+		{"a.b.example.net", []string{"a.b.example.net"}},
+		{"intranet.", []string{"intranet."}},
+		{"192.0.2.1", []string{"192.0.2.1"}},
+	}
+	for _, tt := range tests {
+		if got := conf.expand(tt.host); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expand(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+	if got := (resolvConf{Ndots: 1}).expand("intranet"); !reflect.DeepEqual(got, []string{"intranet"}) {
+		t.Errorf("expand without a search list = %q, want the host alone", got)
+	}
+}
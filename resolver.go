@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Answer is the result of looking up the CNAME record of a single name.
+type Answer struct {
+	// CNAMEs holds the CNAME targets in the answer; it is empty when the name has no CNAME.
+	CNAMEs []string
+	// PTRs holds the names of a reverse (PTR) lookup.
+	PTRs []string
+	// Addrs holds the A and AAAA addresses the name finally resolves to.
+	Addrs []string
+	// Authoritative reports whether the answer carried the authoritative-answer flag.
+	Authoritative bool
+	// AnsweredBy is the address of the nameserver that answered, when the backend knows it.
+	AnsweredBy string
+	// CrossChecked reports whether the lookup was repeated against the zone's
+	// authoritative nameservers, whose CNAME (or PTR) targets are then held in Authority.
+	CrossChecked bool
+	Authority    []string
+	// Records holds the typed answer section when the backend has one, which for CNAME
+	// lookups may include further hops and the terminal address records. Their TTLs
+	// bound how long the answer is cached.
+	Records []ResourceRecord
+	// NXDomain reports that the name does not exist. Authorities holds the authority
+	// section of an address lookup, where a nonexistent name's zone puts its SOA.
+	NXDomain    bool
+	Authorities []ResourceRecord
+	// RCode is the response code of the query, such as NOERROR or NXDOMAIN; empty when the
+	// backend does not report it.
+	RCode string
+}
+
+// rcodeError is a response whose code is neither NOERROR nor NXDOMAIN, such as SERVFAIL,
+// which fails the lookup. It keeps the code so the failed record can still report it.
+type rcodeError struct {
+	server string
+	name   string
+	rcode  string
+}
+
+func (e *rcodeError) Error() string {
+	if e.server == "" {
+		return fmt.Sprintf("error querying %s: server returned %s", e.name, e.rcode)
+	}
+	return fmt.Sprintf("error querying %s for %s: server returned %s", e.server, e.name, e.rcode)
+}
+
+// cnameTargets returns the targets of the CNAME records of name among records. Records
+// owned by other names, such as later hops of a chain, are left out.
+func cnameTargets(name string, records []ResourceRecord) []string {
+	var targets []string
+	for _, rr := range records {
+		if rr.Type == "CNAME" && normalizeHost(rr.Name) == normalizeHost(name) {
+			targets = append(targets, rr.Value)
+		}
+	}
+	return targets
+}
+
+// soaMinimum returns the minimum field of the first SOA record among records, which
+// bounds how long resolvers cache a name error from its zone (RFC 2308), or zero when
+// there is no SOA.
+func soaMinimum(records []ResourceRecord) uint32 {
+	for _, rr := range records {
+		if rr.Type != "SOA" {
+			continue
+		}
+		fields := strings.Fields(rr.Value)
+		if len(fields) != 7 {
+			continue
+		}
+		if minimum, err := strconv.ParseUint(fields[6], 10, 32); err == nil {
+			return uint32(minimum)
+		}
+	}
+	return 0
+}
+
+// dnameTarget returns the target synthesized for name by the closest DNAME record among
+// records owned by an ancestor of name. A DNAME redirects the whole subtree below its
+// owner (RFC 6672): with "old.example DNAME new.example", x.old.example becomes
+// x.new.example.
+func dnameTarget(name string, records []ResourceRecord) (string, bool) {
+	host := normalizeHost(name)
+	best := ""
+	target := ""
+	for _, rr := range records {
+		owner := normalizeHost(rr.Name)
+		if rr.Type != "DNAME" || !strings.HasSuffix(host, "."+owner) || len(owner) <= len(best) {
+			continue
+		}
+		best = owner
+		target = strings.TrimSuffix(host, owner) + strings.TrimSuffix(normalizeHost(rr.Value), ".") + "."
+	}
+	return target, best != ""
+}
+
+// nextHops returns the CNAME targets of name among records, synthesizing one from a
+// covering DNAME when the records hold no CNAME for name. viaDNAME reports whether a
+// DNAME covers name, whether or not the server already synthesized the CNAME.
+func nextHops(name string, records []ResourceRecord) (targets []string, viaDNAME bool) {
+	synthesized, viaDNAME := dnameTarget(name, records)
+	if targets = cnameTargets(name, records); len(targets) == 0 && viaDNAME {
+		targets = []string{synthesized}
+	}
+	return targets, viaDNAME
+}
+
+// terminalValues follows the CNAME (and DNAME) records from name to the end of the chain and returns
+// the values of the rrType records owned by that last name, separating the addresses a
+// chain ends in from its CNAME hops.
+func terminalValues(name string, records []ResourceRecord, rrType string) []string {
+	seen := map[string]bool{normalizeHost(name): true}
+	for {
+		targets, _ := nextHops(name, records)
+		if len(targets) == 0 || seen[normalizeHost(targets[0])] {
+			break
+		}
+		name = targets[0]
+		seen[normalizeHost(name)] = true
+	}
+
+	var values []string
+	for _, rr := range records {
+		if strings.EqualFold(rr.Type, rrType) && normalizeHost(rr.Name) == normalizeHost(name) {
+			values = append(values, rr.Value)
+		}
+	}
+	return values
+}
+
+// Resolver looks up CNAME records, and PTR records for IP address inputs. Each backend
+// (native, dig, replay) implements it. Lookups give up when ctx is done.
+// LookupAddrs resolves a name to its final A and AAAA addresses, following any CNAME
+// chain, and is used to tell dangling targets from live ones.
+type Resolver interface {
+	LookupCNAME(ctx context.Context, name string) (Answer, error)
+	LookupPTR(ctx context.Context, ip string) (Answer, error)
+	LookupAddrs(ctx context.Context, name string) (Answer, error)
+}
+
+// Resolver backends accepted by Options.Backend.
+const (
+	backendAuto   = "auto"
+	backendNative = "native"
+	backendDig    = "dig"
+	backendReplay = "replay"
+)
+
+// defaultQueryTimeout bounds backend probes and native queries whose context has no deadline.
+const defaultQueryTimeout = 5 * time.Second
+
+// nativeResolver queries a nameserver directly using the built-in DNS client.
+type nativeResolver struct {
+	server      string
+	noRecursion bool
+	// doh is set when server is a DNS-over-HTTPS URL, and dot when it is a tls:// server.
+	doh *dohClient
+	dot *dotClient
+	// trace logs every DNS message sent and received, for -trace-dns.
+	trace bool
+}
+
+// newNativeResolver returns a native resolver for server, or for the first nameserver in
+// /etc/resolv.conf when server is empty.
+func newNativeResolver(server string, noRecursion bool) (*nativeResolver, error) {
+	if server == "" {
+		var err error
+		server, err = systemNameserver("/etc/resolv.conf")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if isDoHServer(server) {
+		return &nativeResolver{server: server, noRecursion: noRecursion, doh: newDoHClient(server)}, nil
+	}
+	if isDoTServer(server) {
+		return &nativeResolver{server: server, noRecursion: noRecursion, dot: newDoTClient(server)}, nil
+	}
+	return &nativeResolver{
+		server:      withDefaultPort(server, "53"),
+		noRecursion: noRecursion,
+	}, nil
+}
+
+// traceMessages turns -trace-dns tracing of the resolver's messages on or off.
+func (r *nativeResolver) traceMessages(on bool) {
+	r.trace = on
+	if r.doh != nil {
+		r.doh.trace = on
+	}
+	if r.dot != nil {
+		r.dot.trace = on
+	}
+}
+
+// configure applies the settings of opts that are not part of the server address: the
+// DoH request limit, the DoT certificate pins and -trace-dns.
+func (r *nativeResolver) configure(opts Options) {
+	if r.doh != nil {
+		r.doh.limit(opts.DoHConcurrency)
+	}
+	if r.dot != nil {
+		r.dot.pins = opts.DoTPins
+	}
+	r.traceMessages(opts.TraceDNS)
+}
+
+// query sends a single question to the resolver's server.
+func (r *nativeResolver) query(ctx context.Context, name string, qtype uint16) (dnsMessage, error) {
+	query, err := buildQuery(newQueryID(), name, qtype, !r.noRecursion)
+	if err != nil {
+		return dnsMessage{}, err
+	}
+	if r.doh != nil {
+		return r.doh.exchange(ctx, query)
+	}
+	if r.dot != nil {
+		return r.dot.exchange(ctx, query)
+	}
+	return exchange(ctx, r.server, query, r.trace)
+}
+
+// LookupCNAME returns the CNAME records for name. NXDOMAIN and empty answers are not errors.
+func (r *nativeResolver) LookupCNAME(ctx context.Context, name string) (Answer, error) {
+	msg, err := r.query(ctx, name, typeCNAME)
+	if err != nil {
+		return Answer{}, fmt.Errorf("error querying %s for %s: %v", r.server, name, err)
+	}
+	if msg.RCode != rcodeSuccess && msg.RCode != rcodeNameError {
+		return Answer{}, &rcodeError{server: r.server, name: name, rcode: rcodeName(msg.RCode)}
+	}
+
+	return Answer{
+		CNAMEs:        cnameTargets(name, msg.Answers),
+		Authoritative: msg.Authoritative,
+		AnsweredBy:    r.server,
+		Records:       msg.Answers,
+		RCode:         rcodeName(msg.RCode),
+	}, nil
+}
+
+// LookupPTR returns the PTR records for an IP address. NXDOMAIN and empty answers are not errors.
+func (r *nativeResolver) LookupPTR(ctx context.Context, ip string) (Answer, error) {
+	name, err := reverseName(ip)
+	if err != nil {
+		return Answer{}, err
+	}
+
+	msg, err := r.query(ctx, name, typePTR)
+	if err != nil {
+		return Answer{}, fmt.Errorf("error querying %s for %s: %v", r.server, ip, err)
+	}
+	if msg.RCode != rcodeSuccess && msg.RCode != rcodeNameError {
+		return Answer{}, &rcodeError{server: r.server, name: ip, rcode: rcodeName(msg.RCode)}
+	}
+
+	answer := Answer{Authoritative: msg.Authoritative, AnsweredBy: r.server, Records: msg.Answers, RCode: rcodeName(msg.RCode)}
+	for _, rr := range msg.Answers {
+		if rr.Type == "PTR" {
+			answer.PTRs = append(answer.PTRs, rr.Value)
+		}
+	}
+	return answer, nil
+}
+
+// LookupAddrs returns the A addresses of name, or its AAAA addresses when it has no A
+// records. The server follows any CNAME chain. NXDOMAIN and empty answers are not errors.
+func (r *nativeResolver) LookupAddrs(ctx context.Context, name string) (Answer, error) {
+	answer := Answer{}
+	for _, qtype := range []uint16{typeA, typeAAAA} {
+		msg, err := r.query(ctx, name, qtype)
+		if err != nil {
+			return Answer{}, fmt.Errorf("error querying %s for %s: %v", r.server, name, err)
+		}
+		if msg.RCode != rcodeSuccess && msg.RCode != rcodeNameError {
+			return Answer{}, &rcodeError{server: r.server, name: name, rcode: rcodeName(msg.RCode)}
+		}
+
+		answer.Authoritative = msg.Authoritative
+		answer.AnsweredBy = r.server
+		answer.Records = msg.Answers
+		answer.NXDomain = msg.RCode == rcodeNameError
+		answer.RCode = rcodeName(msg.RCode)
+		answer.Authorities = msg.Authorities
+		for _, rr := range msg.Answers {
+			if rr.Type == "A" || rr.Type == "AAAA" {
+				answer.Addrs = append(answer.Addrs, rr.Value)
+			}
+		}
+		if len(answer.Addrs) > 0 || msg.RCode == rcodeNameError {
+			break
+		}
+	}
+	return answer, nil
+}
+
+// reverseName returns the in-addr.arpa or ip6.arpa name used to look up the PTR records of ip.
+func reverseName(ip string) (string, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return "", fmt.Errorf("invalid IP address %q", ip)
+	}
+
+	if v4 := addr.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", v4[3], v4[2], v4[1], v4[0]), nil
+	}
+
+	const hexDigits = "0123456789abcdef"
+	var b strings.Builder
+	for i := len(addr) - 1; i >= 0; i-- {
+		b.WriteByte(hexDigits[addr[i]&0x0F])
+		b.WriteByte('.')
+		b.WriteByte(hexDigits[addr[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa.")
+	return b.String(), nil
+}
+
+// probe checks that the resolver's server answers at all.
+func (r *nativeResolver) probe() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultQueryTimeout)
+	defer cancel()
+
+	_, err := r.query(ctx, ".", typeNS)
+	return err
+}
+
+// systemNameserver returns the first nameserver listed in a resolv.conf file.
+func systemNameserver(path string) (string, error) {
+	conf, err := parseResolvConf(path)
+	if err != nil {
+		return "", err
+	}
+	if len(conf.Nameservers) == 0 {
+		return "", fmt.Errorf("no nameserver found in %s", path)
+	}
+	return conf.Nameservers[0], nil
+}
+
+// withDefaultPort adds port to a host or IP address that does not already carry one.
+func withDefaultPort(server, port string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), port)
+}
+
+// selectResolver returns the resolver for opts.Backend and the name of the backend chosen.
+// In auto mode the native resolver is preferred, falling back to dig when the native
+// client cannot reach the configured server.
+func selectResolver(opts Options) (Resolver, string, error) {
+	dig := &digResolver{server: opts.Server, noRecursion: opts.NoRecursion, path: opts.DigPath, extraArgs: opts.DigArgs, verbose: opts.Verbose}
+	if dig.path == "" {
+		dig.path = defaultDigPath
+	} else if err := checkDig(dig.path); err != nil {
+		return nil, "", err // an explicit -dig-path is checked whichever backend runs
+	}
+
+	switch opts.Backend {
+	case backendDig:
+		if err := checkDig(dig.path); err != nil {
+			return nil, "", err
+		}
+		if isDoHServer(opts.Server) {
+			return nil, "", fmt.Errorf("the dig backend cannot query DNS-over-HTTPS server %s", opts.Server)
+		}
+		if isDoTServer(opts.Server) {
+			return nil, "", fmt.Errorf("the dig backend cannot query DNS-over-TLS server %s", opts.Server)
+		}
+		return dig, backendDig, nil
+	case backendReplay:
+		if opts.ReplayDir == "" {
+			return nil, "", fmt.Errorf("the replay backend needs a capture directory")
+		}
+		return &replayResolver{dir: opts.ReplayDir}, backendReplay, nil
+	case backendNative:
+		native, err := newNativeResolver(opts.Server, opts.NoRecursion)
+		if err != nil {
+			return nil, "", err
+		}
+		native.configure(opts)
+		return native, backendNative, nil
+	case backendAuto, "":
+	default:
+		return nil, "", fmt.Errorf("unknown resolver backend %q", opts.Backend)
+	}
+
+	native, err := newNativeResolver(opts.Server, opts.NoRecursion)
+	if err == nil {
+		native.configure(opts)
+		if err = native.probe(); err == nil {
+			return native, backendNative, nil
+		}
+	}
+	nativeErr := err
+	if isDoHServer(opts.Server) {
+		return nil, "", fmt.Errorf("DNS-over-HTTPS server unavailable: %v", nativeErr) // dig cannot stand in
+	}
+	if isDoTServer(opts.Server) {
+		return nil, "", fmt.Errorf("DNS-over-TLS server unavailable: %v", nativeErr)
+	}
+
+	if err := dig.probe(); err != nil {
+		return nil, "", fmt.Errorf("no working resolver backend: native: %v; dig: %v", nativeErr, err)
+	}
+	slog.Warn("Native resolver unavailable, falling back to dig", "backend", backendDig, "error", nativeErr)
+	return dig, backendDig, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Typed answers as the backends return them.
+var (
+	mixedChainRecords = []ResourceRecord{
+		{Name: "www.example.com.", Type: "CNAME", Value: "shop.example.net."},
+		{Name: "shop.example.net.", Type: "CNAME", Value: "edge.cdn.example.org."},
+		{Name: "edge.cdn.example.org.", Type: "A", Value: "192.0.2.10"},
+		{Name: "edge.cdn.example.org.", Type: "AAAA", Value: "2001:db8::10"},
+		// An address of an earlier hop is not where the chain ends
+		{Name: "shop.example.net.", Type: "A", Value: "192.0.2.99"},
+	}
+	loopRecords = []ResourceRecord{
+		{Name: "a.example.com.", Type: "CNAME", Value: "b.example.com."},
+		{Name: "b.example.com.", Type: "CNAME", Value: "a.example.com."},
+		{Name: "a.example.com.", Type: "A", Value: "192.0.2.1"},
+	}
+	dnameRecords = []ResourceRecord{
+		{Name: "old.example.com.", Type: "DNAME", Value: "new.example.net."},
+		{Name: "www.new.example.net.", Type: "A", Value: "192.0.2.20"},
+	}
+)
+
+func TestTerminalValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []ResourceRecord
+		rrType  string
+		want    []string
+	}{
+		{"www.example.com", mixedChainRecords, "A", []string{"192.0.2.10"}},
+		{"www.example.com", mixedChainRecords, "AAAA", []string{"2001:db8::10"}},
+		{"shop.example.net.", mixedChainRecords, "A", []string{"192.0.2.10"}},
+		{"edge.cdn.example.org", mixedChainRecords, "A", []string{"192.0.2.10"}},
+		// A loop ends at the last name before it repeats
+		{"a.example.com", loopRecords, "A", nil},
+		{"www.old.example.com", dnameRecords, "A", []string{"192.0.2.20"}},
+		{"unlisted.example.com", mixedChainRecords, "A", nil},
+	}
+	for _, tt := range tests {
+		if got := terminalValues(tt.name, tt.records, tt.rrType); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("terminalValues(%q, %s) = %q, want %q", tt.name, tt.rrType, got, tt.want)
+		}
+	}
+}
+
+func TestNextHops(t *testing.T) {
+	tests := []struct {
+		name      string
+		records   []ResourceRecord
+		want      []string
+		wantDNAME bool
+	}{
+		{"www.example.com", mixedChainRecords, []string{"shop.example.net."}, false},
+		{"edge.cdn.example.org", mixedChainRecords, nil, false},
+		// Only the DNAME: the CNAME it implies is synthesized
+		{"www.old.example.com", dnameRecords, []string{"www.new.example.net."}, true},
+		{"a.b.old.example.com.", dnameRecords, []string{"a.b.new.example.net."}, true},
+		// A DNAME redirects the names below its owner, not the owner itself
+		{"old.example.com", dnameRecords, nil, false},
+		// The server already synthesized the CNAME, which is taken as it is
+		{"www.old.example.com", append([]ResourceRecord{{Name: "www.old.example.com.", Type: "CNAME", Value: "www.new.example.net."}}, dnameRecords...), []string{"www.new.example.net."}, true},
+		// The closest DNAME wins
+		{"x.sub.old.example.com", append([]ResourceRecord{{Name: "sub.old.example.com.", Type: "DNAME", Value: "other.example.org."}}, dnameRecords...), []string{"x.other.example.org."}, true},
+	}
+	for _, tt := range tests {
+		got, viaDNAME := nextHops(tt.name, tt.records)
+		if !reflect.DeepEqual(got, tt.want) || viaDNAME != tt.wantDNAME {
+			t.Errorf("nextHops(%q) = %q, %v; want %q, %v", tt.name, got, viaDNAME, tt.want, tt.wantDNAME)
+		}
+	}
+}
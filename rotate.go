@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rotateTimeFormat stamps rotated result files with the time they were started.
+const rotateTimeFormat = "20060102T150405Z"
+
+// sizeUnits are the suffixes a -rotate size may carry, largest first so "MB" is not
+// read as "B".
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// rotatePolicy is the -rotate flag: start a new result file after size bytes or after
+// age, whichever is set.
+type rotatePolicy struct {
+	size int64
+	age  time.Duration
+	text string
+}
+
+func (p *rotatePolicy) String() string {
+	return p.text
+}
+
+// Set parses a duration such as 24h or a size such as 100MB (KB, MB and GB are powers
+// of 1024; a bare number is bytes).
+func (p *rotatePolicy) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if age, err := time.ParseDuration(value); err == nil {
+		if age <= 0 {
+			return errors.New("rotation interval must be positive")
+		}
+		*p = rotatePolicy{age: age, text: value}
+		return nil
+	}
+
+	number, unit := value, int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(value), u.suffix) {
+			number, unit = strings.TrimSpace(value[:len(value)-len(u.suffix)]), u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n <= 0 || n > (1<<62)/unit {
+		return fmt.Errorf("invalid rotation %q: want a size such as 100MB or a duration such as 24h", value)
+	}
+	*p = rotatePolicy{size: n * unit, text: value}
+	return nil
+}
+
+// enabled reports whether a rotation threshold was set.
+func (p *rotatePolicy) enabled() bool {
+	return p.size > 0 || p.age > 0
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	output io.Writer
+	n      int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.output.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// rotatingWriter writes records to the result file name until the policy's size or age
+// is reached, then closes it, renames it with the time it was started and carries on in
+// a new file of the same name. Rotation happens between records, so every file is
+// complete on its own: a JSON array is closed, CSV gets its header again, and encrypted
+// output is sealed. open stacks the output format (and encryption) on each new file.
+type rotatingWriter struct {
+	name   string
+	policy rotatePolicy
+	open   func(w io.Writer, continuing bool) (recordWriter, error)
+
+	file    *os.File
+	counter *countingWriter
+	next    recordWriter
+	started time.Time
+}
+
+// newRotatingWriter rotates the result file name, which is already open as file. The
+// first file is started with continuing, as for -append.
+func newRotatingWriter(name string, file *os.File, continuing bool, policy rotatePolicy, open func(io.Writer, bool) (recordWriter, error)) (*rotatingWriter, error) {
+	w := &rotatingWriter{name: name, policy: policy, open: open}
+	if err := w.start(file, continuing); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// start makes file the current output.
+func (w *rotatingWriter) start(file *os.File, continuing bool) error {
+	w.file = file
+	w.counter = &countingWriter{output: file}
+	if continuing {
+		if info, err := file.Stat(); err == nil {
+			w.counter.n = info.Size()
+		}
+	}
+	w.started = time.Now()
+	next, err := w.open(w.counter, continuing)
+	if err != nil {
+		return err
+	}
+	w.next = next
+	return nil
+}
+
+func (w *rotatingWriter) Write(record Record) error {
+	if w.due() {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	return w.next.Write(record)
+}
+
+func (w *rotatingWriter) Close() error {
+	err := w.next.Close()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// due reports whether the current file has reached the rotation threshold.
+func (w *rotatingWriter) due() bool {
+	return (w.policy.size > 0 && w.counter.n >= w.policy.size) ||
+		(w.policy.age > 0 && time.Since(w.started) >= w.policy.age)
+}
+
+// rotate finishes the current file, moves it aside and starts a new one.
+func (w *rotatingWriter) rotate() error {
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error closing %s for rotation: %v", w.name, err)
+	}
+	rotated := rotatedName(w.name, w.started)
+	if err := os.Rename(w.name, rotated); err != nil {
+		return fmt.Errorf("error rotating %s: %v", w.name, err)
+	}
+	file, err := os.Create(w.name)
+	if err != nil {
+		return fmt.Errorf("error creating file %s: %v", w.name, err)
+	}
+	return w.start(file, false)
+}
+
+// rotatedName returns the name a result file started at started is rotated to: the
+// timestamp goes before the extension (results-20240102T150405Z.jsonl), with a counter
+// added if that name is taken. A .gz suffix counts as part of the extension.
+func rotatedName(name string, started time.Time) string {
+	ext := filepath.Ext(name)
+	if ext == ".gz" {
+		ext = filepath.Ext(strings.TrimSuffix(name, ext)) + ext
+	}
+	base := strings.TrimSuffix(name, ext) + "-" + started.UTC().Format(rotateTimeFormat)
+	candidate := base + ext
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(candidate); errors.Is(err, os.ErrNotExist) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
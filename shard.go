@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// shard is one slice of the input for -shard M/N, so N nodes running the same command
+// with shards 1/N to N/N scan every host exactly once between them. A host belongs to
+// shard M when the 64-bit FNV-1a hash of its normalized name (lowercased, without
+// surrounding space or a trailing dot), modulo N, is M-1. The hash depends on nothing
+// but the name, so every node agrees without coordinating, whatever the order of its
+// input.
+type shard struct {
+	index int // 1-based
+	count int
+}
+
+// parseShard parses -shard, M/N with 1 <= M <= N.
+func parseShard(value string) (shard, error) {
+	m, n, ok := strings.Cut(value, "/")
+	if !ok {
+		return shard{}, fmt.Errorf("%q is not of the form M/N", value)
+	}
+	index, err := strconv.Atoi(strings.TrimSpace(m))
+	if err != nil {
+		return shard{}, fmt.Errorf("%q is not of the form M/N", value)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(n))
+	if err != nil {
+		return shard{}, fmt.Errorf("%q is not of the form M/N", value)
+	}
+	if count < 1 || index < 1 || index > count {
+		return shard{}, fmt.Errorf("shard %d/%d is out of range, M must be from 1 to N", index, count)
+	}
+	return shard{index: index, count: count}, nil
+}
+
+// owns reports whether subdomain falls in the shard.
+func (s shard) owns(subdomain string) bool {
+	h := fnv.New64a()
+	h.Write([]byte(normalizeHost(subdomain)))
+	return h.Sum64()%uint64(s.count) == uint64(s.index-1)
+}
+
+// filterShard returns the subdomains in s and the number left to other shards.
+func filterShard(subdomains []string, s shard) ([]string, int) {
+	kept := make([]string, 0, len(subdomains)/s.count+1)
+	for _, subdomain := range subdomains {
+		if s.owns(subdomain) {
+			kept = append(kept, subdomain)
+		}
+	}
+	return kept, len(subdomains) - len(kept)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// sqliteSchema creates the records table used by -sqlite. Each subdomain has one row
+// holding its most recent scan result. First_seen and last_seen are the first and the
+// latest scan that found the host vulnerable in its current run of vulnerable verdicts;
+// they are NULL for hosts never found vulnerable, and keep their values once a host turns
+// clean, until it is found vulnerable again.
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS records (
+	subdomain TEXT PRIMARY KEY,
+	cname TEXT NOT NULL,
+	vulnerable INTEGER NOT NULL,
+	matched_pattern TEXT NOT NULL,
+	scanned_at TEXT NOT NULL,
+	first_seen TEXT,
+	last_seen TEXT
+);
+`
+
+// sqliteAddedColumns are the columns added to the records table after its first
+// version, which databases written before then lack.
+var sqliteAddedColumns = []string{"first_seen TEXT", "last_seen TEXT"}
+
+// writeSQLite upserts the scan results into the records table of a SQLite database. Like
+// the dig backend it shells out to the sqlite3 command rather than linking a driver. Failed
+// lookups are skipped so they do not overwrite the last good result for a host.
+func writeSQLite(filename string, results map[string]Record, scannedAt time.Time) error {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return fmt.Errorf("sqlite3 not found; install it to use -sqlite: %v", err)
+	}
+
+	columns, err := sqliteColumns(filename)
+	if err != nil {
+		return err
+	}
+
+	var script strings.Builder
+	script.WriteString("BEGIN;\n")
+	script.WriteString(sqliteSchema)
+	if len(columns) > 0 {
+		for _, column := range sqliteAddedColumns {
+			if name, _, _ := strings.Cut(column, " "); !columns[name] {
+				fmt.Fprintf(&script, "ALTER TABLE records ADD COLUMN %s;\n", column)
+			}
+		}
+	}
+	timestamp := scannedAt.UTC().Format(time.RFC3339)
+	for _, record := range sortedBySubdomain(results) {
+		if record.Error != "" {
+			continue
+		}
+		vulnerable, seen := 0, "NULL"
+		if record.IsVulnerable {
+			vulnerable, seen = 1, sqlQuote(timestamp)
+		}
+		// In DO UPDATE, records holds the previous scan's row and excluded this scan's
+		fmt.Fprintf(&script, "INSERT INTO records (subdomain, cname, vulnerable, matched_pattern, scanned_at, first_seen, last_seen) VALUES (%s, %s, %d, %s, %s, %s, %s)"+
+			" ON CONFLICT(subdomain) DO UPDATE SET cname = excluded.cname, vulnerable = excluded.vulnerable,"+
+			" matched_pattern = excluded.matched_pattern, scanned_at = excluded.scanned_at,"+
+			" first_seen = CASE WHEN excluded.vulnerable = 0 OR (records.vulnerable = 1 AND records.first_seen IS NOT NULL) THEN records.first_seen ELSE excluded.first_seen END,"+
+			" last_seen = CASE WHEN excluded.vulnerable = 1 THEN excluded.last_seen ELSE records.last_seen END;\n",
+			sqlQuote(record.Subdomain), sqlQuote(recordAnswer(record)), vulnerable, sqlQuote(record.MatchedPattern), sqlQuote(timestamp), seen, seen)
+	}
+	script.WriteString("COMMIT;\n")
+
+	cmd := exec.Command("sqlite3", "-bail", filename)
+	cmd.Stdin = strings.NewReader(script.String())
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error writing SQLite database %s: %v: %s", filename, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// sqliteColumns returns the columns of the records table in the database, none when the
+// database or the table does not exist yet.
+func sqliteColumns(filename string) (map[string]bool, error) {
+	cmd := exec.Command("sqlite3", "-bail", filename, "SELECT name FROM pragma_table_info('records');")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error reading SQLite database %s: %v: %s", filename, err, strings.TrimSpace(stderr.String()))
+	}
+	columns := make(map[string]bool)
+	for _, name := range strings.Fields(stdout.String()) {
+		columns[name] = true
+	}
+	return columns, nil
+}
+
+// recordAnswer returns the answer stored for a record: its CNAME, or its PTR names for IP inputs.
+func recordAnswer(record Record) string {
+	if record.PTR != "" {
+		return record.PTR
+	}
+	return record.CNAME
+}
+
+// sqlQuote returns s as a single-quoted SQL string literal.
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
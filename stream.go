@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// stdioName is the file argument that stands for stdin (subdomains) or stdout (results).
+const stdioName = "-"
+
+// sendLines feeds lines into the returned channel, which is closed once every line has
+// been sent or ctx is done.
+func sendLines(ctx context.Context, lines []string) <-chan string {
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		for _, line := range lines {
+			select {
+			case out <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// concatLines feeds the lines of each channel in turn into the returned channel, which is
+// closed after the last one or once ctx is done.
+func concatLines(ctx context.Context, channels ...<-chan string) <-chan string {
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		for _, lines := range channels {
+			for line := range lines {
+				select {
+				case out <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// streamLines feeds the non-empty, trimmed lines of r into the returned channel as they
+// are read, so input of any length is processed with bounded memory. When transform is
+// set, each line is replaced by the names it returns, which may be none. The channel is closed at the end of r or once ctx is done;
+// the returned function, called after that, reports any read error. The name identifies
+// r in log and error messages.
+func streamLines(ctx context.Context, r io.Reader, name string, transform func(string) []string) (<-chan string, func() error) {
+	out := make(chan string)
+	var readErr error
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		defer close(out)
+
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+		scanner.Split(skipLongLines(name))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			names := []string{line}
+			if transform != nil {
+				names = transform(line)
+			}
+			for _, name := range names {
+				select {
+				case out <- name:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			readErr = fmt.Errorf("error reading file %s: %v", name, err)
+		}
+	}()
+
+	return out, func() error {
+		<-done
+		return readErr
+	}
+}
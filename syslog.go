@@ -0,0 +1,90 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/syslog"
+	"net/url"
+	"strings"
+)
+
+// syslogFacilities maps -syslog-facility names to facilities.
+var syslogFacilities = map[string]syslog.Priority{
+	"kern": syslog.LOG_KERN, "user": syslog.LOG_USER, "mail": syslog.LOG_MAIL,
+	"daemon": syslog.LOG_DAEMON, "auth": syslog.LOG_AUTH, "syslog": syslog.LOG_SYSLOG,
+	"lpr": syslog.LOG_LPR, "news": syslog.LOG_NEWS, "uucp": syslog.LOG_UUCP,
+	"cron": syslog.LOG_CRON, "authpriv": syslog.LOG_AUTHPRIV, "ftp": syslog.LOG_FTP,
+	"local0": syslog.LOG_LOCAL0, "local1": syslog.LOG_LOCAL1, "local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3, "local4": syslog.LOG_LOCAL4, "local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6, "local7": syslog.LOG_LOCAL7,
+}
+
+// syslogWriter sends each record to syslog as one JSON message: vulnerable records at
+// warning severity, failed lookups at error and everything else at info.
+type syslogWriter struct {
+	writer *syslog.Writer
+	json   *jsonWriter
+	buf    bytes.Buffer
+}
+
+// newSyslogWriter connects to the local syslog daemon, or to addr when it is set
+// ("udp://host:514", "tcp://host:514", or "host:514" for UDP), logging with the given
+// facility and tag.
+func newSyslogWriter(addr, facility, tag string, fields []recordField) (recordWriter, error) {
+	priority, ok := syslogFacilities[strings.ToLower(facility)]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+	network, raddr, err := parseSyslogAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := syslog.Dial(network, raddr, priority|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	w := &syslogWriter{writer: writer}
+	w.json = &jsonWriter{output: &w.buf, fields: fields}
+	return w, nil
+}
+
+// parseSyslogAddr splits a -syslog-addr value into the network and address for
+// syslog.Dial. An empty addr means the local daemon.
+func parseSyslogAddr(addr string) (string, string, error) {
+	if addr == "" {
+		return "", "", nil
+	}
+	if !strings.Contains(addr, "://") {
+		return "udp", addr, nil
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid syslog address %q: %v", addr, err)
+	}
+	if (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+		return "", "", fmt.Errorf("invalid syslog address %q: want udp://host:port or tcp://host:port", addr)
+	}
+	return u.Scheme, u.Host, nil
+}
+
+func (w *syslogWriter) Write(record Record) error {
+	w.buf.Reset()
+	if err := w.json.Write(record); err != nil {
+		return err
+	}
+	message := strings.TrimSuffix(w.buf.String(), "\n")
+	switch {
+	case record.IsVulnerable:
+		return w.writer.Warning(message)
+	case record.Error != "":
+		return w.writer.Err(message)
+	default:
+		return w.writer.Info(message)
+	}
+}
+
+func (w *syslogWriter) Close() error {
+	return w.writer.Close()
+}
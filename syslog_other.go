@@ -0,0 +1,11 @@
+//go:build windows || plan9
+
+package main
+
+import "errors"
+
+// newSyslogWriter reports that syslog output is unavailable: log/syslog does not exist
+// on this platform.
+func newSyslogWriter(addr, facility, tag string, fields []recordField) (recordWriter, error) {
+	return nil, errors.New("syslog output is not supported on this platform")
+}
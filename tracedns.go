@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// traceMessage logs a DNS message sent to or received from server for -trace-dns, as hex
+// and decoded, at debug level. The decoding is done here rather than taken from the
+// parsed response, so it shows what the bytes hold even when parseMessage rejects them.
+func traceMessage(msg, server string, data []byte) {
+	slog.Debug(msg, "server", server, "bytes", len(data), "hex", hex.EncodeToString(data), "decoded", describeMessage(data))
+}
+
+// describeMessage renders a DNS message on one line, dig-like: the header, the question
+// and each section's records. A message that does not decode is rendered up to the
+// point of failure, followed by the error.
+func describeMessage(data []byte) string {
+	if len(data) < 12 {
+		return fmt.Sprintf("%v (%d bytes)", errShortMessage, len(data))
+	}
+	var b strings.Builder
+	flags := binary.BigEndian.Uint16(data[2:])
+	kind := "query"
+	if flags&0x8000 != 0 {
+		kind = "response"
+	}
+	fmt.Fprintf(&b, "%s id %d opcode %d rcode %s flags", kind, binary.BigEndian.Uint16(data), flags>>11&0xF, rcodeName(int(flags&0xF)))
+	for _, flag := range []struct {
+		bit  uint16
+		name string
+	}{{0x0400, "aa"}, {0x0200, "tc"}, {0x0100, "rd"}, {0x0080, "ra"}, {0x0020, "ad"}, {0x0010, "cd"}} {
+		if flags&flag.bit != 0 {
+			b.WriteString(" " + flag.name)
+		}
+	}
+
+	off := 12
+	for i := 0; i < int(binary.BigEndian.Uint16(data[4:])); i++ {
+		name, next, err := decodeName(data, off)
+		if err == nil && next+4 > len(data) {
+			err = errShortMessage
+		}
+		if err != nil {
+			fmt.Fprintf(&b, "; question: %v", err)
+			return b.String()
+		}
+		fmt.Fprintf(&b, "; question %s %s", name, typeName(binary.BigEndian.Uint16(data[next:])))
+		off = next + 4
+	}
+
+	sections := []string{"answer", "authority", "additional"}
+	for i, section := range sections {
+		records, next, err := parseRecords(data, off, int(binary.BigEndian.Uint16(data[6+2*i:])))
+		if err != nil {
+			fmt.Fprintf(&b, "; %s: %v", section, err)
+			return b.String()
+		}
+		for _, rr := range records {
+			fmt.Fprintf(&b, "; %s %s %d %s %s", section, rr.Name, rr.TTL, rr.Type, rr.Value)
+		}
+		off = next
+	}
+	if off < len(data) {
+		fmt.Fprintf(&b, "; %d trailing bytes", len(data)-off)
+	}
+	return b.String()
+}
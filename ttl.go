@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TTL warnings set in Record.TTLWarning.
+const (
+	ttlWarnLong  = "long"
+	ttlWarnShort = "short"
+)
+
+// ttlThresholds is the -ttl-warn flag: vulnerable records whose TTL is above long are
+// slow to clear from caches once fixed, and those below short may be churned by
+// automation, so both are flagged. Either bound may be unset.
+type ttlThresholds struct {
+	short time.Duration
+	long  time.Duration
+	text  string
+}
+
+func (t *ttlThresholds) String() string {
+	return t.text
+}
+
+// Set parses "long", such as 24h, or "short,long", such as 30s,24h.
+func (t *ttlThresholds) Set(value string) error {
+	shortText, longText, hasShort := strings.Cut(strings.TrimSpace(value), ",")
+	if !hasShort {
+		shortText, longText = "", shortText
+	}
+	parse := func(text string) (time.Duration, error) {
+		if text = strings.TrimSpace(text); text == "" {
+			return 0, nil
+		}
+		d, err := time.ParseDuration(text)
+		if err != nil || d < time.Second {
+			return 0, fmt.Errorf("invalid TTL %q: want a duration of at least 1s", text)
+		}
+		return d, nil
+	}
+
+	short, err := parse(shortText)
+	if err != nil {
+		return err
+	}
+	long, err := parse(longText)
+	if err != nil {
+		return err
+	}
+	switch {
+	case short == 0 && long == 0:
+		return errors.New("want a long TTL such as 24h, or short,long such as 30s,24h")
+	case long != 0 && short >= long:
+		return fmt.Errorf("short TTL %v is not below long TTL %v", short, long)
+	}
+	*t = ttlThresholds{short: short, long: long, text: value}
+	return nil
+}
+
+// check returns the warning for a record with ttl seconds, or "" when it is within the
+// thresholds or unknown (zero).
+func (t ttlThresholds) check(ttl uint32) string {
+	d := time.Duration(ttl) * time.Second
+	switch {
+	case ttl == 0:
+		return ""
+	case t.long > 0 && d > t.long:
+		return ttlWarnLong
+	case t.short > 0 && d < t.short:
+		return ttlWarnShort
+	}
+	return ""
+}
+
+// answerTTL returns the TTL of name's first rrType record among records, or zero when
+// there is none or the backend did not report TTLs.
+func answerTTL(name, rrType string, records []ResourceRecord) uint32 {
+	for _, rr := range records {
+		if rr.Type == rrType && normalizeHost(rr.Name) == normalizeHost(name) {
+			return rr.TTL
+		}
+	}
+	return 0
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// regexMetacharacters are characters that suggest a pattern was written as a regular
+// expression. Patterns are matched as plain substrings, so these only ever match literally.
+const regexMetacharacters = `^$+?()[]{}|\`
+
+// validatePatterns checks a pattern set for mistakes that would silently weaken a scan and
+// returns one message per problem found: an empty set, empty patterns, patterns that look
+// like regular expressions, duplicates, and patterns that another pattern makes redundant.
+//
+// Under substring matching a pattern is redundant when a shorter pattern it contains (such
+// as amazonaws.com for s3.amazonaws.com) is at least as severe: the shorter pattern always
+// matches first, so the longer one can never be reported.
+func validatePatterns(patterns []Pattern) []string {
+	if len(patterns) == 0 {
+		return []string{"no patterns loaded"}
+	}
+
+	var problems []string
+	seen := make(map[string]Pattern, len(patterns))
+	for _, pattern := range patterns {
+		value := pattern.Value
+		switch {
+		case strings.TrimSpace(value) == "":
+			problems = append(problems, "empty pattern matches every CNAME")
+			continue
+		case pattern.expr == nil && strings.ContainsAny(strings.TrimPrefix(value, "*."), regexMetacharacters+"*"):
+			problems = append(problems, fmt.Sprintf("pattern %q looks like a regular expression, but patterns are matched as plain substrings", value))
+		}
+
+		key := strings.ToLower(value)
+		if first, ok := seen[key]; ok {
+			problems = append(problems, fmt.Sprintf("duplicate pattern %q (severities %s and %s)", value, first.Severity, pattern.Severity))
+			continue
+		}
+		seen[key] = pattern
+	}
+
+	// Containment says nothing about what a match expression matches
+	for _, pattern := range patterns {
+		if pattern.expr != nil {
+			continue
+		}
+		for _, other := range patterns {
+			if other.Value == "" || other.expr != nil || len(other.Value) >= len(pattern.Value) {
+				continue
+			}
+			if strings.Contains(strings.ToLower(pattern.Value), strings.ToLower(other.Value)) && other.Severity >= pattern.Severity {
+				problems = append(problems, fmt.Sprintf("pattern %q is redundant: %q (%s) already matches everything it does", pattern.Value, other.Value, other.Severity))
+				break
+			}
+		}
+	}
+	return problems
+}
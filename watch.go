@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Changes a changeTracker reports, in Record.Change.
+const (
+	changeNewVulnerable = "new-vulnerable"
+	changeVulnerable    = "clean-to-vulnerable"
+	changeClean         = "vulnerable-to-clean"
+	changeCNAME         = "cname-changed"
+	changeSeverity      = "severity-increased"
+)
+
+// hostState is what a changeTracker remembers about a host between scans.
+type hostState struct {
+	Vulnerable bool     `json:"vulnerable"`
+	CNAME      string   `json:"cname"`
+	Severity   Severity `json:"severity"`
+}
+
+// changeTracker remembers the verdict and CNAME of every host from one scan to the next,
+// in memory and optionally in a JSON state file, so -only-changed can report transitions.
+type changeTracker struct {
+	stateFile string
+
+	mu     sync.Mutex
+	states map[string]hostState
+}
+
+// newChangeTracker loads the state file if one is given and exists.
+func newChangeTracker(stateFile string) (*changeTracker, error) {
+	t := &changeTracker{stateFile: stateFile, states: make(map[string]hostState)}
+	if stateFile == "" {
+		return t, nil
+	}
+
+	data, err := os.ReadFile(stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening file %s: %v", stateFile, err)
+	}
+	if err := json.Unmarshal(data, &t.states); err != nil {
+		return nil, fmt.Errorf("error reading state file %s: %v", stateFile, err)
+	}
+	return t, nil
+}
+
+// observe records the state of a host and returns how it changed since it was last seen,
+// or "" when it did not, along with its state before. A host seen for the first time is
+// only a change when it is vulnerable. Failed lookups say nothing about the host and
+// leave its state alone. A vulnerable host whose severity rose changed even if its CNAME
+// did not; state files written before severities were kept have none to compare.
+func (t *changeTracker) observe(record Record) (string, hostState) {
+	if record.Error != "" {
+		return "", hostState{}
+	}
+	key := normalizeHost(record.Subdomain)
+	state := hostState{Vulnerable: record.IsVulnerable, CNAME: record.CNAME, Severity: record.Severity}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	previous, seen := t.states[key]
+	t.states[key] = state
+
+	switch {
+	case !seen && state.Vulnerable:
+		return changeNewVulnerable, previous
+	case !seen:
+		return "", previous
+	case state.Vulnerable && !previous.Vulnerable:
+		return changeVulnerable, previous
+	case !state.Vulnerable && previous.Vulnerable:
+		return changeClean, previous
+	case state.Vulnerable && previous.Severity != SeverityNone && state.Severity > previous.Severity:
+		return changeSeverity, previous
+	case state.CNAME != previous.CNAME:
+		return changeCNAME, previous
+	}
+	return "", previous
+}
+
+// save writes the state file, if there is one.
+func (t *changeTracker) save() error {
+	if t.stateFile == "" {
+		return nil
+	}
+	t.mu.Lock()
+	data, err := json.Marshal(t.states)
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(t.stateFile, data); err != nil {
+		return fmt.Errorf("error writing state file %s: %v", t.stateFile, err)
+	}
+	return nil
+}
+
+// changeFilter passes on only the records whose host changed, with Change set to how
+// and the Baseline fields to the host's earlier state. With alertSeverity set, for
+// -compare-baseline-severity, it passes on only changes for the worse, as worsened
+// decides, and logs the others.
+type changeFilter struct {
+	next          recordWriter
+	tracker       *changeTracker
+	alertSeverity Severity
+}
+
+func (w *changeFilter) Write(record Record) error {
+	var previous hostState
+	if record.Change, previous = w.tracker.observe(record); record.Change == "" {
+		return nil
+	}
+	if w.alertSeverity != SeverityNone && !worsened(record, w.alertSeverity) {
+		slog.Info("Host changed without worsening", "host", record.Subdomain, "change", record.Change, "severity", record.Severity, "baseline_severity", previous.Severity)
+		return nil
+	}
+	record.BaselineCNAME, record.BaselineVulnerable, record.BaselineSeverity = previous.CNAME, previous.Vulnerable, previous.Severity
+	return w.next.Write(record)
+}
+
+// worsened reports whether the change of record is one to alert on: a vulnerable host
+// whose severity rose, or a host newly vulnerable at alertSeverity or above. A finding
+// below it that comes and goes between scans is only logged, and so is a host turning
+// clean or changing CNAME without getting more severe.
+func worsened(record Record, alertSeverity Severity) bool {
+	switch record.Change {
+	case changeSeverity:
+		return true
+	case changeNewVulnerable, changeVulnerable:
+		return record.Severity >= alertSeverity
+	}
+	return false
+}
+
+func (w *changeFilter) Close() error {
+	return w.next.Close()
+}
+
+// watchScan scans subdomains every interval until ctx ends or the process is interrupted,
+// writing each scan's records to output. resolver must look up through cache, which is
+// emptied before every scan so it sees current answers. After each scan the tracker's
+// state file, if any, is saved.
+func watchScan(ctx context.Context, subdomains []string, patterns []Pattern, resolver Resolver, cache *cachingResolver, opts Options, output recordWriter, tracker *changeTracker, interval time.Duration) {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	opts.DiscardResults = true
+
+	for cycle := 1; ; cycle++ {
+		cache.reset()
+		_, _, err := checkCNAMERecords(ctx, sendLines(ctx, subdomains), len(subdomains), patterns, resolver, opts, output)
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("Scan aborted early", "scan", cycle, "error", err)
+		}
+		if opts.Verbose {
+			logCacheStats(cache)
+		}
+		if tracker != nil {
+			if err := tracker.save(); err != nil {
+				slog.Error("Failed to save watch state", "error", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Info("Stopped watching", "scans", cycle)
+			return
+		case <-time.After(interval):
+		}
+	}
+}